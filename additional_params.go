@@ -0,0 +1,106 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"sort"
+	"strings"
+)
+
+// postgresKnownAdditionalParams contains connection parameters that are recognized by both Postgres drivers,
+// and the most common server runtime parameters that may be set on connecting.
+var postgresKnownAdditionalParams = []string{
+	"application_name", "fallback_application_name", "connect_timeout", "options", "target_session_attrs",
+	"sslmode", "sslcert", "sslkey", "sslrootcert", "sslpassword", "sslsni", "krbsrvname", "krbspn",
+	"search_path", "client_encoding", "datestyle", "timezone", "extra_float_digits",
+	"statement_timeout", "lock_timeout", "idle_in_transaction_session_timeout",
+	"default_transaction_isolation", "default_transaction_read_only", "work_mem",
+}
+
+// knownAdditionalParams contains additional parameters that are recognized for each dialect (see Config.UnknownAdditionalParameters).
+var knownAdditionalParams = map[Dialect][]string{
+	DialectPostgres: append([]string{
+		"binary_parameters", "disable_prepared_binary_result", "sslinline", "passfile",
+	}, postgresKnownAdditionalParams...),
+	DialectPgx: append([]string{
+		"default_query_exec_mode", "statement_cache_capacity", "description_cache_capacity", "min_read_buffer_size",
+		"passfile", "service", "servicefile",
+	}, postgresKnownAdditionalParams...),
+	DialectSQLite: {
+		"mode", "cache", "immutable", "vfs", "nolock", "psow",
+		"_loc", "_mutex", "_txlock", "_busy_timeout", "_timeout", "_foreign_keys", "_fk", "_journal_mode", "_journal",
+		"_synchronous", "_sync", "_auto_vacuum", "_vacuum", "_case_sensitive_like", "_cslike",
+		"_defer_foreign_keys", "_defer_fk", "_ignore_check_constraints", "_locking_mode", "_locking",
+		"_query_only", "_recursive_triggers", "_rt", "_secure_delete", "_writable_schema", "_cache_size",
+		"_auth", "_auth_user", "_auth_pass", "_auth_crypt", "_auth_salt",
+	},
+	// MSSQL parameter names are case-insensitive.
+	DialectMSSQL: {
+		"encrypt", "trustservercertificate", "certificate", "hostnameincertificate", "tlsmin", "serverspn",
+		"workstation id", "app name", "applicationintent", "failoverpartner", "failoverport", "packet size", "log",
+		"connection timeout", "dial timeout", "keepalive", "multisubnetfailover", "protocol", "disableretry",
+		"columnencryption", "fedauth",
+	},
+}
+
+// WithStrictAdditionalParameters returns a ConfigOption that makes config loading fail
+// if additional parameters contain keys that are not recognized for the dialect (see Config.UnknownAdditionalParameters).
+// It should not be used if some exotic driver or server parameters are passed.
+func WithStrictAdditionalParameters() ConfigOption {
+	return func(o *configOptions) {
+		o.strictAdditionalParams = true
+	}
+}
+
+// UnknownAdditionalParameters returns sorted keys of the additional parameters of the configured dialect
+// that are not recognized as driver parameters (e.g. "sslmde" typo instead of "sslmode").
+// Such parameters are still passed into the DSN, so the result is intended to be logged as a warning at startup.
+// The list of recognized parameters is not exhaustive, Postgres drivers pass all unknown parameters
+// to the server as runtime parameters, so only the most common of them are recognized.
+func (c *Config) UnknownAdditionalParameters() []string {
+	var params map[string]string
+	switch c.Dialect {
+	case DialectPostgres, DialectPgx:
+		params = c.Postgres.AdditionalParameters
+	case DialectSQLite:
+		params = c.SQLite.AdditionalParameters
+	case DialectMSSQL:
+		params = c.MSSQL.AdditionalParameters
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	known := make(map[string]struct{}, len(knownAdditionalParams[c.Dialect]))
+	for _, param := range knownAdditionalParams[c.Dialect] {
+		known[param] = struct{}{}
+	}
+	var unknown []string
+	for key := range params {
+		normalizedKey := key
+		if c.Dialect == DialectMSSQL {
+			normalizedKey = strings.ToLower(key)
+		}
+		if _, ok := known[normalizedKey]; !ok {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func (c *Config) additionalParamsKey() string {
+	switch c.Dialect {
+	case DialectPostgres, DialectPgx:
+		return cfgKeyPostgresAdditionalParams
+	case DialectSQLite:
+		return cfgKeySQLiteAdditionalParams
+	case DialectMSSQL:
+		return cfgKeyMSSQLAdditionalParams
+	default:
+		return ""
+	}
+}
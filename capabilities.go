@@ -0,0 +1,105 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import "runtime/debug"
+
+// Capabilities describes what the SQL dialect and its driver support.
+// It may be logged for diagnostics or used for choosing code paths that differ per dialect.
+type Capabilities struct {
+	Dialect       Dialect
+	DriverName    string // Name of the registered database/sql driver (e.g. "pgx").
+	DriverModule  string // Go module of the driver (e.g. "github.com/jackc/pgx/v5").
+	DriverVersion string // Version of the driver module from the build info, empty if it's unknown.
+
+	MultiStatements        bool // Several statements may be executed in a single Exec call (MySQL DSN enables it).
+	ReturningClause        bool // INSERT returns values of the inserted row (RETURNING or OUTPUT for MSSQL).
+	LastInsertID           bool // sql.Result.LastInsertId is supported.
+	CreateTableIfNotExists bool // CREATE TABLE IF NOT EXISTS is supported.
+	CreateIndexIfNotExists bool // CREATE INDEX IF NOT EXISTS is supported.
+	ConcurrentIndex        bool // CREATE INDEX CONCURRENTLY is supported.
+	TransactionalDDL       bool // DDL statements are transactional (no implicit commit).
+	CopyFrom               bool // COPY FROM STDIN is supported via database/sql (lib/pq's CopyIn).
+	StatementTimeout       bool // Server-side statement timeout is supported (see WithStatementTimeout).
+}
+
+// driverModules contains Go modules of the drivers that are used for each dialect.
+var driverModules = map[Dialect]string{
+	DialectSQLite:   "github.com/mattn/go-sqlite3",
+	DialectMySQL:    "github.com/go-sql-driver/mysql",
+	DialectPostgres: "github.com/lib/pq",
+	DialectPgx:      "github.com/jackc/pgx/v5",
+	DialectMSSQL:    "github.com/microsoft/go-mssqldb",
+}
+
+// DialectCapabilities returns capabilities of the passed dialect and its driver.
+// Zero value (except Dialect) is returned for unknown dialect.
+func DialectCapabilities(dialect Dialect) Capabilities {
+	caps := Capabilities{Dialect: dialect, DriverName: driverNames[dialect], DriverModule: driverModules[dialect]}
+	if caps.DriverModule != "" {
+		caps.DriverVersion = moduleVersion(caps.DriverModule)
+	}
+	switch dialect {
+	case DialectPostgres, DialectPgx:
+		caps.MultiStatements = true // Statements without arguments are sent via the simple query protocol.
+		caps.ReturningClause = true
+		caps.CreateTableIfNotExists = true
+		caps.CreateIndexIfNotExists = true
+		caps.ConcurrentIndex = true
+		caps.TransactionalDDL = true
+		caps.CopyFrom = dialect == DialectPostgres
+		caps.StatementTimeout = true
+	case DialectMySQL:
+		caps.MultiStatements = true
+		caps.LastInsertID = true
+		caps.CreateTableIfNotExists = true
+		caps.StatementTimeout = true
+	case DialectSQLite:
+		caps.MultiStatements = true
+		caps.ReturningClause = true // Since SQLite 3.35 that is bundled with the driver.
+		caps.LastInsertID = true
+		caps.CreateTableIfNotExists = true
+		caps.CreateIndexIfNotExists = true
+		caps.TransactionalDDL = true
+	case DialectMSSQL:
+		caps.MultiStatements = true
+		caps.ReturningClause = true
+		caps.TransactionalDDL = true
+	}
+	return caps
+}
+
+// DriverCapabilities returns capabilities of the configured dialect and its driver (see DialectCapabilities).
+func (c *Config) DriverCapabilities() Capabilities {
+	return DialectCapabilities(c.Dialect)
+}
+
+// driverNames contains names of the database/sql drivers that are used for each dialect (see Config.DriverNameAndDSN).
+var driverNames = map[Dialect]string{
+	DialectSQLite:   "sqlite3",
+	DialectMySQL:    "mysql",
+	DialectPostgres: "postgres",
+	DialectPgx:      "pgx",
+	DialectMSSQL:    "mssql",
+}
+
+// moduleVersion returns the version of the module that the binary is built with, empty string if it's unknown.
+func moduleVersion(modulePath string) string {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	for _, dep := range buildInfo.Deps {
+		if dep.Path == modulePath {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+	return ""
+}
@@ -0,0 +1,35 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_DriverCapabilities(t *testing.T) {
+	for _, dialect := range []Dialect{DialectSQLite, DialectMySQL, DialectPostgres, DialectPgx, DialectMSSQL} {
+		t.Run(string(dialect), func(t *testing.T) {
+			cfg := &Config{Dialect: dialect}
+			caps := cfg.DriverCapabilities()
+			driverName, _ := cfg.DriverNameAndDSN()
+			require.Equal(t, dialect, caps.Dialect)
+			require.Equal(t, driverName, caps.DriverName)
+			require.NotEmpty(t, caps.DriverModule)
+			require.True(t, caps.MultiStatements)
+			require.Equal(t, dialect == DialectPostgres, caps.CopyFrom)
+			require.Equal(t, dialect != DialectMSSQL, caps.CreateTableIfNotExists)
+			require.Equal(t, dialect == DialectMySQL || dialect == DialectSQLite, caps.LastInsertID)
+		})
+	}
+
+	// SQLite driver is linked into the test binary, so its version is known from the build info.
+	require.NotEmpty(t, DialectCapabilities(DialectSQLite).DriverVersion)
+
+	require.Equal(t, Capabilities{Dialect: "unknown"}, DialectCapabilities("unknown"))
+}
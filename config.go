@@ -25,6 +25,10 @@ const (
 	cfgKeyMaxOpenConns    = "maxOpenConns"
 	cfgKeyConnMaxLifetime = "connMaxLifeTime"
 
+	cfgKeyConnectionInitSQL     = "connectionInitSQL"
+	cfgKeyConnectionInitRetries = "connectionInitRetries"
+	cfgKeyRequireTLS            = "requireTLS"
+
 	cfgKeyMySQLHost     = "mysql.host"
 	cfgKeyMySQLPort     = "mysql.port"
 	cfgKeyMySQLDatabase = "mysql.database"
@@ -32,7 +36,12 @@ const (
 	cfgKeyMySQLPassword = "mysql.password" //nolint:gosec // Not a hardcoded password, just a config key
 	cfgKeyMySQLTxLevel  = "mysql.txLevel"
 
-	cfgKeySQLitePath = "sqlite3.path"
+	cfgKeyMySQLSessionTxLevel = "mysql.sessionTxLevel"
+	cfgKeyMySQLTLS            = "mysql.tls"
+	cfgKeyMySQLConnAttrs      = "mysql.connectionAttributes"
+
+	cfgKeySQLitePath             = "sqlite3.path"
+	cfgKeySQLiteAdditionalParams = "sqlite3.additionalParameters"
 
 	cfgKeyPostgresHost             = "postgres.host"
 	cfgKeyPostgresPort             = "postgres.port"
@@ -43,6 +52,8 @@ const (
 	cfgKeyPostgresSSLMode          = "postgres.sslMode"
 	cfgKeyPostgresSearchPath       = "postgres.searchPath"
 	cfgKeyPostgresAdditionalParams = "postgres.additionalParameters"
+	cfgKeyPostgresPgxQueryExecMode = "postgres.pgxQueryExecMode"
+	cfgKeyPostgresSessionTxLevel   = "postgres.sessionTxLevel"
 	cfgKeyMSSQLHost                = "mssql.host"
 	cfgKeyMSSQLPort                = "mssql.port"
 	cfgKeyMSSQLDatabase            = "mssql.database"
@@ -63,8 +74,21 @@ type Config struct {
 	SQLite          SQLiteConfig        `mapstructure:"sqlite3" yaml:"sqlite3" json:"sqlite3"`
 	Postgres        PostgresConfig      `mapstructure:"postgres" yaml:"postgres" json:"postgres"`
 
-	keyPrefix         string
-	supportedDialects []Dialect
+	// ConnectionInitSQL contains SQL statements (e.g. "SET statement_timeout = 1000", "SET time_zone = '+00:00'")
+	// that are executed once for each newly established connection in the pool. Used by Open.
+	ConnectionInitSQL []string `mapstructure:"connectionInitSQL" yaml:"connectionInitSQL" json:"connectionInitSQL"`
+
+	// ConnectionInitRetries is the number of times a new connection is re-established
+	// if ConnectionInitSQL fails on it (e.g. because of the transient timeout). The failed connection is always closed,
+	// so it never gets into the pool. 0 means no retries (database/sql may still retry on its own).
+	ConnectionInitRetries int `mapstructure:"connectionInitRetries" yaml:"connectionInitRetries" json:"connectionInitRetries"`
+
+	// RequireTLS makes Open refuse to connect if the connection is not encrypted (see Config.ValidateTLS).
+	RequireTLS bool `mapstructure:"requireTLS" yaml:"requireTLS" json:"requireTLS"`
+
+	keyPrefix              string
+	supportedDialects      []Dialect
+	strictAdditionalParams bool
 }
 
 var _ config.Config = (*Config)(nil)
@@ -74,7 +98,8 @@ var _ config.KeyPrefixProvider = (*Config)(nil)
 type ConfigOption func(*configOptions)
 
 type configOptions struct {
-	keyPrefix string
+	keyPrefix              string
+	strictAdditionalParams bool
 }
 
 // WithKeyPrefix returns a ConfigOption that sets a key prefix for parsing configuration parameters.
@@ -91,7 +116,7 @@ func NewConfig(supportedDialects []Dialect, options ...ConfigOption) *Config {
 	for _, opt := range options {
 		opt(&opts)
 	}
-	return &Config{supportedDialects: supportedDialects, keyPrefix: opts.keyPrefix}
+	return &Config{supportedDialects: supportedDialects, keyPrefix: opts.keyPrefix, strictAdditionalParams: opts.strictAdditionalParams}
 }
 
 // NewConfigWithKeyPrefix creates a new instance of the Config with a key prefix.
@@ -112,11 +137,12 @@ func NewDefaultConfig(supportedDialects []Dialect, options ...ConfigOption) *Con
 		opt(&opts)
 	}
 	return &Config{
-		keyPrefix:         opts.keyPrefix,
-		supportedDialects: supportedDialects,
-		MaxOpenConns:      DefaultMaxOpenConns,
-		MaxIdleConns:      DefaultMaxIdleConns,
-		ConnMaxLifetime:   config.TimeDuration(DefaultConnMaxLifetime),
+		keyPrefix:              opts.keyPrefix,
+		supportedDialects:      supportedDialects,
+		strictAdditionalParams: opts.strictAdditionalParams,
+		MaxOpenConns:           DefaultMaxOpenConns,
+		MaxIdleConns:           DefaultMaxIdleConns,
+		ConnMaxLifetime:        config.TimeDuration(DefaultConnMaxLifetime),
 		MySQL: MySQLConfig{
 			TxIsolationLevel: IsolationLevel(MySQLDefaultTxLevel),
 		},
@@ -166,6 +192,19 @@ type MySQLConfig struct {
 	Password         string         `mapstructure:"password" yaml:"password" json:"password"`
 	Database         string         `mapstructure:"database" yaml:"database" json:"database"`
 	TxIsolationLevel IsolationLevel `mapstructure:"txLevel" yaml:"txLevel" json:"txLevel"`
+
+	// SessionTxLevel enables encoding TxIsolationLevel into the DSN (transaction_isolation session variable),
+	// so all connections in the pool use it by default. Isolation level passed in sql.TxOptions still takes precedence.
+	SessionTxLevel bool `mapstructure:"sessionTxLevel" yaml:"sessionTxLevel" json:"sessionTxLevel"`
+
+	// TLS is a value of the tls DSN parameter: "true", "false", "skip-verify", "preferred"
+	// or a name of the custom TLS config registered via mysql.RegisterTLSConfig.
+	TLS string `mapstructure:"tls" yaml:"tls" json:"tls"`
+
+	// ConnectionAttributes are sent to the server on connecting (connectionAttributes DSN parameter),
+	// they are visible in performance_schema.session_connect_attrs (e.g. program_name: my-service).
+	// It allows identifying which service owns each connection. Keys and values must not contain ',' and ':'.
+	ConnectionAttributes map[string]string `mapstructure:"connectionAttributes" yaml:"connectionAttributes" json:"connectionAttributes"`
 }
 
 // MSSQLConfig represents a set of configuration parameters for working with MSSQL.
@@ -180,8 +219,11 @@ type MSSQLConfig struct {
 }
 
 // SQLiteConfig represents a set of configuration parameters for working with SQLite.
+// AdditionalParameters are passed as URI parameters (e.g. cache=shared), so path "file::memory:" with
+// "cache: shared" parameter may be used for an in-memory database that is shared across connections.
 type SQLiteConfig struct {
-	Path string `mapstructure:"path" yaml:"path" json:"path"`
+	Path                 string            `mapstructure:"path" yaml:"path" json:"path"`
+	AdditionalParameters map[string]string `mapstructure:"additionalParameters" yaml:"additionalParameters" json:"additionalParameters"`
 }
 
 // PostgresConfig represents a set of configuration parameters for working with Postgres.
@@ -195,6 +237,15 @@ type PostgresConfig struct {
 	SSLMode              PostgresSSLMode   `mapstructure:"sslMode" yaml:"sslMode" json:"sslMode"`
 	SearchPath           string            `mapstructure:"searchPath" yaml:"searchPath" json:"searchPath"`
 	AdditionalParameters map[string]string `mapstructure:"additionalParameters" yaml:"additionalParameters" json:"additionalParameters"`
+
+	// PgxQueryExecMode sets default query execution mode for pgx driver (default_query_exec_mode parameter).
+	// May be used only with pgx dialect. Use PgxQueryExecModeSimpleProtocol for PgBouncer in transaction pooling mode.
+	PgxQueryExecMode PgxQueryExecMode `mapstructure:"pgxQueryExecMode" yaml:"pgxQueryExecMode" json:"pgxQueryExecMode"`
+
+	// SessionTxLevel enables encoding TxIsolationLevel into the DSN (default_transaction_isolation parameter),
+	// so all connections in the pool use it by default. Isolation level passed in sql.TxOptions still takes precedence.
+	// Note that some connection poolers (e.g. PgBouncer) may reject unknown startup parameters.
+	SessionTxLevel bool `mapstructure:"sessionTxLevel" yaml:"sessionTxLevel" json:"sessionTxLevel"`
 }
 
 // Set sets configuration values from config.DataProvider.
@@ -205,6 +256,11 @@ func (c *Config) Set(dp config.DataProvider) error {
 	if err != nil {
 		return err
 	}
+	if c.strictAdditionalParams {
+		if unknownParams := c.UnknownAdditionalParameters(); len(unknownParams) != 0 {
+			return dp.WrapKeyErr(c.additionalParamsKey(), fmt.Errorf("unknown parameters: %s", strings.Join(unknownParams, ", ")))
+		}
+	}
 
 	var maxOpenConns int
 	if maxOpenConns, err = dp.GetInt(cfgKeyMaxOpenConns); err != nil {
@@ -232,6 +288,20 @@ func (c *Config) Set(dp config.DataProvider) error {
 	}
 	c.ConnMaxLifetime = config.TimeDuration(connMaxLifeTime)
 
+	if c.ConnectionInitSQL, err = dp.GetStringSlice(cfgKeyConnectionInitSQL); err != nil {
+		return err
+	}
+	if c.ConnectionInitRetries, err = dp.GetInt(cfgKeyConnectionInitRetries); err != nil {
+		return err
+	}
+	if c.ConnectionInitRetries < 0 {
+		return dp.WrapKeyErr(cfgKeyConnectionInitRetries, fmt.Errorf("must not be negative"))
+	}
+
+	if c.RequireTLS, err = dp.GetBool(cfgKeyRequireTLS); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -252,19 +322,94 @@ func (c *Config) TxIsolationLevel() sql.IsolationLevel {
 func (c *Config) DriverNameAndDSN() (driverName, dsn string) {
 	switch c.Dialect {
 	case DialectMySQL:
-		return "mysql", MakeMySQLDSN(&c.MySQL)
+		return driverNames[c.Dialect], MakeMySQLDSN(&c.MySQL)
 	case DialectSQLite:
-		return "sqlite3", MakeSQLiteDSN(&c.SQLite)
-	case DialectPostgres:
-		return "postgres", MakePostgresDSN(&c.Postgres)
-	case DialectPgx:
-		return "pgx", MakePostgresDSN(&c.Postgres)
+		return driverNames[c.Dialect], MakeSQLiteDSN(&c.SQLite)
+	case DialectPostgres, DialectPgx:
+		return driverNames[c.Dialect], MakePostgresDSN(&c.Postgres)
 	case DialectMSSQL:
-		return "mssql", MakeMSSQLDSN(&c.MSSQL)
+		return driverNames[c.Dialect], MakeMSSQLDSN(&c.MSSQL)
 	}
 	return "", ""
 }
 
+// OverrideDriver returns a copy of the Postgres config that is opened via another driver:
+// lib/pq for DialectPostgres or pgx for DialectPgx. The rest of the config stays the same,
+// so drivers may be evaluated (e.g. A/B tested) without editing configuration files.
+// As in Set, target_session_attrs=read-write is added for pgx if it's not specified.
+// Since lib/pq doesn't support target_session_attrs and query exec mode (see PostgresConfig.PgxQueryExecMode),
+// they are removed when overriding to lib/pq.
+func (c *Config) OverrideDriver(dialect Dialect) (*Config, error) {
+	if c.Dialect != DialectPostgres && c.Dialect != DialectPgx {
+		return nil, fmt.Errorf("driver override is supported only for postgres dialects, config dialect is %q", c.Dialect)
+	}
+	if dialect != DialectPostgres && dialect != DialectPgx {
+		return nil, fmt.Errorf("driver override is supported only for postgres dialects, got %q", dialect)
+	}
+	overridden := *c
+	overridden.Dialect = dialect
+	overridden.Postgres.AdditionalParameters = make(map[string]string, len(c.Postgres.AdditionalParameters)+1)
+	for k, v := range c.Postgres.AdditionalParameters {
+		overridden.Postgres.AdditionalParameters[k] = v
+	}
+	switch dialect {
+	case DialectPgx:
+		if _, ok := overridden.Postgres.AdditionalParameters[PgTargetSessionAttrs]; !ok {
+			overridden.Postgres.AdditionalParameters[PgTargetSessionAttrs] = PgReadWriteParam
+		}
+	case DialectPostgres:
+		delete(overridden.Postgres.AdditionalParameters, PgTargetSessionAttrs)
+		// lib/pq passes unknown DSN parameters to the server as run-time parameters, so the connection would fail.
+		overridden.Postgres.PgxQueryExecMode = ""
+	}
+	return &overridden, nil
+}
+
+// Redacted returns a deep copy of the config with all non-empty passwords replaced with RedactedPassword.
+// The result is suitable for marshaling into JSON or YAML (e.g. for diagnostics endpoints).
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redacted.ConnectionInitSQL = copyStringSlice(c.ConnectionInitSQL)
+	redacted.supportedDialects = append([]Dialect(nil), c.supportedDialects...)
+	redacted.MySQL.Password = redactPassword(c.MySQL.Password)
+	redacted.MySQL.ConnectionAttributes = redactedStringMap(c.MySQL.ConnectionAttributes)
+	redacted.MSSQL.Password = redactPassword(c.MSSQL.Password)
+	redacted.MSSQL.AdditionalParameters = redactedStringMap(c.MSSQL.AdditionalParameters)
+	redacted.Postgres.Password = redactPassword(c.Postgres.Password)
+	redacted.Postgres.AdditionalParameters = redactedStringMap(c.Postgres.AdditionalParameters)
+	redacted.SQLite.AdditionalParameters = redactedStringMap(c.SQLite.AdditionalParameters)
+	return &redacted
+}
+
+func redactPassword(password string) string {
+	if password == "" {
+		return ""
+	}
+	return RedactedPassword
+}
+
+func copyStringSlice(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append([]string(nil), s...)
+}
+
+// redactedStringMap copies the map and redacts values of password-like parameters.
+func redactedStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		if strings.Contains(strings.ToLower(k), "password") {
+			v = redactPassword(v)
+		}
+		res[k] = v
+	}
+	return res
+}
+
 func (c *Config) setDialectSpecificConfig(dp config.DataProvider) error {
 	var err error
 
@@ -313,6 +458,19 @@ func (c *Config) setMySQLConfig(dp config.DataProvider) error {
 	if c.MySQL.TxIsolationLevel, err = getIsolationLevel(dp, cfgKeyMySQLTxLevel); err != nil {
 		return err
 	}
+	if c.MySQL.SessionTxLevel, err = dp.GetBool(cfgKeyMySQLSessionTxLevel); err != nil {
+		return err
+	}
+	if c.MySQL.TLS, err = dp.GetString(cfgKeyMySQLTLS); err != nil {
+		return err
+	}
+	var connAttrs map[string]string
+	if connAttrs, err = dp.GetStringMapString(cfgKeyMySQLConnAttrs); err != nil {
+		return err
+	}
+	if len(connAttrs) != 0 {
+		c.MySQL.ConnectionAttributes = connAttrs
+	}
 
 	return nil
 }
@@ -375,6 +533,9 @@ func (c *Config) setPostgresConfig(dp config.DataProvider, dialect Dialect) erro
 	if c.Postgres.TxIsolationLevel, err = getIsolationLevel(dp, cfgKeyPostgresTxLevel); err != nil {
 		return err
 	}
+	if c.Postgres.SessionTxLevel, err = dp.GetBool(cfgKeyPostgresSessionTxLevel); err != nil {
+		return err
+	}
 
 	var additionalParams map[string]string
 	if additionalParams, err = dp.GetStringMapString(cfgKeyPostgresAdditionalParams); err != nil {
@@ -406,6 +567,27 @@ func (c *Config) setPostgresConfig(dp config.DataProvider, dialect Dialect) erro
 	}
 	c.Postgres.SSLMode = PostgresSSLMode(gotSSLModeStr)
 
+	var queryExecModeStr string
+	if queryExecModeStr, err = dp.GetString(cfgKeyPostgresPgxQueryExecMode); err != nil {
+		return err
+	}
+	if queryExecModeStr != "" {
+		if dialect != DialectPgx {
+			return dp.WrapKeyErr(cfgKeyPostgresPgxQueryExecMode, fmt.Errorf("may be used only with %s dialect", DialectPgx))
+		}
+		availableQueryExecModesStr := []string{
+			string(PgxQueryExecModeCacheStatement),
+			string(PgxQueryExecModeCacheDescribe),
+			string(PgxQueryExecModeDescribeExec),
+			string(PgxQueryExecModeExec),
+			string(PgxQueryExecModeSimpleProtocol),
+		}
+		if queryExecModeStr, err = dp.GetStringFromSet(cfgKeyPostgresPgxQueryExecMode, availableQueryExecModesStr, false); err != nil {
+			return err
+		}
+	}
+	c.Postgres.PgxQueryExecMode = PgxQueryExecMode(queryExecModeStr)
+
 	return nil
 }
 
@@ -415,6 +597,13 @@ func (c *Config) setSQLiteConfig(dp config.DataProvider) error {
 	if c.SQLite.Path, err = dp.GetString(cfgKeySQLitePath); err != nil {
 		return err
 	}
+	var additionalParams map[string]string
+	if additionalParams, err = dp.GetStringMapString(cfgKeySQLiteAdditionalParams); err != nil {
+		return err
+	}
+	if len(additionalParams) != 0 {
+		c.SQLite.AdditionalParameters = additionalParams
+	}
 
 	return nil
 }
@@ -10,6 +10,7 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
+	"strings"
 	"testing"
 	"time"
 
@@ -47,6 +48,9 @@ db:
     user: mysql-user
     password: mysql-password
     txLevel: "Repeatable Read"
+    sessionTxLevel: true
+    connectionAttributes:
+      program_name: my-service
 `,
 			expectedCfg: func() *Config {
 				cfg := NewDefaultConfig(supportedDialects)
@@ -60,6 +64,8 @@ db:
 				cfg.MySQL.User = "mysql-user"
 				cfg.MySQL.Password = "mysql-password"
 				cfg.MySQL.TxIsolationLevel = IsolationLevel(sql.LevelRepeatableRead)
+				cfg.MySQL.SessionTxLevel = true
+				cfg.MySQL.ConnectionAttributes = map[string]string{"program_name": "my-service"}
 				return cfg
 			},
 		},
@@ -68,6 +74,10 @@ db:
 			cfgData: `
 db:
   dialect: postgres
+  connectionInitSQL:
+    - "SET application_name = 'my-service'"
+    - "SET statement_timeout = 1000"
+  connectionInitRetries: 2
   postgres:
     host: pg-host
     port: 5433
@@ -89,6 +99,8 @@ db:
 				cfg.Postgres.TxIsolationLevel = IsolationLevel(sql.LevelReadCommitted)
 				cfg.Postgres.SSLMode = PostgresSSLModeVerifyFull
 				cfg.Postgres.SearchPath = "pg-search"
+				cfg.ConnectionInitSQL = []string{"SET application_name = 'my-service'", "SET statement_timeout = 1000"}
+				cfg.ConnectionInitRetries = 2
 				return cfg
 			},
 		},
@@ -121,6 +133,31 @@ db:
 				return cfg
 			},
 		},
+		{
+			name: "postgres dialect, github.com/jackc/pgx driver, simple protocol",
+			cfgData: `
+db:
+  dialect: pgx
+  postgres:
+    host: pg-host
+    port: 5433
+    database: pg_db
+    user: pg-user
+    password: pg-password
+    pgxQueryExecMode: simple_protocol
+`,
+			expectedCfg: func() *Config {
+				cfg := NewDefaultConfig(supportedDialects)
+				cfg.Dialect = DialectPgx
+				cfg.Postgres.Host = "pg-host"
+				cfg.Postgres.Port = 5433
+				cfg.Postgres.Database = "pg_db"
+				cfg.Postgres.User = "pg-user"
+				cfg.Postgres.Password = "pg-password"
+				cfg.Postgres.PgxQueryExecMode = PgxQueryExecModeSimpleProtocol
+				return cfg
+			},
+		},
 		{
 			name: "postgres dialect, github.com/jackc/pgx driver, overridden target_session_attrs",
 			cfgData: `
@@ -227,6 +264,24 @@ db:
 				return cfg
 			},
 		},
+		{
+			name: "sqlite dialect, shared in-memory database",
+			cfgData: `
+db:
+  dialect: sqlite3
+  sqlite3:
+    path: "file::memory:"
+    additionalParameters:
+      cache: shared
+`,
+			expectedCfg: func() *Config {
+				cfg := NewDefaultConfig(supportedDialects)
+				cfg.Dialect = DialectSQLite
+				cfg.SQLite.Path = "file::memory:"
+				cfg.SQLite.AdditionalParameters = map[string]string{"cache": "shared"}
+				return cfg
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -342,6 +397,15 @@ db:
 `,
 			expectedErrMsg: `db.maxIdleConns: must be positive`,
 		},
+		{
+			name: "invalid connection init retries",
+			yamlData: `
+db:
+  dialect: mysql
+  connectionInitRetries: -1
+`,
+			expectedErrMsg: `db.connectionInitRetries: must not be negative`,
+		},
 		{
 			name: "max idle connections greater than max open connections",
 			yamlData: `
@@ -361,6 +425,27 @@ db:
 `,
 			expectedErrMsg: `db.connMaxLifeTime: time: invalid duration "invalid-duration"`,
 		},
+		{
+			name: "pgx query exec mode with postgres dialect",
+			yamlData: `
+db:
+  dialect: postgres
+  postgres:
+    pgxQueryExecMode: simple_protocol
+`,
+			expectedErrMsg: `db.postgres.pgxQueryExecMode: may be used only with pgx dialect`,
+		},
+		{
+			name: "unknown pgx query exec mode",
+			yamlData: `
+db:
+  dialect: pgx
+  postgres:
+    pgxQueryExecMode: unknown
+`,
+			expectedErrMsg: `db.postgres.pgxQueryExecMode: unknown value "unknown", ` +
+				`should be one of [cache_statement cache_describe describe_exec exec simple_protocol]`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -371,6 +456,80 @@ db:
 	}
 }
 
+func TestConfig_UnknownAdditionalParameters(t *testing.T) {
+	supportedDialects := []Dialect{DialectSQLite, DialectMySQL, DialectPostgres, DialectPgx, DialectMSSQL}
+
+	tests := []struct {
+		name     string
+		yamlData string
+		expected []string
+	}{
+		{
+			name: "postgres",
+			yamlData: `
+db:
+  dialect: postgres
+  postgres:
+    additionalParameters:
+      application_name: my-service
+      statement_timeout: 1000
+      sslmde: disable
+      default_query_exec_mode: exec
+`,
+			expected: []string{"default_query_exec_mode", "sslmde"},
+		},
+		{
+			name: "pgx",
+			yamlData: `
+db:
+  dialect: pgx
+  postgres:
+    additionalParameters:
+      default_query_exec_mode: exec
+`,
+		},
+		{
+			name: "sqlite",
+			yamlData: `
+db:
+  dialect: sqlite3
+  sqlite3:
+    path: ":memory:"
+    additionalParameters:
+      cache: shared
+      _foreign_keyz: 1
+`,
+			expected: []string{"_foreign_keyz"},
+		},
+		{
+			name: "mssql, case-insensitive",
+			yamlData: `
+db:
+  dialect: mssql
+  mssql:
+    additionalParameters:
+      TrustServerCertificate: true
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := NewConfig(supportedDialects)
+			err := config.NewDefaultLoader("").LoadFromReader(bytes.NewBuffer([]byte(tt.yamlData)), config.DataTypeYAML, cfg)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, cfg.UnknownAdditionalParameters())
+
+			cfg = NewConfig(supportedDialects, WithStrictAdditionalParameters())
+			err = config.NewDefaultLoader("").LoadFromReader(bytes.NewBuffer([]byte(tt.yamlData)), config.DataTypeYAML, cfg)
+			if len(tt.expected) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorContains(t, err, "additionalParameters: unknown parameters: "+strings.Join(tt.expected, ", "))
+		})
+	}
+}
+
 func mustYAMLToJSON(yamlData []byte) []byte {
 	var yamlMap map[string]interface{}
 	if err := yaml.Unmarshal(yamlData, &yamlMap); err != nil {
@@ -382,3 +541,73 @@ func mustYAMLToJSON(yamlData []byte) []byte {
 	}
 	return jsonData
 }
+
+func TestConfig_Redacted(t *testing.T) {
+	cfg := &Config{
+		Dialect:           DialectPostgres,
+		MaxOpenConns:      10,
+		ConnectionInitSQL: []string{"SET statement_timeout = 1000"},
+		MySQL:             MySQLConfig{Host: "myhost", Password: "mypassword"},
+		MSSQL:             MSSQLConfig{Host: "mssqlhost", AdditionalParameters: map[string]string{"app name": "test"}},
+		Postgres: PostgresConfig{
+			Host:                 "pghost",
+			Port:                 5432,
+			Password:             "pgpassword",
+			SSLMode:              PostgresSSLModeRequire,
+			AdditionalParameters: map[string]string{"sslpassword": "secret", "param1": "foo"},
+		},
+	}
+
+	redacted := cfg.Redacted()
+	require.Equal(t, RedactedPassword, redacted.MySQL.Password)
+	require.Equal(t, RedactedPassword, redacted.Postgres.Password)
+	require.Equal(t, "", redacted.MSSQL.Password)
+	require.Equal(t, map[string]string{"sslpassword": RedactedPassword, "param1": "foo"}, redacted.Postgres.AdditionalParameters)
+	require.Equal(t, "pghost", redacted.Postgres.Host)
+	require.Equal(t, PostgresSSLModeRequire, redacted.Postgres.SSLMode)
+	require.Equal(t, 10, redacted.MaxOpenConns)
+
+	// Original config is untouched.
+	redacted.MSSQL.AdditionalParameters["app name"] = "changed"
+	redacted.ConnectionInitSQL[0] = "changed"
+	require.Equal(t, "mypassword", cfg.MySQL.Password)
+	require.Equal(t, "pgpassword", cfg.Postgres.Password)
+	require.Equal(t, "secret", cfg.Postgres.AdditionalParameters["sslpassword"])
+	require.Equal(t, "test", cfg.MSSQL.AdditionalParameters["app name"])
+	require.Equal(t, "SET statement_timeout = 1000", cfg.ConnectionInitSQL[0])
+}
+
+func TestConfig_OverrideDriver(t *testing.T) {
+	cfg := &Config{Dialect: DialectPostgres, Postgres: PostgresConfig{
+		Host: "pghost", Port: 5432, Database: "pgdb", AdditionalParameters: map[string]string{"param1": "foo"},
+	}}
+
+	pgxCfg, err := cfg.OverrideDriver(DialectPgx)
+	require.NoError(t, err)
+	driverName, dsn := pgxCfg.DriverNameAndDSN()
+	require.Equal(t, "pgx", driverName)
+	require.Equal(t, "postgres://:@pghost:5432/pgdb?sslmode=verify-ca&param1=foo&target_session_attrs=read-write", dsn)
+	require.Equal(t, DialectPostgres, cfg.Dialect, "original config must not be changed")
+	require.Equal(t, map[string]string{"param1": "foo"}, cfg.Postgres.AdditionalParameters)
+
+	pqCfg, err := pgxCfg.OverrideDriver(DialectPostgres)
+	require.NoError(t, err)
+	driverName, dsn = pqCfg.DriverNameAndDSN()
+	require.Equal(t, "postgres", driverName)
+	require.Equal(t, "postgres://:@pghost:5432/pgdb?sslmode=verify-ca&param1=foo", dsn)
+
+	pgxCfg.Postgres.PgxQueryExecMode = PgxQueryExecModeSimpleProtocol
+	_, dsn = pgxCfg.DriverNameAndDSN()
+	require.Contains(t, dsn, "default_query_exec_mode=simple_protocol")
+	pqCfg, err = pgxCfg.OverrideDriver(DialectPostgres)
+	require.NoError(t, err)
+	require.Empty(t, pqCfg.Postgres.PgxQueryExecMode)
+	_, dsn = pqCfg.DriverNameAndDSN()
+	require.Equal(t, "postgres://:@pghost:5432/pgdb?sslmode=verify-ca&param1=foo", dsn)
+	require.Equal(t, PgxQueryExecModeSimpleProtocol, pgxCfg.Postgres.PgxQueryExecMode, "original config must not be changed")
+
+	_, err = cfg.OverrideDriver(DialectMySQL)
+	require.EqualError(t, err, `driver override is supported only for postgres dialects, got "mysql"`)
+	_, err = (&Config{Dialect: DialectSQLite}).OverrideDriver(DialectPgx)
+	require.EqualError(t, err, `driver override is supported only for postgres dialects, config dialect is "sqlite3"`)
+}
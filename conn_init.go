@@ -0,0 +1,123 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+)
+
+// initSQLConnector wraps driver.Connector and executes initialization SQL statements
+// once for each newly established connection.
+type initSQLConnector struct {
+	driver.Connector
+	initSQL     []string
+	initRetries int
+}
+
+// NewConnector creates driver.Connector for the database specified in the configuration.
+// If cfg.ConnectionInitSQL is not empty, the connector executes its statements on every new connection.
+// It may be used with sql.OpenDB by packages that open the database on their own (e.g. dbrutil).
+func NewConnector(cfg *Config) (driver.Connector, error) {
+	driverName, dsn := cfg.DriverNameAndDSN()
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	drv := db.Driver()
+	_ = db.Close() // Nothing is opened yet, db was used only for getting the driver.
+
+	var connector driver.Connector
+	if drvCtx, ok := drv.(driver.DriverContext); ok {
+		if connector, err = drvCtx.OpenConnector(dsn); err != nil {
+			return nil, err
+		}
+	} else {
+		connector = dsnConnector{dsn: dsn, drv: drv}
+	}
+	return wrapConnector(cfg, connector), nil
+}
+
+// wrapConnector wraps passed driver.Connector with initSQLConnector if cfg.ConnectionInitSQL is not empty.
+func wrapConnector(cfg *Config, connector driver.Connector) driver.Connector {
+	if len(cfg.ConnectionInitSQL) == 0 {
+		return connector
+	}
+	return &initSQLConnector{Connector: connector, initSQL: cfg.ConnectionInitSQL, initRetries: cfg.ConnectionInitRetries}
+}
+
+// Connect establishes a new connection and executes initialization SQL statements on it.
+// If initialization fails, the connection is closed and established again (at most initRetries times).
+// Initialization error wraps driver.ErrBadConn, so database/sql treats it as a connection failure
+// and never hands out the connection in a wrong session state.
+// Implements driver.Connector interface.
+func (c *initSQLConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := c.Connector.Connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err = c.initConn(ctx, conn); err == nil {
+			return conn, nil
+		}
+		_ = conn.Close()
+		if attempt >= c.initRetries || ctx.Err() != nil {
+			return nil, fmt.Errorf("%w (%w)", err, driver.ErrBadConn)
+		}
+	}
+}
+
+func (c *initSQLConnector) initConn(ctx context.Context, conn driver.Conn) error {
+	for _, query := range c.initSQL {
+		if err := execOnConn(ctx, conn, query); err != nil {
+			return fmt.Errorf("execute connection init sql %q: %w", query, err)
+		}
+	}
+	return nil
+}
+
+func execOnConn(ctx context.Context, conn driver.Conn, query string) error {
+	if execer, ok := conn.(driver.ExecerContext); ok {
+		_, err := execer.ExecContext(ctx, query, nil)
+		if err != driver.ErrSkip {
+			return err
+		}
+	}
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = conn.Prepare(query)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() { _ = stmt.Close() }()
+	if stmtExecer, ok := stmt.(driver.StmtExecContext); ok {
+		_, err = stmtExecer.ExecContext(ctx, nil)
+		return err
+	}
+	_, err = stmt.Exec(nil) //nolint:staticcheck // Fallback for drivers that don't implement driver.StmtExecContext.
+	return err
+}
+
+// dsnConnector is a trivial driver.Connector implementation for drivers that don't implement driver.DriverContext.
+type dsnConnector struct {
+	dsn string
+	drv driver.Driver
+}
+
+func (c dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.drv.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.drv
+}
@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"syscall"
+)
+
+// connectionErrorFuncs stores functions registered by RegisterIsConnectionErrorFunc.
+var connectionErrorFuncs = newDriverErrorFuncs()
+
+// IsConnectionError tells if the error means that the connection is dead (or the server is shutting down)
+// as opposed to the error of the query itself. Callers (e.g. circuit breakers) may decide to re-open the connection
+// (or wait for the database) rather than retry the query in this case.
+// driver.ErrBadConn, sql.ErrConnDone, io.EOF, io.ErrUnexpectedEOF, network errors and connection reset (or refused)
+// system errors are always considered connection errors. Timeouts (network ones and context.DeadlineExceeded)
+// and context.Canceled are not, since they are more likely caused by a slow query than by a dead connection.
+// Driver specific errors are recognized by the functions registered with RegisterIsConnectionErrorFunc
+// (e.g. by importing github.com/acronis/go-dbkit/postgres).
+func IsConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	// Context errors mean that the query (or the caller) timed out or was canceled,
+	// context.DeadlineExceeded implements net.Error, so it's checked first.
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return !netErr.Timeout()
+	}
+	return connectionErrorFuncs.match(err)
+}
+
+// RegisterIsConnectionErrorFunc registers callback to determine whether specific DB error means dead connection
+// (see IsConnectionError). Several registered functions for the same driver will be called one after another
+// in FIFO order before some function returns true.
+// Note: this function is not concurrent-safe. Typical scenario: register all custom functions in module init()
+func RegisterIsConnectionErrorFunc(d driver.Driver, isConnectionError func(err error) bool) {
+	connectionErrorFuncs.register(d, isConnectionError)
+}
+
+// UnregisterAllIsConnectionErrorFuncs removes previously registered connection error functions for the given driver.
+func UnregisterAllIsConnectionErrorFuncs(d driver.Driver) {
+	connectionErrorFuncs.unregisterAll(d)
+}
+
+// driverErrorFuncs stores error classification functions registered per driver type (one function per type).
+// The registration order of driver types is kept, so functions are called deterministically.
+type driverErrorFuncs struct {
+	funcs map[reflect.Type]func(err error) bool
+	order []reflect.Type
+}
+
+func newDriverErrorFuncs() *driverErrorFuncs {
+	return &driverErrorFuncs{funcs: map[reflect.Type]func(err error) bool{}}
+}
+
+// register appends the function to the ones already registered for the driver.
+func (f *driverErrorFuncs) register(d driver.Driver, fn func(err error) bool) {
+	t := reflect.TypeOf(d)
+	prev, ok := f.funcs[t]
+	if !ok {
+		f.order = append(f.order, t)
+	}
+	f.funcs[t] = func(e error) bool {
+		if ok && prev(e) {
+			return true
+		}
+		return fn(e)
+	}
+}
+
+func (f *driverErrorFuncs) unregisterAll(d driver.Driver) {
+	t := reflect.TypeOf(d)
+	if _, ok := f.funcs[t]; !ok {
+		return
+	}
+	delete(f.funcs, t)
+	for i, orderedType := range f.order {
+		if orderedType == t {
+			f.order = append(f.order[:i], f.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// match returns true if some of the registered functions returns true for the error.
+func (f *driverErrorFuncs) match(err error) bool {
+	for _, t := range f.order {
+		if f.funcs[t](err) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type connectionErrorTestDriver struct {
+	driver.Driver
+}
+
+func TestIsConnectionError(t *testing.T) {
+	require.False(t, IsConnectionError(nil))
+	require.False(t, IsConnectionError(errors.New("syntax error")))
+	require.False(t, IsConnectionError(sql.ErrNoRows))
+	require.False(t, IsConnectionError(context.DeadlineExceeded))
+	require.False(t, IsConnectionError(fmt.Errorf("query: %w", context.Canceled)))
+	require.False(t, IsConnectionError(&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}))
+	for _, err := range []error{
+		driver.ErrBadConn,
+		sql.ErrConnDone,
+		io.EOF,
+		fmt.Errorf("read packet: %w", io.ErrUnexpectedEOF),
+		&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET},
+		fmt.Errorf("write: %w", syscall.EPIPE),
+	} {
+		require.True(t, IsConnectionError(err), "error %v", err)
+	}
+
+	errServerShutdown := errors.New("server shutdown in progress")
+	drv := &connectionErrorTestDriver{}
+	RegisterIsConnectionErrorFunc(drv, func(err error) bool {
+		return errors.Is(err, errServerShutdown)
+	})
+	defer UnregisterAllIsConnectionErrorFuncs(drv)
+	require.True(t, IsConnectionError(fmt.Errorf("query: %w", errServerShutdown)))
+	require.False(t, IsConnectionError(errors.New("syntax error")))
+
+	UnregisterAllIsConnectionErrorFuncs(drv)
+	require.False(t, IsConnectionError(errServerShutdown))
+}
@@ -48,6 +48,29 @@ const (
 	DialectMSSQL    Dialect = "mssql"
 )
 
+// Maximum lengths of identifiers (table names, column names, etc.) supported by SQL dialects.
+// Postgres silently truncates longer identifiers, MySQL and MSSQL reject them.
+const (
+	MySQLMaxIdentifierLength    = 64
+	PostgresMaxIdentifierLength = 63
+	MSSQLMaxIdentifierLength    = 128
+)
+
+// MaxIdentifierLength returns the maximum identifier length for the passed SQL dialect.
+// Zero is returned if the dialect has no practical limit (e.g. SQLite) or is unknown.
+func MaxIdentifierLength(dialect Dialect) int {
+	switch dialect {
+	case DialectMySQL:
+		return MySQLMaxIdentifierLength
+	case DialectPostgres, DialectPgx:
+		return PostgresMaxIdentifierLength
+	case DialectMSSQL:
+		return MSSQLMaxIdentifierLength
+	default:
+		return 0
+	}
+}
+
 // PostgresSSLMode defines possible values for Postgres sslmode connection parameter.
 type PostgresSSLMode string
 
@@ -58,3 +81,17 @@ const (
 	PostgresSSLModeVerifyCA   PostgresSSLMode = "verify-ca"
 	PostgresSSLModeVerifyFull PostgresSSLMode = "verify-full"
 )
+
+// PgxQueryExecMode defines possible values for pgx default_query_exec_mode connection parameter.
+type PgxQueryExecMode string
+
+// Pgx query execution modes.
+// PgxQueryExecModeSimpleProtocol should be used behind PgBouncer in transaction pooling mode,
+// since prepared statements don't work there.
+const (
+	PgxQueryExecModeCacheStatement PgxQueryExecMode = "cache_statement"
+	PgxQueryExecModeCacheDescribe  PgxQueryExecMode = "cache_describe"
+	PgxQueryExecModeDescribeExec   PgxQueryExecMode = "describe_exec"
+	PgxQueryExecModeExec           PgxQueryExecMode = "exec"
+	PgxQueryExecModeSimpleProtocol PgxQueryExecMode = "simple_protocol"
+)
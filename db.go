@@ -9,28 +9,145 @@ package dbkit
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"runtime"
+	"sync"
 	"time"
+	"weak"
 
 	"github.com/acronis/go-appkit/retry"
 )
 
+// OpenOption is a functional option for Open.
+type OpenOption func(*openOptions)
+
+type openOptions struct {
+	driverOverride    Dialect
+	pingRetries       int
+	pingRetryInterval time.Duration
+	poolSettingsHook  func(settings PoolSettings)
+}
+
+// WithDriverOverride makes Open use another driver for the Postgres config (see Config.OverrideDriver):
+// lib/pq for DialectPostgres or pgx for DialectPgx.
+func WithDriverOverride(dialect Dialect) OpenOption {
+	return func(opts *openOptions) {
+		opts.driverOverride = dialect
+	}
+}
+
+// WithPingRetries makes Open retry the failed ping (if it's enabled) at most n times with the passed interval
+// between attempts. It covers the case when the database is occasionally slow to accept the first connection
+// (e.g. after a failover). WaitForDB may be used for more sophisticated retry policies.
+func WithPingRetries(n int, interval time.Duration) OpenOption {
+	return func(opts *openOptions) {
+		opts.pingRetries = n
+		opts.pingRetryInterval = interval
+	}
+}
+
+// WithPoolSettingsHook sets a function that is called by Open with the pool settings applied to the opened *sql.DB.
+// Since sql.DB doesn't expose most of them (e.g. connection max lifetime), it's mainly useful in tests
+// for checking that the values from the config are honored.
+func WithPoolSettingsHook(hook func(settings PoolSettings)) OpenOption {
+	return func(opts *openOptions) {
+		opts.poolSettingsHook = hook
+	}
+}
+
 // Open opens a new database connection using the provided configuration.
 // If ping is true, it will check the connection by sending a ping to the database.
-func Open(cfg *Config, ping bool) (*sql.DB, error) {
-	driver, dsn := cfg.DriverNameAndDSN()
-	db, err := sql.Open(driver, dsn)
+// If cfg.ConnectionInitSQL is not empty, its statements are executed once for each newly established connection.
+// If cfg.RequireTLS is true, the error is returned when the connection is not configured to use TLS (see Config.ValidateTLS).
+func Open(cfg *Config, ping bool, options ...OpenOption) (*sql.DB, error) {
+	var opts openOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+	if opts.driverOverride != "" {
+		var err error
+		if cfg, err = cfg.OverrideDriver(opts.driverOverride); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.validateRequiredTLS(); err != nil {
+		return nil, err
+	}
+	if len(cfg.ConnectionInitSQL) != 0 {
+		connector, err := NewConnector(cfg)
+		if err != nil {
+			return nil, err
+		}
+		db := sql.OpenDB(connector)
+		return db, initOpenedDB(db, cfg, ping, &opts)
+	}
+	driverName, dsn := cfg.DriverNameAndDSN()
+	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, err
 	}
+	return db, initOpenedDB(db, cfg, ping, &opts)
+}
+
+func initOpenedDB(db *sql.DB, cfg *Config, ping bool, opts *openOptions) error {
+	if opts.poolSettingsHook != nil {
+		opts.poolSettingsHook(cfg.PoolSettings())
+	}
+	if err := InitOpenedDB(db, cfg, ping && opts.pingRetries <= 0); err != nil {
+		return err
+	}
+	if ping && opts.pingRetries > 0 {
+		return WaitForDB(context.Background(), db, retry.NewConstantBackoffPolicy(opts.pingRetryInterval, opts.pingRetries))
+	}
+	return nil
+}
+
+// OpenWithConnector opens database using the passed driver.Connector and initializes it with the pool settings
+// from the configuration (see InitOpenedDB). ConnectionInitSQL from the configuration is executed on each new connection.
+// It's an integration point for libraries that wrap the driver (e.g. for tracing).
+// Config.DriverNameAndDSN may be used for building the connector.
+func OpenWithConnector(cfg *Config, connector driver.Connector, ping bool) (*sql.DB, error) {
+	if connector == nil {
+		return nil, errors.New("connector is nil")
+	}
+	if err := cfg.validateRequiredTLS(); err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(wrapConnector(cfg, connector))
 	return db, InitOpenedDB(db, cfg, ping)
 }
 
-// InitOpenedDB initializes early opened *sql.DB instance.
+// PoolSettings contains settings of the connection pool that are applied to *sql.DB by InitOpenedDB.
+type PoolSettings struct {
+	MaxOpenConns    int           `json:"maxOpenConns" yaml:"maxOpenConns"`
+	MaxIdleConns    int           `json:"maxIdleConns" yaml:"maxIdleConns"`
+	ConnMaxLifetime time.Duration `json:"connMaxLifetime" yaml:"connMaxLifetime"`
+}
+
+// PoolSettings returns settings of the connection pool that are applied to *sql.DB by InitOpenedDB.
+// For SQLite in-memory database that is not shared across connections (i.e. without cache=shared parameter),
+// the pool is limited to a single connection that is never closed,
+// since otherwise different connections would see different (empty) databases.
+func (c *Config) PoolSettings() PoolSettings {
+	if c.Dialect == DialectSQLite && c.SQLite.isPrivateMemory() {
+		return PoolSettings{MaxOpenConns: 1, MaxIdleConns: 1}
+	}
+	return PoolSettings{
+		MaxOpenConns:    c.MaxOpenConns,
+		MaxIdleConns:    c.MaxIdleConns,
+		ConnMaxLifetime: time.Duration(c.ConnMaxLifetime),
+	}
+}
+
+// InitOpenedDB initializes early opened *sql.DB instance with the pool settings (see Config.PoolSettings).
 func InitOpenedDB(db *sql.DB, cfg *Config, ping bool) error {
-	db.SetMaxOpenConns(cfg.MaxOpenConns)
-	db.SetMaxIdleConns(cfg.MaxIdleConns)
-	db.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime))
+	poolSettings := cfg.PoolSettings()
+	db.SetMaxOpenConns(poolSettings.MaxOpenConns)
+	db.SetMaxIdleConns(poolSettings.MaxIdleConns)
+	db.SetConnMaxLifetime(poolSettings.ConnMaxLifetime)
+	storeAppliedPoolSettings(db, poolSettings)
 	if ping {
 		if err := db.Ping(); err != nil {
 			return err
@@ -39,11 +156,58 @@ func InitOpenedDB(db *sql.DB, cfg *Config, ping bool) error {
 	return nil
 }
 
+// appliedPoolSettings maps weak pointers to *sql.DB to the PoolSettings applied to them by InitOpenedDB.
+// Weak pointers are used, so the tracking doesn't prevent *sql.DB from being garbage collected.
+var appliedPoolSettings sync.Map
+
+func storeAppliedPoolSettings(db *sql.DB, poolSettings PoolSettings) {
+	key := weak.Make(db)
+	if _, loaded := appliedPoolSettings.Swap(key, poolSettings); !loaded {
+		runtime.AddCleanup(db, func(key weak.Pointer[sql.DB]) { appliedPoolSettings.Delete(key) }, key)
+	}
+}
+
+// AppliedPoolSettings returns the pool settings applied to the passed *sql.DB by Open, OpenWithConnector or InitOpenedDB,
+// since sql.DB doesn't expose most of them. It may be used for diagnosing pool misconfigurations
+// (e.g. for dumping the effective settings at startup or in a diagnostics endpoint).
+// False is returned if the *sql.DB wasn't initialized by the kit.
+func AppliedPoolSettings(db *sql.DB) (PoolSettings, bool) {
+	poolSettings, ok := appliedPoolSettings.Load(weak.Make(db))
+	if !ok {
+		return PoolSettings{}, false
+	}
+	return poolSettings.(PoolSettings), true
+}
+
+// WaitForDB pings the database until it's available using the passed retry policy
+// (e.g. retry.NewExponentialBackoffPolicy for exponential backoff).
+// It may be used at startup when the database may become available later than the service.
+// If the policy is nil, the database is pinged only once.
+// The last ping error is returned if the database is not available after all attempts.
+func WaitForDB(ctx context.Context, db *sql.DB, policy retry.Policy) error {
+	if policy == nil {
+		return db.PingContext(ctx)
+	}
+	return retry.DoWithRetry(ctx, policy, func(error) bool { return true }, nil, db.PingContext)
+}
+
 type doInTxOptions struct {
-	txOpts      *sql.TxOptions
-	retryPolicy retry.Policy
+	txOpts           *sql.TxOptions
+	retryPolicy      retry.Policy
+	dialect          Dialect
+	statementTimeout time.Duration
+	connWaitObserver func(wait time.Duration)
+	isRetryable      func(err error) bool
+
+	retryOnCommitFailure bool
 }
 
+// ErrTxCommitFailed is wrapped by the error returned by DoInTx (and DoInTxOrJoin) when the transaction commit fails.
+// Such transaction is in doubt: the commit may be applied on the server even if the error is returned
+// (e.g. the connection is lost before the response is received), so the effects of the function
+// must not be re-applied blindly.
+var ErrTxCommitFailed = errors.New("commit tx")
+
 // DoInTxOption is a functional option for DoInTx.
 type DoInTxOption func(*doInTxOptions)
 
@@ -61,24 +225,113 @@ func WithRetryPolicy(policy retry.Policy) DoInTxOption {
 	}
 }
 
+// WithRetryableErrorFunc sets a function that classifies errors as retryable for the DoInTx call
+// in addition to the function registered for the driver (see RegisterIsRetryableFunc).
+// The error is retried if any of them returns true. It allows retrying a single transaction on the application-specific
+// condition (e.g. optimistic concurrency conflict) without affecting the global per-driver registration.
+// It's used only when the retry policy is set (see WithRetryPolicy).
+func WithRetryableErrorFunc(isRetryable func(err error) bool) DoInTxOption {
+	return func(opts *doInTxOptions) {
+		opts.isRetryable = isRetryable
+	}
+}
+
+// WithRetryOnCommitFailure enables retrying the whole transaction by DoInTx when the commit fails
+// with a retryable error (see ErrTxCommitFailed). By default, commit failures are never retried,
+// since the transaction may be committed already, and retrying could apply its effects twice.
+// Failures before the commit (begin, statements or the function itself) are always safe to retry.
+// It may be enabled knowingly if the function is idempotent or the commit failure is known to mean rollback
+// (e.g. Postgres serialization failure of the SERIALIZABLE transaction is reported on commit).
+// It's used only when the retry policy is set (see WithRetryPolicy).
+func WithRetryOnCommitFailure(retryOnCommitFailure bool) DoInTxOption {
+	return func(opts *doInTxOptions) {
+		opts.retryOnCommitFailure = retryOnCommitFailure
+	}
+}
+
+// WithDialect sets SQL dialect of the database for DoInTx.
+// It's required for the dialect-specific options like WithStatementTimeout.
+func WithDialect(dialect Dialect) DoInTxOption {
+	return func(opts *doInTxOptions) {
+		opts.dialect = dialect
+	}
+}
+
+// WithStatementTimeout sets server-side timeout for each statement executed within the transaction started by DoInTx.
+// Dialect must be specified via WithDialect option.
+// For Postgres, "SET LOCAL statement_timeout" is used, so the timeout affects only the current transaction.
+// For MySQL, session's "max_execution_time" is used (it's applied to SELECT statements only).
+// It's set on the connection acquired explicitly (sql.DB.Conn) before beginning the transaction,
+// and the previous value is restored after the transaction is finished.
+// If the restoring fails, the connection is discarded, so the timeout doesn't leak into the pool.
+// It's a no-op for SQLite and MSSQL since they don't support server-side statement timeouts
+// (SQLite statements executed with the context-aware methods are interrupted when the context is done,
+// see the sqlite package).
+func WithStatementTimeout(timeout time.Duration) DoInTxOption {
+	return func(opts *doInTxOptions) {
+		opts.statementTimeout = timeout
+	}
+}
+
+// WithConnWaitObserver sets a function that is called with the duration of waiting for a connection from the pool
+// before beginning the transaction by DoInTx (PrometheusMetrics.ObserveConnWait may be used).
+// It allows distinguishing slow queries from the pool exhaustion, since sql.DBStats.WaitDuration is cumulative.
+// When it's set, the connection is acquired explicitly (sql.DB.Conn) before beginning the transaction.
+func WithConnWaitObserver(observer func(wait time.Duration)) DoInTxOption {
+	return func(opts *doInTxOptions) {
+		opts.connWaitObserver = observer
+	}
+}
+
 // DoInTx begins a new transaction, calls passed function and do commit or rollback
 // depending on whether the function returns an error or not.
+// If the retry policy is set (see WithRetryPolicy), the transaction is retried on retryable errors,
+// except the commit failures (see WithRetryOnCommitFailure).
 func DoInTx(ctx context.Context, dbConn *sql.DB, fn func(tx *sql.Tx) error, options ...DoInTxOption) (err error) {
 	var opts doInTxOptions
 	for _, opt := range options {
 		opt(&opts)
 	}
+	if opts.statementTimeout > 0 && opts.dialect == "" {
+		return fmt.Errorf("dialect must be specified for using statement timeout")
+	}
 	if opts.retryPolicy == nil {
-		return doInTx(ctx, dbConn, fn, opts.txOpts)
+		return doInTx(ctx, dbConn, fn, &opts)
+	}
+	isRetryable := GetIsRetryable(dbConn.Driver())
+	if opts.isRetryable != nil {
+		driverIsRetryable := isRetryable
+		isRetryable = func(err error) bool {
+			return opts.isRetryable(err) || driverIsRetryable(err)
+		}
 	}
-	return retry.DoWithRetry(ctx, opts.retryPolicy, GetIsRetryable(dbConn.Driver()), nil, func(ctx context.Context) error {
-		return doInTx(ctx, dbConn, fn, opts.txOpts)
+	if !opts.retryOnCommitFailure {
+		baseIsRetryable := isRetryable
+		isRetryable = func(err error) bool {
+			return !errors.Is(err, ErrTxCommitFailed) && baseIsRetryable(err)
+		}
+	}
+	return retry.DoWithRetry(ctx, opts.retryPolicy, isRetryable, nil, func(ctx context.Context) error {
+		return doInTx(ctx, dbConn, fn, &opts)
 	})
 }
 
-func doInTx(ctx context.Context, dbConn *sql.DB, fn func(tx *sql.Tx) error, txOpts *sql.TxOptions) (err error) {
+// TxBeginner is an interface for beginning transactions (e.g. *sql.DB or *sql.Conn).
+type TxBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// DoInTxOrJoin calls passed function within the existing transaction if it's not nil,
+// neither commit nor rollback is done in this case, since the transaction is finished by its owner.
+// Otherwise, it begins a new transaction, calls passed function and do commit or rollback
+// depending on whether the function returns an error or not.
+// It allows implementing methods that may be called both standalone and within the caller's transaction.
+func DoInTxOrJoin(ctx context.Context, beginner TxBeginner, existing *sql.Tx, fn func(tx *sql.Tx) error) (err error) {
+	if existing != nil {
+		return fn(existing)
+	}
 	var tx *sql.Tx
-	if tx, err = dbConn.BeginTx(ctx, txOpts); err != nil {
+	if tx, err = beginner.BeginTx(ctx, nil); err != nil {
 		return fmt.Errorf("begin tx: %w", err)
 	}
 	defer func() {
@@ -91,8 +344,97 @@ func doInTx(ctx context.Context, dbConn *sql.DB, fn func(tx *sql.Tx) error, txOp
 			return
 		}
 		if err = tx.Commit(); err != nil {
-			err = fmt.Errorf("commit tx: %w", err)
+			err = fmt.Errorf("%w: %w", ErrTxCommitFailed, err)
 		}
 	}()
 	return fn(tx)
 }
+
+func doInTx(ctx context.Context, dbConn *sql.DB, fn func(tx *sql.Tx) error, opts *doInTxOptions) (err error) {
+	beginTx := dbConn.BeginTx
+	setSessionTimeout := opts.statementTimeout > 0 && opts.dialect == DialectMySQL
+	if opts.connWaitObserver != nil || setSessionTimeout {
+		waitStartedAt := time.Now()
+		conn, connErr := dbConn.Conn(ctx)
+		if opts.connWaitObserver != nil {
+			opts.connWaitObserver(time.Since(waitStartedAt))
+		}
+		if connErr != nil {
+			return fmt.Errorf("get connection: %w", connErr)
+		}
+		defer func() { _ = conn.Close() }() // Deferred first, so it's called after the transaction is finished.
+		if setSessionTimeout {
+			var restoreSessionTimeout func()
+			if restoreSessionTimeout, err = setMySQLSessionStatementTimeout(ctx, conn, opts.statementTimeout); err != nil {
+				return fmt.Errorf("set statement timeout: %w", err)
+			}
+			defer restoreSessionTimeout()
+		}
+		beginTx = conn.BeginTx
+	}
+
+	var tx *sql.Tx
+	if tx, err = beginTx(ctx, opts.txOpts); err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		if err = tx.Commit(); err != nil {
+			err = fmt.Errorf("%w: %w", ErrTxCommitFailed, err)
+		}
+	}()
+	if opts.statementTimeout > 0 {
+		if err = setLocalStatementTimeout(ctx, tx, opts.dialect, opts.statementTimeout); err != nil {
+			return fmt.Errorf("set statement timeout: %w", err)
+		}
+	}
+	return fn(tx)
+}
+
+// setLocalStatementTimeout sets server-side statement timeout that affects only the current transaction.
+// It's a no-op for the dialects that don't support it (MySQL timeout is set for the session,
+// see setMySQLSessionStatementTimeout).
+func setLocalStatementTimeout(ctx context.Context, tx *sql.Tx, dialect Dialect, timeout time.Duration) error {
+	switch dialect {
+	case DialectPostgres, DialectPgx:
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", statementTimeoutMs(timeout)))
+		return err
+	default:
+		return nil
+	}
+}
+
+// setMySQLSessionStatementTimeout sets "max_execution_time" for the session of the passed connection.
+// Returned function should be called after the transaction is finished and before the connection is released.
+// It restores the previous value or discards the connection if the restoring fails.
+func setMySQLSessionStatementTimeout(ctx context.Context, conn *sql.Conn, timeout time.Duration) (restore func(), err error) {
+	var prevTimeoutMs int64
+	if err = conn.QueryRowContext(ctx, "SELECT @@SESSION.max_execution_time").Scan(&prevTimeoutMs); err != nil {
+		return nil, err
+	}
+	if _, err = conn.ExecContext(ctx, fmt.Sprintf("SET SESSION max_execution_time = %d", statementTimeoutMs(timeout))); err != nil {
+		return nil, err
+	}
+	return func() {
+		// Context may be already canceled here, but the session variable should be restored anyway.
+		restoreQuery := fmt.Sprintf("SET SESSION max_execution_time = %d", prevTimeoutMs)
+		if _, restoreErr := conn.ExecContext(context.WithoutCancel(ctx), restoreQuery); restoreErr != nil {
+			// driver.ErrBadConn makes database/sql close the connection instead of returning it to the pool.
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+	}, nil
+}
+
+func statementTimeoutMs(timeout time.Duration) int64 {
+	if timeoutMs := timeout.Milliseconds(); timeoutMs > 0 {
+		return timeoutMs
+	}
+	return 1 // 0 means no timeout for both Postgres and MySQL.
+}
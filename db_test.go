@@ -9,15 +9,20 @@ package dbkit
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/acronis/go-appkit/config"
 	"github.com/acronis/go-appkit/retry"
+	"github.com/acronis/go-appkit/testutil"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 )
 
@@ -78,6 +83,98 @@ func TestOpen(t *testing.T) {
 	}
 }
 
+func TestOpen_SQLiteInMemory(t *testing.T) {
+	makeCfg := func(sqliteCfg SQLiteConfig) *Config {
+		return &Config{
+			Dialect:         DialectSQLite,
+			SQLite:          sqliteCfg,
+			MaxOpenConns:    10,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: config.TimeDuration(time.Minute * 10),
+		}
+	}
+
+	t.Run("private in-memory database uses single connection", func(t *testing.T) {
+		dbConn, err := Open(makeCfg(SQLiteConfig{Path: ":memory:"}), true)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, dbConn.Close()) }()
+		require.Equal(t, 1, dbConn.Stats().MaxOpenConnections)
+
+		// All queries should see the same database.
+		_, err = dbConn.Exec("CREATE TABLE users (id INTEGER)")
+		require.NoError(t, err)
+		var count int
+		require.NoError(t, dbConn.QueryRow("SELECT count(*) FROM users").Scan(&count))
+	})
+
+	t.Run("shared in-memory database uses configured pool", func(t *testing.T) {
+		dbConn, err := Open(makeCfg(SQLiteConfig{Path: ":memory:", AdditionalParameters: map[string]string{"cache": "shared"}}), true)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, dbConn.Close()) }()
+		require.Equal(t, 10, dbConn.Stats().MaxOpenConnections)
+	})
+}
+
+func TestAppliedPoolSettings(t *testing.T) {
+	cfg := &Config{
+		Dialect:         DialectSQLite,
+		SQLite:          SQLiteConfig{Path: ":memory:", AdditionalParameters: map[string]string{"cache": "shared"}},
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: config.TimeDuration(time.Minute * 10),
+	}
+	dbConn, err := Open(cfg, false)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	poolSettings, ok := AppliedPoolSettings(dbConn)
+	require.True(t, ok)
+	require.Equal(t, PoolSettings{MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetime: time.Minute * 10}, poolSettings)
+
+	// Re-initialization overrides the settings.
+	cfg.MaxOpenConns = 20
+	require.NoError(t, InitOpenedDB(dbConn, cfg, false))
+	poolSettings, ok = AppliedPoolSettings(dbConn)
+	require.True(t, ok)
+	require.Equal(t, 20, poolSettings.MaxOpenConns)
+
+	// Database that isn't initialized by the kit.
+	rawDBConn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, rawDBConn.Close()) }()
+	_, ok = AppliedPoolSettings(rawDBConn)
+	require.False(t, ok)
+}
+
+func TestOpen_WithPoolSettingsHook(t *testing.T) {
+	cfg := &Config{
+		Dialect:         DialectSQLite,
+		SQLite:          SQLiteConfig{Path: ":memory:", AdditionalParameters: map[string]string{"cache": "shared"}},
+		MaxOpenConns:    10,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: config.TimeDuration(time.Minute * 10),
+	}
+	var appliedSettings []PoolSettings
+	hook := WithPoolSettingsHook(func(settings PoolSettings) {
+		appliedSettings = append(appliedSettings, settings)
+	})
+
+	dbConn, err := Open(cfg, true, hook)
+	require.NoError(t, err)
+	require.NoError(t, dbConn.Close())
+
+	// Pool of the private in-memory database is overridden.
+	cfg.SQLite.AdditionalParameters = nil
+	dbConn, err = Open(cfg, true, hook)
+	require.NoError(t, err)
+	require.NoError(t, dbConn.Close())
+
+	require.Equal(t, []PoolSettings{
+		{MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetime: time.Minute * 10},
+		{MaxOpenConns: 1, MaxIdleConns: 1},
+	}, appliedSettings)
+}
+
 func TestDoInTx(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -166,6 +263,58 @@ func TestDoInTx(t *testing.T) {
 	}
 }
 
+func TestDoInTxOrJoin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, mock.ExpectationsWereMet())
+	}()
+
+	// New transaction is begun and committed.
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	var outerTx *sql.Tx
+	require.NoError(t, DoInTxOrJoin(context.Background(), db, nil, func(tx *sql.Tx) error {
+		outerTx = tx
+		_, execErr := tx.Exec("UPDATE users")
+		return execErr
+	}))
+	require.NotNil(t, outerTx)
+
+	// Existing transaction is joined and finished by its owner only.
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE notes").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, DoInTx(context.Background(), db, func(tx *sql.Tx) error {
+		if _, execErr := tx.Exec("UPDATE users"); execErr != nil {
+			return execErr
+		}
+		return DoInTxOrJoin(context.Background(), db, tx, func(joinedTx *sql.Tx) error {
+			require.Same(t, tx, joinedTx)
+			_, execErr := joinedTx.Exec("UPDATE notes")
+			return execErr
+		})
+	}))
+
+	// Error in the joined transaction is returned, rollback is done by the owner.
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	require.EqualError(t, DoInTx(context.Background(), db, func(tx *sql.Tx) error {
+		return DoInTxOrJoin(context.Background(), db, tx, func(joinedTx *sql.Tx) error {
+			return fmt.Errorf("fn error")
+		})
+	}), "fn error")
+
+	// Error in the new transaction leads to rollback.
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	require.EqualError(t, DoInTxOrJoin(context.Background(), db, nil, func(tx *sql.Tx) error {
+		return fmt.Errorf("fn error")
+	}), "fn error")
+}
+
 func TestDoInTxWithRetryPolicy(t *testing.T) {
 	retryableError := errors.New("retryable error")
 
@@ -268,3 +417,398 @@ func TestDoInTxWithRetryPolicy(t *testing.T) {
 		})
 	}
 }
+
+func TestDoInTxWithRetryableErrorFunc(t *testing.T) {
+	driverRetryableError := errors.New("driver retryable error")
+	versionMismatchError := errors.New("version mismatch")
+	retryPolicy := retry.NewConstantBackoffPolicy(time.Millisecond, 3)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	UnregisterAllIsRetryableFuncs(db.Driver())
+	RegisterIsRetryableFunc(db.Driver(), func(err error) bool {
+		return errors.Is(err, driverRetryableError)
+	})
+	defer UnregisterAllIsRetryableFuncs(db.Driver())
+
+	// Both the call-site and the driver's classifiers are used.
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+	errs := []error{versionMismatchError, driverRetryableError, nil}
+	var attempts int
+	require.NoError(t, DoInTx(context.Background(), db, func(tx *sql.Tx) error {
+		attempts++
+		return errs[attempts-1]
+	}, WithRetryPolicy(retryPolicy), WithRetryableErrorFunc(func(err error) bool {
+		return errors.Is(err, versionMismatchError)
+	})))
+	require.Equal(t, 3, attempts)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// Without the call-site classifier, the domain error isn't retried.
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	require.ErrorIs(t, DoInTx(context.Background(), db, func(tx *sql.Tx) error {
+		return versionMismatchError
+	}, WithRetryPolicy(retryPolicy)), versionMismatchError)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDoInTxWithRetryOnCommitFailure(t *testing.T) {
+	retryableError := errors.New("retryable error")
+	retryPolicy := retry.NewConstantBackoffPolicy(time.Millisecond, 3)
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	UnregisterAllIsRetryableFuncs(db.Driver())
+	RegisterIsRetryableFunc(db.Driver(), func(err error) bool {
+		return errors.Is(err, retryableError)
+	})
+	defer UnregisterAllIsRetryableFuncs(db.Driver())
+
+	// Commit failure is not retried by default since the transaction may be committed.
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit().WillReturnError(retryableError)
+	var attempts int
+	fn := func(tx *sql.Tx) error {
+		attempts++
+		_, execErr := tx.Exec("UPDATE users")
+		return execErr
+	}
+	err = DoInTx(context.Background(), db, fn, WithRetryPolicy(retryPolicy))
+	require.ErrorIs(t, err, ErrTxCommitFailed)
+	require.ErrorIs(t, err, retryableError)
+	require.EqualError(t, err, "commit tx: retryable error")
+	require.Equal(t, 1, attempts)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// Retrying is enabled explicitly.
+	attempts = 0
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit().WillReturnError(retryableError)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, DoInTx(context.Background(), db, fn, WithRetryPolicy(retryPolicy), WithRetryOnCommitFailure(true)))
+	require.Equal(t, 2, attempts)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// Failures before the commit are still retried.
+	attempts = 0
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WillReturnError(retryableError)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	require.NoError(t, DoInTx(context.Background(), db, fn, WithRetryPolicy(retryPolicy)))
+	require.Equal(t, 2, attempts)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDoInTxWithStatementTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		dialect  Dialect
+		initMock func(m sqlmock.Sqlmock)
+		wantErr  error
+	}{
+		{
+			name:    "postgres",
+			dialect: DialectPostgres,
+			initMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec("SET LOCAL statement_timeout = 1500").WillReturnResult(sqlmock.NewResult(0, 0))
+				m.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectCommit()
+			},
+		},
+		{
+			name:    "mysql, previous value is restored",
+			dialect: DialectMySQL,
+			initMock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery("SELECT @@SESSION.max_execution_time").
+					WillReturnRows(sqlmock.NewRows([]string{"max_execution_time"}).AddRow(300))
+				m.ExpectExec("SET SESSION max_execution_time = 1500").WillReturnResult(sqlmock.NewResult(0, 0))
+				m.ExpectBegin()
+				m.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectCommit()
+				m.ExpectExec("SET SESSION max_execution_time = 300").WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+		},
+		{
+			name:    "mysql, connection is discarded if previous value is not restored",
+			dialect: DialectMySQL,
+			initMock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery("SELECT @@SESSION.max_execution_time").
+					WillReturnRows(sqlmock.NewRows([]string{"max_execution_time"}).AddRow(300))
+				m.ExpectExec("SET SESSION max_execution_time = 1500").WillReturnResult(sqlmock.NewResult(0, 0))
+				m.ExpectBegin()
+				m.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectCommit()
+				m.ExpectExec("SET SESSION max_execution_time = 300").WillReturnError(fmt.Errorf("connection lost"))
+				m.ExpectClose()
+			},
+		},
+		{
+			name:    "sqlite, no-op",
+			dialect: DialectSQLite,
+			initMock: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectCommit()
+			},
+		},
+		{
+			name:     "dialect is not specified",
+			initMock: func(m sqlmock.Sqlmock) {},
+			wantErr:  fmt.Errorf("dialect must be specified for using statement timeout"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.NoError(t, err)
+
+			tt.initMock(mock)
+
+			err = DoInTx(context.Background(), db, func(tx *sql.Tx) error {
+				_, execErr := tx.Exec("UPDATE users")
+				return execErr
+			}, WithDialect(tt.dialect), WithStatementTimeout(time.Millisecond*1500))
+			if tt.wantErr == nil {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.wantErr.Error())
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestOpen_ConnectionInitSQL(t *testing.T) {
+	cfg := &Config{
+		Dialect:           DialectSQLite,
+		SQLite:            SQLiteConfig{Path: t.TempDir() + "/test.db"},
+		MaxOpenConns:      2,
+		MaxIdleConns:      2,
+		ConnectionInitSQL: []string{"PRAGMA foreign_keys = ON"},
+	}
+
+	t.Run("init sql is executed for each connection", func(t *testing.T) {
+		dbConn, err := Open(cfg, true)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, dbConn.Close()) }()
+
+		ctx := context.Background()
+		var conns []*sql.Conn
+		for i := 0; i < 2; i++ {
+			conn, connErr := dbConn.Conn(ctx)
+			require.NoError(t, connErr)
+			conns = append(conns, conn)
+		}
+		for _, conn := range conns {
+			var foreignKeys int
+			require.NoError(t, conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys))
+			require.Equal(t, 1, foreignKeys)
+			require.NoError(t, conn.Close())
+		}
+	})
+
+	t.Run("invalid init sql", func(t *testing.T) {
+		invalidCfg := *cfg
+		invalidCfg.ConnectionInitSQL = []string{"NOT A VALID STATEMENT"}
+		dbConn, err := Open(&invalidCfg, true)
+		require.ErrorContains(t, err, "connection init sql")
+		require.NoError(t, dbConn.Close())
+	})
+}
+
+// flakyInitConn is a fake connection whose Exec fails while failures counter is positive.
+type flakyInitConn struct {
+	connector *flakyInitConnector
+}
+
+func (c *flakyInitConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (c *flakyInitConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (c *flakyInitConn) Close() error {
+	c.connector.closes++
+	return nil
+}
+
+func (c *flakyInitConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	if c.connector.failures > 0 {
+		c.connector.failures--
+		return nil, errors.New("canceling statement due to statement timeout")
+	}
+	return driver.RowsAffected(0), nil
+}
+
+type flakyInitConnector struct {
+	failures int
+	connects int
+	closes   int
+}
+
+func (c *flakyInitConnector) Connect(context.Context) (driver.Conn, error) {
+	c.connects++
+	return &flakyInitConn{connector: c}, nil
+}
+
+func (c *flakyInitConnector) Driver() driver.Driver { return nil }
+
+func TestOpenWithConnector_ConnectionInitSQLFailure(t *testing.T) {
+	cfg := &Config{
+		Dialect:           DialectPostgres,
+		MaxOpenConns:      1,
+		MaxIdleConns:      1,
+		ConnectionInitSQL: []string{"SET statement_timeout = 1000"},
+	}
+
+	t.Run("failed connection is discarded and established again", func(t *testing.T) {
+		retryCfg := *cfg
+		retryCfg.ConnectionInitRetries = 2
+		connector := &flakyInitConnector{failures: 2}
+		dbConn, err := OpenWithConnector(&retryCfg, connector, true)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, dbConn.Close()) }()
+		require.Equal(t, 3, connector.connects)
+		require.Equal(t, 2, connector.closes)
+	})
+
+	t.Run("init error is treated as bad connection", func(t *testing.T) {
+		connector := &flakyInitConnector{failures: 100}
+		dbConn, err := OpenWithConnector(cfg, connector, true)
+		require.ErrorIs(t, err, driver.ErrBadConn)
+		require.ErrorContains(t, err, `execute connection init sql "SET statement_timeout = 1000": canceling statement`)
+		require.NoError(t, dbConn.Close())
+		// Connection is never handed out, database/sql retries on driver.ErrBadConn on its own.
+		require.Greater(t, connector.connects, 1)
+		require.Equal(t, connector.connects, connector.closes)
+		require.Equal(t, 0, dbConn.Stats().OpenConnections)
+	})
+}
+
+type countingConnector struct {
+	driver.Connector
+	connects int
+}
+
+func (c *countingConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.connects++
+	return c.Connector.Connect(ctx)
+}
+
+func TestOpenWithConnector(t *testing.T) {
+	cfg := &Config{
+		Dialect:           DialectSQLite,
+		SQLite:            SQLiteConfig{Path: t.TempDir() + "/test.db"},
+		MaxOpenConns:      3,
+		MaxIdleConns:      2,
+		ConnectionInitSQL: []string{"PRAGMA foreign_keys = ON"},
+	}
+	baseConnector, err := NewConnector(&Config{Dialect: cfg.Dialect, SQLite: cfg.SQLite})
+	require.NoError(t, err)
+	connector := &countingConnector{Connector: baseConnector}
+
+	dbConn, err := OpenWithConnector(cfg, connector, true)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	require.Equal(t, 1, connector.connects)
+	require.Equal(t, 3, dbConn.Stats().MaxOpenConnections)
+	var foreignKeys int
+	require.NoError(t, dbConn.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys))
+	require.Equal(t, 1, foreignKeys)
+
+	_, err = OpenWithConnector(cfg, nil, true)
+	require.EqualError(t, err, "connector is nil")
+}
+
+func TestDoInTxWithConnWaitObserver(t *testing.T) {
+	dbConn, err := Open(&Config{
+		Dialect:      DialectSQLite,
+		SQLite:       SQLiteConfig{Path: t.TempDir() + "/test.db"},
+		MaxOpenConns: 1,
+		MaxIdleConns: 1,
+	}, true)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	metrics := NewPrometheusMetrics()
+	var waits []time.Duration
+	observer := func(wait time.Duration) {
+		waits = append(waits, wait)
+		metrics.ObserveConnWait(wait)
+	}
+
+	// The only connection is busy, so the second transaction waits for it.
+	busyConn, err := dbConn.Conn(context.Background())
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = busyConn.Close()
+	}()
+	require.NoError(t, DoInTx(context.Background(), dbConn, func(tx *sql.Tx) error {
+		_, execErr := tx.Exec("SELECT 1")
+		return execErr
+	}, WithConnWaitObserver(observer)))
+	require.Len(t, waits, 1)
+	require.GreaterOrEqual(t, waits[0], 50*time.Millisecond)
+
+	// Connection is returned to the pool after the transaction is finished.
+	require.NoError(t, DoInTx(context.Background(), dbConn, func(tx *sql.Tx) error {
+		return nil
+	}, WithConnWaitObserver(observer)))
+	require.Len(t, waits, 2)
+	testutil.RequireSamplesCountInHistogram(t, metrics.ConnWaitDurations.With(prometheus.Labels{}).(prometheus.Histogram), 2)
+	require.Equal(t, 0, dbConn.Stats().InUse)
+}
+
+func TestWaitForDB(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+
+	pingErr := errors.New("connection refused")
+	mock.ExpectPing().WillReturnError(pingErr)
+	mock.ExpectPing().WillReturnError(pingErr)
+	mock.ExpectPing()
+	require.NoError(t, WaitForDB(context.Background(), db, retry.NewConstantBackoffPolicy(time.Millisecond, 5)))
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	mock.ExpectPing().WillReturnError(pingErr)
+	mock.ExpectPing().WillReturnError(pingErr)
+	require.ErrorIs(t, WaitForDB(context.Background(), db, retry.NewConstantBackoffPolicy(time.Millisecond, 1)), pingErr)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	mock.ExpectPing().WillReturnError(pingErr)
+	require.ErrorIs(t, WaitForDB(context.Background(), db, nil), pingErr)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOpen_WithPingRetries(t *testing.T) {
+	dbDir := filepath.Join(t.TempDir(), "db")
+	cfg := &Config{Dialect: DialectSQLite, SQLite: SQLiteConfig{Path: filepath.Join(dbDir, "test.db")}}
+
+	// Database is not available since its directory doesn't exist.
+	_, err := Open(cfg, true)
+	require.Error(t, err)
+	_, err = Open(cfg, true, WithPingRetries(2, time.Millisecond))
+	require.Error(t, err)
+
+	// Database becomes available after a while.
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = os.Mkdir(dbDir, 0o755)
+	}()
+	dbConn, err := Open(cfg, true, WithPingRetries(100, 10*time.Millisecond))
+	require.NoError(t, err)
+	require.NoError(t, dbConn.Close())
+}
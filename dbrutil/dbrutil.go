@@ -23,18 +23,105 @@ import (
 	"github.com/acronis/go-dbkit"
 )
 
+// OpenOption is a functional option for Open.
+type OpenOption func(*openOptions)
+
+type openOptions struct {
+	dialect         dbr.Dialect
+	afterOpen       func(conn *dbr.Connection) error
+	pingRetryPolicy retry.Policy
+	driverOverride  dbkit.Dialect
+}
+
+// WithDialect sets dbr dialect that is used for building queries.
+// By default, the dialect is chosen by dbr according to the driver name.
+func WithDialect(dialect dbr.Dialect) OpenOption {
+	return func(opts *openOptions) {
+		opts.dialect = dialect
+	}
+}
+
+// WithAfterOpen sets a callback that is called after the connection is opened and initialized.
+// It may be used for the additional configuration of the connection.
+// If the callback returns an error, the connection is closed and Open returns this error.
+func WithAfterOpen(fn func(conn *dbr.Connection) error) OpenOption {
+	return func(opts *openOptions) {
+		opts.afterOpen = fn
+	}
+}
+
+// WithPingRetryPolicy sets a retry policy for pinging the database when Open is called with ping=true
+// (see dbkit.WaitForDB). By default, the database is pinged only once.
+func WithPingRetryPolicy(policy retry.Policy) OpenOption {
+	return func(opts *openOptions) {
+		opts.pingRetryPolicy = policy
+	}
+}
+
+// WithDriverOverride makes Open use another driver for the Postgres config (see dbkit.Config.OverrideDriver):
+// lib/pq for dbkit.DialectPostgres or pgx for dbkit.DialectPgx.
+func WithDriverOverride(dialect dbkit.Dialect) OpenOption {
+	return func(opts *openOptions) {
+		opts.driverOverride = dialect
+	}
+}
+
 // Open opens database (using dbr query builder) with specified configuration parameters
 // and verifies (if ping argument is true) that connection can be established.
-func Open(cfg *dbkit.Config, ping bool, eventReceiver dbr.EventReceiver) (*dbr.Connection, error) {
+// As in dbkit.Open, cfg.ConnectionInitSQL statements are executed once for each newly established connection.
+func Open(cfg *dbkit.Config, ping bool, eventReceiver dbr.EventReceiver, options ...OpenOption) (*dbr.Connection, error) {
+	var opts openOptions
+	for _, opt := range options {
+		opt(&opts)
+	}
+
+	if opts.driverOverride != "" {
+		var err error
+		if cfg, err = cfg.OverrideDriver(opts.driverOverride); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RequireTLS {
+		if err := cfg.ValidateTLS(); err != nil {
+			return nil, err
+		}
+	}
+
 	driver, dsn := cfg.DriverNameAndDSN()
 	conn, err := dbr.Open(driver, dsn, eventReceiver)
 	if err != nil {
 		return nil, err
 	}
+	if len(cfg.ConnectionInitSQL) != 0 {
+		// dbr.Open is still used for choosing the dialect, but the connections are created by the dbkit's connector.
+		connector, connectorErr := dbkit.NewConnector(cfg)
+		_ = conn.Close() // Nothing is opened yet.
+		if connectorErr != nil {
+			return nil, connectorErr
+		}
+		conn.DB = sql.OpenDB(connector)
+	}
+	if opts.dialect != nil {
+		conn.Dialect = opts.dialect
+	}
 
-	if err := dbkit.InitOpenedDB(conn.DB, cfg, ping); err != nil {
+	if err = dbkit.InitOpenedDB(conn.DB, cfg, ping && opts.pingRetryPolicy == nil); err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
+	if ping && opts.pingRetryPolicy != nil {
+		if err = dbkit.WaitForDB(context.Background(), conn.DB, opts.pingRetryPolicy); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if opts.afterOpen != nil {
+		if err = opts.afterOpen(conn); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
 
 	return conn, nil
 }
@@ -9,13 +9,17 @@ package dbrutil
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/acronis/go-appkit/retry"
 	"github.com/acronis/go-appkit/testutil"
 	"github.com/gocraft/dbr/v2"
+	"github.com/gocraft/dbr/v2/dialect"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
@@ -100,6 +104,65 @@ func TestDbrOpen(t *testing.T) {
 	require.Equal(t, 5, usersCount)
 }
 
+func TestDbrOpen_WithOptions(t *testing.T) {
+	cfg := &dbkit.Config{
+		Dialect:      dbkit.DialectSQLite,
+		SQLite:       dbkit.SQLiteConfig{Path: ":memory:"},
+		MaxOpenConns: 1,
+		MaxIdleConns: 1,
+	}
+
+	t.Run("custom dialect and after open callback", func(t *testing.T) {
+		var afterOpenCalled bool
+		dbConn, err := Open(cfg, true, nil, WithDialect(dialect.PostgreSQL), WithAfterOpen(func(conn *dbr.Connection) error {
+			afterOpenCalled = true
+			return nil
+		}))
+		require.NoError(t, err)
+		defer func() { require.NoError(t, dbConn.Close()) }()
+		require.True(t, afterOpenCalled)
+		require.Equal(t, dialect.PostgreSQL, dbConn.Dialect)
+	})
+
+	t.Run("after open callback error", func(t *testing.T) {
+		afterOpenErr := errors.New("after open error")
+		_, err := Open(cfg, true, nil, WithAfterOpen(func(conn *dbr.Connection) error {
+			return afterOpenErr
+		}))
+		require.ErrorIs(t, err, afterOpenErr)
+	})
+
+	t.Run("ping with retry policy", func(t *testing.T) {
+		dbConn, err := Open(cfg, true, nil, WithPingRetryPolicy(retry.NewConstantBackoffPolicy(time.Millisecond, 3)))
+		require.NoError(t, err)
+		require.NoError(t, dbConn.Close())
+
+		invalidCfg := *cfg
+		invalidCfg.SQLite = dbkit.SQLiteConfig{Path: "/non-existent-dir/test.db"}
+		_, err = Open(&invalidCfg, true, nil, WithPingRetryPolicy(retry.NewConstantBackoffPolicy(time.Millisecond, 2)))
+		require.Error(t, err)
+	})
+	t.Run("connection init sql", func(t *testing.T) {
+		initCfg := *cfg
+		initCfg.ConnectionInitSQL = []string{"CREATE TABLE init_marker (id INTEGER)", "INSERT INTO init_marker VALUES (1)"}
+		dbConn, err := Open(&initCfg, true, nil)
+		require.NoError(t, err)
+		defer func() { require.NoError(t, dbConn.Close()) }()
+		var markersCount int
+		require.NoError(t, dbConn.NewSession(nil).Select("COUNT(*)").From("init_marker").LoadOne(&markersCount))
+		require.Equal(t, 1, markersCount)
+		require.Equal(t, dialect.SQLite3, dbConn.Dialect)
+
+		initCfg.ConnectionInitSQL = []string{"NOT A VALID STATEMENT"}
+		_, err = Open(&initCfg, true, nil)
+		require.ErrorContains(t, err, "connection init sql")
+	})
+	t.Run("driver override is supported only for postgres", func(t *testing.T) {
+		_, err := Open(cfg, true, nil, WithDriverOverride(dbkit.DialectPgx))
+		require.ErrorContains(t, err, "driver override is supported only for postgres dialects")
+	})
+}
+
 func TestDbrSlowQueryLogEventReceiver_TimingKv(t *testing.T) {
 	dbConn := openAndSeedDB(t)
 	defer func() {
@@ -137,6 +200,32 @@ func TestDbrSlowQueryLogEventReceiver_TimingKv(t *testing.T) {
 		require.True(t, sqlFieldFound)
 		require.Equal(t, "query_count_users_by_name", string(logField.Bytes))
 	})
+
+	t.Run("slow queries are sampled", func(t *testing.T) {
+		logRecorder := logtest.NewRecorder()
+		slowQueryEventReceiver := NewSlowQueryLogEventReceiverWithOpts(logRecorder, 0, SlowQueryLogEventReceiverOpts{
+			AnnotationPrefix: "query_",
+			Sampler:          NewSlowQueryLogSampler(3, 0),
+		})
+		dbSess := dbConn.NewSession(slowQueryEventReceiver)
+		for i := 0; i < 7; i++ {
+			countUsersByName(t, dbSess, "query_count_users_by_name", "Bob", 1)
+		}
+		require.Equal(t, 3, len(logRecorder.Entries())) // 1st, 4th and 7th queries.
+	})
+
+	t.Run("slow queries are rate-limited", func(t *testing.T) {
+		logRecorder := logtest.NewRecorder()
+		slowQueryEventReceiver := NewSlowQueryLogEventReceiverWithOpts(logRecorder, 0, SlowQueryLogEventReceiverOpts{
+			AnnotationPrefix: "query_",
+			Sampler:          NewSlowQueryLogSampler(0, 2),
+		})
+		dbSess := dbConn.NewSession(slowQueryEventReceiver)
+		for i := 0; i < 5; i++ {
+			countUsersByName(t, dbSess, "query_count_users_by_name", "Bob", 1)
+		}
+		require.Equal(t, 2, len(logRecorder.Entries()))
+	})
 }
 
 func TestDbrQueryMetricsEventReceiver_TimingKv(t *testing.T) {
@@ -168,6 +257,98 @@ func TestDbrQueryMetricsEventReceiver_TimingKv(t *testing.T) {
 		hist := mc.QueryDurations.With(labels).(prometheus.Histogram)
 		testutil.RequireSamplesCountInHistogram(t, hist, 1)
 	})
+
+	t.Run("long annotation is truncated", func(t *testing.T) {
+		mc := dbkit.NewPrometheusMetrics()
+		metricsEventReceiver := NewQueryMetricsEventReceiverWithOpts(mc, QueryMetricsEventReceiverOpts{
+			AnnotationPrefix:    "query_",
+			MaxAnnotationLength: len("query_count"),
+		})
+		dbSess := dbConn.NewSession(metricsEventReceiver)
+
+		countUsersByName(t, dbSess, "query_count_users_by_name", "Sam", 2)
+
+		labels := prometheus.Labels{dbkit.PrometheusMetricsLabelQuery: "query_count"}
+		hist := mc.QueryDurations.With(labels).(prometheus.Histogram)
+		testutil.RequireSamplesCountInHistogram(t, hist, 1)
+	})
+
+	t.Run("metrics for not allowed annotation are not collected", func(t *testing.T) {
+		mc := dbkit.NewPrometheusMetrics()
+		metricsEventReceiver := NewQueryMetricsEventReceiverWithOpts(mc, QueryMetricsEventReceiverOpts{
+			AnnotationPrefix:   "query_",
+			AllowedAnnotations: []string{"query_count_users_by_name"},
+		})
+		dbSess := dbConn.NewSession(metricsEventReceiver)
+
+		countUsersByName(t, dbSess, "query_count_users_by_name", "Sam", 2)
+		countUsersByName(t, dbSess, "query_count_users_by_name_42", "Sam", 2)
+
+		labels := prometheus.Labels{dbkit.PrometheusMetricsLabelQuery: "query_count_users_by_name"}
+		testutil.RequireSamplesCountInHistogram(t, mc.QueryDurations.With(labels).(prometheus.Histogram), 1)
+		labels = prometheus.Labels{dbkit.PrometheusMetricsLabelQuery: "query_count_users_by_name_42"}
+		testutil.RequireSamplesCountInHistogram(t, mc.QueryDurations.With(labels).(prometheus.Histogram), 0)
+	})
+}
+
+func TestTruncateAnnotation(t *testing.T) {
+	require.Equal(t, "query_count", truncateAnnotation("query_count", 20))
+	require.Equal(t, "query", truncateAnnotation("query_count", 5))
+	// "ü" takes 2 bytes, so it's dropped entirely instead of being split.
+	require.Equal(t, "query_gr", truncateAnnotation("query_grüße", 9))
+	require.Equal(t, "query_grü", truncateAnnotation("query_grüße", 10))
+	require.True(t, utf8.ValidString(truncateAnnotation("запрос_пользователей", 7)))
+	require.Equal(t, "", truncateAnnotation("я", 1))
+}
+
+func TestForEachRow(t *testing.T) {
+	dbConn := openAndSeedDB(t)
+	defer func() {
+		require.NoError(t, dbConn.Close())
+	}()
+
+	mc := dbkit.NewPrometheusMetrics()
+	dbSess := dbConn.NewSession(NewQueryMetricsEventReceiver(mc, "query_"))
+
+	t.Run("all rows are iterated", func(t *testing.T) {
+		var names []string
+		err := ForEachRow(context.Background(),
+			dbSess.Select("id", "name").From("users").OrderBy("id").Comment("query_stream_users"),
+			func(scan func(dest ...interface{}) error) error {
+				var id int64
+				var name string
+				if err := scan(&id, &name); err != nil {
+					return err
+				}
+				names = append(names, name)
+				return nil
+			})
+		require.NoError(t, err)
+		require.Equal(t, []string{"Albert", "Bob", "John", "Sam", "Sam"}, names)
+
+		labels := prometheus.Labels{dbkit.PrometheusMetricsLabelQuery: "query_stream_users"}
+		testutil.RequireSamplesCountInHistogram(t, mc.QueryDurations.With(labels).(prometheus.Histogram), 1)
+	})
+
+	t.Run("iteration is stopped on error", func(t *testing.T) {
+		stopErr := errors.New("stop")
+		var iterated int
+		err := ForEachRow(context.Background(), dbSess.Select("name").From("users"),
+			func(scan func(dest ...interface{}) error) error {
+				iterated++
+				return stopErr
+			})
+		require.ErrorIs(t, err, stopErr)
+		require.Equal(t, 1, iterated)
+	})
+
+	t.Run("query error is returned", func(t *testing.T) {
+		err := ForEachRow(context.Background(), dbSess.Select("name").From("unknown_table"),
+			func(scan func(dest ...interface{}) error) error {
+				return nil
+			})
+		require.ErrorContains(t, err, "no such table")
+	})
 }
 
 func addExclamation(s string) string {
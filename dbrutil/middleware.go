@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/acronis/go-appkit/httpserver/middleware"
+	"github.com/acronis/go-appkit/log"
 	"github.com/gocraft/dbr/v2"
 )
 
@@ -29,6 +30,14 @@ type TxRunnerMiddlewareOpts struct {
 	SlowQueryLog struct {
 		MinTime          time.Duration
 		AnnotationPrefix string
+
+		// LogFields returns additional request-scoped fields (e.g. user ID) for the slow query logs.
+		// They are added to the request's logger (see middleware.GetLoggerFromContext),
+		// which already carries the request's correlation fields (e.g. request ID).
+		LogFields func(r *http.Request) []log.Field
+
+		// Sampler limits the number of logged slow queries across all requests. nil means no limit.
+		Sampler *SlowQueryLogSampler
 	}
 	NewTxRunner NewTxRunnerFunc
 }
@@ -85,13 +94,15 @@ func (m *txRunnerHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 	dbEventReceiver := m.dbConn.EventReceiver
 	if m.opts.SlowQueryLog.MinTime > 0 {
-		slowLogEventReceiver := NewSlowQueryLogEventReceiver(
-			middleware.GetLoggerFromContext(reqCtx), m.opts.SlowQueryLog.MinTime, m.opts.SlowQueryLog.AnnotationPrefix)
-		if dbEventReceiver != nil {
-			dbEventReceiver = NewCompositeReceiver([]dbr.EventReceiver{dbEventReceiver, slowLogEventReceiver})
-		} else {
-			dbEventReceiver = slowLogEventReceiver
+		logger := middleware.GetLoggerFromContext(reqCtx)
+		if logger != nil && m.opts.SlowQueryLog.LogFields != nil {
+			logger = logger.With(m.opts.SlowQueryLog.LogFields(r)...)
 		}
+		dbEventReceiver = NewEventReceiverWithSlowQueryLogOpts(dbEventReceiver, logger, m.opts.SlowQueryLog.MinTime,
+			SlowQueryLogEventReceiverOpts{
+				AnnotationPrefix: m.opts.SlowQueryLog.AnnotationPrefix,
+				Sampler:          m.opts.SlowQueryLog.Sampler,
+			})
 	}
 
 	dbSess := m.opts.NewTxRunner(m.dbConn, m.txOpts, dbEventReceiver)
@@ -14,6 +14,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/acronis/go-appkit/httpserver/middleware"
+	"github.com/acronis/go-appkit/log"
+	"github.com/acronis/go-appkit/log/logtest"
 	"github.com/acronis/go-appkit/retry"
 	"github.com/gocraft/dbr/v2"
 	"github.com/stretchr/testify/require"
@@ -59,3 +62,66 @@ func TestTxRunnerMiddlewareUsesSessionFactory(t *testing.T) {
 	middleware.ServeHTTP(resp, req)
 	require.True(t, passed, "Implementation of middleware.ServeHTTP must use opts.NewSession!")
 }
+
+func TestTxRunnerMiddleware_SlowQueryLogFields(t *testing.T) {
+	dbConn := openAndSeedDB(t)
+	defer func() {
+		require.NoError(t, dbConn.Close())
+	}()
+
+	var opts TxRunnerMiddlewareOpts
+	opts.SlowQueryLog.MinTime = time.Nanosecond
+	opts.SlowQueryLog.AnnotationPrefix = "query_"
+	opts.SlowQueryLog.LogFields = func(r *http.Request) []log.Field {
+		return []log.Field{log.String("user_id", r.Header.Get("X-User-ID"))}
+	}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		require.NoError(t, GetTxRunnerFromContext(r.Context()).DoInTx(r.Context(), func(runner dbr.SessionRunner) error {
+			countUsersByName(t, runner, "query_count_users_by_name", "Sam", 2)
+			return nil
+		}))
+	})
+	handler := TxRunnerMiddlewareWithOpts(dbConn, sql.LevelDefault, opts)(next)
+
+	logRecorder := logtest.NewRecorder()
+	reqLogger := logRecorder.With(log.String("request_id", "req-1"))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-User-ID", "user-1")
+	req = req.WithContext(middleware.NewContextWithLogger(req.Context(), reqLogger))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	logEntry, found := logRecorder.FindEntry("slow SQL query")
+	require.True(t, found)
+	for key, wantValue := range map[string]string{"request_id": "req-1", "user_id": "user-1"} {
+		field, ok := logEntry.FindField(key)
+		require.True(t, ok, "field %q not found", key)
+		require.Equal(t, wantValue, string(field.Bytes))
+	}
+}
+
+func TestTxRunnerMiddleware_SlowQueryLogSampler(t *testing.T) {
+	dbConn := openAndSeedDB(t)
+	defer func() {
+		require.NoError(t, dbConn.Close())
+	}()
+
+	var opts TxRunnerMiddlewareOpts
+	opts.SlowQueryLog.MinTime = time.Nanosecond
+	opts.SlowQueryLog.AnnotationPrefix = "query_"
+	opts.SlowQueryLog.Sampler = NewSlowQueryLogSampler(0, 2)
+	next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		require.NoError(t, GetTxRunnerFromContext(r.Context()).DoInTx(r.Context(), func(runner dbr.SessionRunner) error {
+			countUsersByName(t, runner, "query_count_users_by_name", "Sam", 2)
+			return nil
+		}))
+	})
+	handler := TxRunnerMiddlewareWithOpts(dbConn, sql.LevelDefault, opts)(next)
+
+	logRecorder := logtest.NewRecorder()
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req = req.WithContext(middleware.NewContextWithLogger(req.Context(), logRecorder))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	require.Equal(t, 2, len(logRecorder.Entries())) // The limit is shared by receivers of all requests.
+}
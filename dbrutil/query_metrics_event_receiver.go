@@ -8,6 +8,7 @@ package dbrutil
 
 import (
 	"time"
+	"unicode/utf8"
 
 	"github.com/gocraft/dbr/v2"
 )
@@ -21,6 +22,15 @@ type MetricsCollector interface {
 type QueryMetricsEventReceiverOpts struct {
 	AnnotationPrefix   string
 	AnnotationModifier func(string) string
+
+	// MaxAnnotationLength limits the length of the annotation that is used as a label value.
+	// Longer annotations are truncated (on the UTF-8 rune boundary, so the limit is in bytes). 0 means no limit.
+	MaxAnnotationLength int
+
+	// AllowedAnnotations is a list of annotations that are allowed to be used as label values.
+	// Metrics for queries with other annotations are not collected. Empty list means all annotations are allowed.
+	// It protects the metrics registry from the cardinality explosion when an annotation is built dynamically by mistake.
+	AllowedAnnotations []string
 }
 
 // QueryMetricsEventReceiver implements the dbr.EventReceiver interface and collects metrics about SQL queries.
@@ -30,16 +40,28 @@ type QueryMetricsEventReceiver struct {
 	metricsCollector   MetricsCollector
 	annotationPrefix   string
 	annotationModifier func(string) string
+
+	maxAnnotationLength int
+	allowedAnnotations  map[string]struct{}
 }
 
 // NewQueryMetricsEventReceiverWithOpts creates a new QueryMetricsEventReceiver with additinal options.
 func NewQueryMetricsEventReceiverWithOpts(
 	mc MetricsCollector, options QueryMetricsEventReceiverOpts,
 ) *QueryMetricsEventReceiver {
+	var allowedAnnotations map[string]struct{}
+	if len(options.AllowedAnnotations) != 0 {
+		allowedAnnotations = make(map[string]struct{}, len(options.AllowedAnnotations))
+		for _, annotation := range options.AllowedAnnotations {
+			allowedAnnotations[annotation] = struct{}{}
+		}
+	}
 	return &QueryMetricsEventReceiver{
-		metricsCollector:   mc,
-		annotationPrefix:   options.AnnotationPrefix,
-		annotationModifier: options.AnnotationModifier,
+		metricsCollector:    mc,
+		annotationPrefix:    options.AnnotationPrefix,
+		annotationModifier:  options.AnnotationModifier,
+		maxAnnotationLength: options.MaxAnnotationLength,
+		allowedAnnotations:  allowedAnnotations,
 	}
 }
 
@@ -58,5 +80,26 @@ func (er *QueryMetricsEventReceiver) TimingKv(eventName string, nanoseconds int6
 	if annotation == "" {
 		return
 	}
+	if er.allowedAnnotations != nil {
+		if _, ok := er.allowedAnnotations[annotation]; !ok {
+			return
+		}
+	}
+	if er.maxAnnotationLength > 0 {
+		annotation = truncateAnnotation(annotation, er.maxAnnotationLength)
+	}
 	er.metricsCollector.ObserveQueryDuration(annotation, time.Duration(nanoseconds))
 }
+
+// truncateAnnotation truncates the annotation to maxLen bytes on the rune boundary,
+// so the result is valid UTF-8 (it's required for the label value).
+func truncateAnnotation(annotation string, maxLen int) string {
+	if len(annotation) <= maxLen {
+		return annotation
+	}
+	end := maxLen
+	for end > 0 && !utf8.RuneStart(annotation[end]) {
+		end--
+	}
+	return annotation[:end]
+}
@@ -0,0 +1,38 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbrutil
+
+import (
+	"context"
+
+	"github.com/gocraft/dbr/v2"
+)
+
+// ForEachRow executes the select statement and calls fn for each row of the result set one by one,
+// so large result sets may be streamed (e.g. for exporting) without loading all rows into memory as dbr's Load* methods do.
+// fn receives the scan function that copies columns of the current row into the values pointed at by dest (see sql.Rows.Scan).
+// Iteration is stopped if fn returns an error, and this error is returned.
+// The statement is executed via dbr.SelectStmt.RowsContext, so the session's event receivers
+// (e.g. QueryMetricsEventReceiver and SlowQueryLogEventReceiver) observe it as usual.
+// Keep in mind that the observed duration covers only the query execution, not the iteration over the rows.
+func ForEachRow(ctx context.Context, stmt *dbr.SelectStmt, fn func(scan func(dest ...interface{}) error) error) (err error) {
+	rows, err := stmt.RowsContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := rows.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+	for rows.Next() {
+		if err = fn(rows.Scan); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -7,6 +7,8 @@ Released under MIT license.
 package dbrutil
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/acronis/go-appkit/log"
@@ -17,6 +19,55 @@ import (
 type SlowQueryLogEventReceiverOpts struct {
 	AnnotationPrefix   string
 	AnnotationModifier func(string) string
+
+	// Sampler decides which slow queries are logged. nil means that all slow queries are logged.
+	// The same sampler may be shared by many receivers (e.g. ones created per request by TxRunnerMiddleware),
+	// so the limits are applied to all of them together.
+	Sampler *SlowQueryLogSampler
+}
+
+// SlowQueryLogSampler limits the number of logged slow queries. It's safe for concurrent use.
+type SlowQueryLogSampler struct {
+	sampleEvery      uint64
+	slowQueriesCount atomic.Uint64
+
+	maxLogsPerSecond int
+	rateMu           sync.Mutex
+	rateWindowStart  time.Time
+	rateWindowLogs   int
+}
+
+// NewSlowQueryLogSampler creates a new SlowQueryLogSampler.
+// sampleEvery makes it pass only every N-th slow query (1 in N), 0 and 1 mean that all slow queries are passed.
+// maxLogsPerSecond limits the number of slow queries passed per second, 0 means no limit.
+// It protects from log storms when a whole table becomes slow and thousands of queries cross the threshold at once.
+func NewSlowQueryLogSampler(sampleEvery uint64, maxLogsPerSecond int) *SlowQueryLogSampler {
+	return &SlowQueryLogSampler{sampleEvery: sampleEvery, maxLogsPerSecond: maxLogsPerSecond}
+}
+
+// Sample decides whether the current slow query should be logged.
+func (s *SlowQueryLogSampler) Sample() bool {
+	if s == nil {
+		return true
+	}
+	if s.sampleEvery > 1 && (s.slowQueriesCount.Add(1)-1)%s.sampleEvery != 0 {
+		return false
+	}
+	if s.maxLogsPerSecond <= 0 {
+		return true
+	}
+	s.rateMu.Lock()
+	defer s.rateMu.Unlock()
+	now := time.Now()
+	if now.Sub(s.rateWindowStart) >= time.Second {
+		s.rateWindowStart = now
+		s.rateWindowLogs = 0
+	}
+	if s.rateWindowLogs >= s.maxLogsPerSecond {
+		return false
+	}
+	s.rateWindowLogs++
+	return true
 }
 
 // SlowQueryLogEventReceiver implements the dbr.EventReceiver interface and logs long SQL queries.
@@ -27,6 +78,7 @@ type SlowQueryLogEventReceiver struct {
 	longQueryTime      time.Duration
 	annotationPrefix   string
 	annotationModifier func(string) string
+	sampler            *SlowQueryLogSampler
 }
 
 // NewSlowQueryLogEventReceiverWithOpts creates a new SlowQueryLogEventReceiver with additional options.
@@ -39,6 +91,7 @@ func NewSlowQueryLogEventReceiverWithOpts(
 		longQueryTime:      longQueryTime,
 		annotationPrefix:   options.AnnotationPrefix,
 		annotationModifier: options.AnnotationModifier,
+		sampler:            options.Sampler,
 	}
 }
 
@@ -51,6 +104,30 @@ func NewSlowQueryLogEventReceiver(logger log.FieldLogger, longQueryTime time.Dur
 	return NewSlowQueryLogEventReceiverWithOpts(logger, longQueryTime, opts)
 }
 
+// NewEventReceiverWithSlowQueryLog returns an event receiver that passes all events to the base receiver (if it's not nil)
+// and additionally logs slow annotated SQL queries using SlowQueryLogEventReceiver.
+// dbr doesn't pass the query's context to event receivers, so to have request-scoped fields (request ID, trace ID, user)
+// in the slow query logs, the receiver should be created per request (or per job) with the logger that carries them
+// (e.g. middleware.GetLoggerFromContext) and passed to the TxRunner. TxRunnerMiddleware does it for each request.
+func NewEventReceiverWithSlowQueryLog(
+	base dbr.EventReceiver, logger log.FieldLogger, longQueryTime time.Duration, annotationPrefix string,
+) dbr.EventReceiver {
+	return NewEventReceiverWithSlowQueryLogOpts(base, logger, longQueryTime, SlowQueryLogEventReceiverOpts{
+		AnnotationPrefix: annotationPrefix,
+	})
+}
+
+// NewEventReceiverWithSlowQueryLogOpts is a more configurable version of NewEventReceiverWithSlowQueryLog.
+func NewEventReceiverWithSlowQueryLogOpts(
+	base dbr.EventReceiver, logger log.FieldLogger, longQueryTime time.Duration, options SlowQueryLogEventReceiverOpts,
+) dbr.EventReceiver {
+	slowLogEventReceiver := NewSlowQueryLogEventReceiverWithOpts(logger, longQueryTime, options)
+	if base == nil {
+		return slowLogEventReceiver
+	}
+	return NewCompositeReceiver([]dbr.EventReceiver{base, slowLogEventReceiver})
+}
+
 // TimingKv is called when SQL query is executed. It receives the duration of how long the query takes,
 // parses annotation from SQL comment and logs last if execution time is long.
 func (er *SlowQueryLogEventReceiver) TimingKv(eventName string, nanoseconds int64, kvs map[string]string) {
@@ -61,6 +138,9 @@ func (er *SlowQueryLogEventReceiver) TimingKv(eventName string, nanoseconds int6
 	if annotation == "" {
 		return
 	}
+	if !er.sampler.Sample() {
+		return
+	}
 	er.logger.Warn("slow SQL query",
 		log.String("annotation", annotation),
 		log.Int64("duration_ms", nanoseconds/int64(time.Millisecond)),
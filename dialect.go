@@ -0,0 +1,75 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// ErrUnknownDialect is returned by DetectDialect when the dialect cannot be detected.
+var ErrUnknownDialect = errors.New("unknown sql dialect")
+
+// mySQLVersionRe matches the version() output of MySQL and MariaDB (e.g. "8.0.36", "5.7.44-log" or "10.11.6-MariaDB").
+var mySQLVersionRe = regexp.MustCompile(`^\d+\.\d+\.\d+(?:[-+~].*)?$`)
+
+// pgxStdlibPkgPath is the path of the package that contains the database/sql driver of pgx.
+// It's compared by path, so the driver is not registered (and pgx is not linked) by importing this package.
+const pgxStdlibPkgPath = "github.com/jackc/pgx/v5/stdlib"
+
+// DetectDialect detects SQL dialect of already opened database by running lightweight probe queries
+// (SELECT sqlite_version(), SELECT version(), SELECT @@VERSION) and matching their output.
+// Since Postgres may be used via both github.com/lib/pq and github.com/jackc/pgx drivers,
+// DialectPgx is returned for Postgres if the database was opened with pgx driver.
+// MySQL is detected by the version number format, so other databases that support version() are not misclassified.
+// ErrUnknownDialect is returned if none of the probes succeeded or the output wasn't recognized.
+func DetectDialect(ctx context.Context, db *sql.DB) (Dialect, error) {
+	var version string
+	if err := db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version); err == nil {
+		return DialectSQLite, nil
+	}
+
+	if err := db.QueryRowContext(ctx, "SELECT version()").Scan(&version); err == nil {
+		switch {
+		case strings.Contains(version, "PostgreSQL"):
+			if isPgxDriver(db.Driver()) {
+				return DialectPgx, nil
+			}
+			return DialectPostgres, nil
+		case mySQLVersionRe.MatchString(version):
+			// MySQL and MariaDB return just a version number.
+			return DialectMySQL, nil
+		}
+	}
+
+	if err := db.QueryRowContext(ctx, "SELECT @@VERSION").Scan(&version); err == nil &&
+		strings.Contains(version, "Microsoft SQL Server") {
+		return DialectMSSQL, nil
+	}
+
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	return "", ErrUnknownDialect
+}
+
+// isPgxDriver checks whether the database was opened with github.com/jackc/pgx driver (stdlib package).
+func isPgxDriver(drv driver.Driver) bool {
+	t := reflect.TypeOf(drv)
+	if t == nil {
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.PkgPath() == pgxStdlibPkgPath
+}
@@ -11,9 +11,13 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"math/rand"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 
 	"github.com/acronis/go-dbkit"
@@ -25,23 +29,82 @@ const DefaultTableName = "distributed_locks"
 
 // DBManager provides management functionality for distributed locks based on the SQL database.
 type DBManager struct {
-	queries dbQueries
+	queries      dbQueries
+	stats        *keyStatsTracker
+	keyNamespace string
+	trackOwner   bool
+	owner        string
 }
 
 // DBManagerOption is an option for NewDBManager.
 type DBManagerOption func(*dbManagerOptions)
 
 type dbManagerOptions struct {
-	tableName string
+	tableName    string
+	statsEnabled bool
+	statsMaxKeys int
+	keyNamespace string
+	trackOwner   bool
+	owner        string
+
+	createTableSQL string
 }
 
 // WithTableName sets a custom table name for the table that stores distributed locks.
+// The name must not exceed the identifier length limit of the SQL dialect (see dbkit.MaxIdentifierLength).
 func WithTableName(tableName string) DBManagerOption {
 	return func(o *dbManagerOptions) {
 		o.tableName = tableName
 	}
 }
 
+// WithStats enables tracking of successful and contended (failed because the lock is held by someone else)
+// acquisitions per lock key. Stats may be retrieved via DBManager.Stats.
+// At most maxKeys distinct keys are tracked, the least recently used ones are evicted.
+// If maxKeys is not positive, DefaultStatsMaxKeys is used.
+func WithStats(maxKeys int) DBManagerOption {
+	return func(o *dbManagerOptions) {
+		o.statsEnabled = true
+		o.statsMaxKeys = maxKeys
+	}
+}
+
+// WithKeyNamespace sets a namespace that prefixes every lock key created by the DBManager (as "<namespace>:<key>").
+// It allows partitioning the lock space per service or module without coordinating on global key naming.
+// Lock key length limit (40 symbols) is applied to the prefixed key.
+func WithKeyNamespace(namespace string) DBManagerOption {
+	return func(o *dbManagerOptions) {
+		o.keyNamespace = namespace
+	}
+}
+
+// maxOwnerLength is a max length of the lock owner (see WithOwner).
+const maxOwnerLength = 255
+
+// WithOwner enables tracking of the lock owner and the time when the lock was acquired.
+// They are stored in the optional "owner" and "acquired_at" columns on each acquisition
+// and may be retrieved via DBManager.LockStatus (e.g. for finding out which pod holds the lock and since when).
+// If the owner is empty, the hostname is used.
+// The columns are added to the existing table by DBManager.CreateTable and DBManager.Migrations,
+// so they must be called (or the migrations must be applied) before acquiring locks.
+func WithOwner(owner string) DBManagerOption {
+	return func(o *dbManagerOptions) {
+		o.trackOwner = true
+		o.owner = owner
+	}
+}
+
+// WithCreateTableSQL sets a custom SQL statement for creating the table that stores distributed locks.
+// It may be used for satisfying organizational schema policies (e.g. audit columns or a specific tablespace).
+// The statement must create the table with the name configured for the DBManager (see WithTableName)
+// and with all the standard columns (see CreateTableSQL for the default statement), since they are used by the lock queries.
+// The statement is returned by DBManager.CreateTableSQL and used by DBManager.CreateTable and DBManager.Migrations.
+func WithCreateTableSQL(createTableSQL string) DBManagerOption {
+	return func(o *dbManagerOptions) {
+		o.createTableSQL = createTableSQL
+	}
+}
+
 // NewDBManager creates a new distributed lock manager that uses SQL database as a backend.
 func NewDBManager(dialect dbkit.Dialect, options ...DBManagerOption) (*DBManager, error) {
 	var opts dbManagerOptions
@@ -51,19 +114,56 @@ func NewDBManager(dialect dbkit.Dialect, options ...DBManagerOption) (*DBManager
 	if opts.tableName == "" {
 		opts.tableName = DefaultTableName
 	}
+	if maxLen := dbkit.MaxIdentifierLength(dialect); maxLen > 0 && len(opts.tableName) > maxLen {
+		return nil, fmt.Errorf("table name %q is too long for %s dialect: %d characters, max %d",
+			opts.tableName, dialect, len(opts.tableName), maxLen)
+	}
 	q, err := newDBQueries(dialect, opts.tableName)
 	if err != nil {
 		return nil, err
 	}
-	return &DBManager{q}, nil
+	if opts.createTableSQL != "" {
+		q.createTable = opts.createTableSQL
+	}
+	if opts.trackOwner {
+		if opts.owner == "" {
+			if opts.owner, err = os.Hostname(); err != nil {
+				return nil, fmt.Errorf("get hostname for lock owner: %w", err)
+			}
+		}
+		if len(opts.owner) > maxOwnerLength {
+			return nil, fmt.Errorf("lock owner %q is too long: %d characters, max %d",
+				opts.owner, len(opts.owner), maxOwnerLength)
+		}
+	}
+	m := &DBManager{queries: q, keyNamespace: opts.keyNamespace, trackOwner: opts.trackOwner, owner: opts.owner}
+	if opts.statsEnabled {
+		m.stats = newKeyStatsTracker(opts.statsMaxKeys)
+	}
+	return m, nil
+}
+
+// Stats returns acquisition counters per lock key since the DBManager creation.
+// Nil is returned if stats are not enabled (see WithStats).
+func (m *DBManager) Stats() map[string]LockKeyStats {
+	if m.stats == nil {
+		return nil
+	}
+	return m.stats.snapshot()
 }
 
 // Migrations returns set of migrations that must be applied before creating new locks.
+// If the owner tracking is enabled (see WithOwner), the migration that adds the owner columns is included too.
 func (m *DBManager) Migrations() []migrate.Migration {
-	return []migrate.Migration{
+	migrations := []migrate.Migration{
 		migrate.NewCustomMigration(createTableMigrationID,
 			[]string{m.CreateTableSQL()}, []string{m.DropTableSQL()}, nil, nil),
 	}
+	if m.trackOwner {
+		migrations = append(migrations, migrate.NewCustomMigration(addOwnerColumnsMigrationID,
+			m.queries.addOwnerColumns, m.queries.dropOwnerColumns, nil, nil))
+	}
+	return migrations
 }
 
 // CreateTableSQL returns SQL query for creating a table that stores distributed locks.
@@ -71,16 +171,148 @@ func (m *DBManager) CreateTableSQL() string {
 	return m.queries.createTable
 }
 
+// CreateTable creates a table that stores distributed locks (if it doesn't exist).
+// The query is executed under the passed context, so it may be used with a timeout
+// for preventing startup from hanging when DDL is blocked.
+// If the owner tracking is enabled (see WithOwner), the owner columns are added to the table if they don't exist.
+func (m *DBManager) CreateTable(ctx context.Context, executor SQLExecutor) error {
+	if _, err := executor.ExecContext(ctx, m.queries.createTable); err != nil {
+		return fmt.Errorf("create distributed locks table: %w", err)
+	}
+	if !m.trackOwner {
+		return nil
+	}
+	for _, query := range m.queries.addOwnerColumns {
+		if _, err := executor.ExecContext(ctx, query); err != nil && !isDuplicateColumnError(err) {
+			return fmt.Errorf("add owner columns to distributed locks table: %w", err)
+		}
+	}
+	return nil
+}
+
 // DropTableSQL returns SQL query for dropping a table that stores distributed locks.
 func (m *DBManager) DropTableSQL() string {
 	return m.queries.dropTable
 }
 
+// DropTable drops a table that stores distributed locks (if it exists).
+func (m *DBManager) DropTable(ctx context.Context, executor SQLExecutor) error {
+	if _, err := executor.ExecContext(ctx, m.queries.dropTable); err != nil {
+		return fmt.Errorf("drop distributed locks table: %w", err)
+	}
+	return nil
+}
+
+// HeldLockCount returns a number of currently held (i.e. acquired and not expired) locks in the table.
+// All locks in the table are counted regardless of the key namespace (see WithKeyNamespace).
+// Lock state is not changed, so it may be used for monitoring (see PrometheusMetrics.CollectHeldLocks).
+func (m *DBManager) HeldLockCount(ctx context.Context, executor SQLQueryExecutor) (int, error) {
+	var count int
+	if err := executor.QueryRowContext(ctx, m.queries.countHeldLocks).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count held locks: %w", err)
+	}
+	return count, nil
+}
+
+// LockStatus represents the state of the lock in the database.
+type LockStatus struct {
+	Key  string
+	Held bool // True if the lock is acquired and not expired.
+
+	// Owner and AcquiredAt contain the owner of the last acquisition and the time when the lock was acquired by it.
+	// They are filled only if the owner tracking is enabled (see WithOwner),
+	// and they are kept after the release, so they describe the last holder if the lock is not held.
+	Owner      string
+	AcquiredAt time.Time
+}
+
+// LockStatus returns the state of the lock with the passed key.
+// The key namespace (see WithKeyNamespace) is applied to the key in the same way as in NewLock.
+// Lock state is not changed, so it may be used for debugging and monitoring.
+// sql.ErrNoRows is returned (wrapped) if the lock is not initialized.
+func (m *DBManager) LockStatus(ctx context.Context, executor SQLQueryExecutor, key string) (LockStatus, error) {
+	if m.keyNamespace != "" {
+		key = m.keyNamespace + ":" + key
+	}
+	status := LockStatus{Key: key}
+	query := m.queries.lockStatus
+	dest := []interface{}{&status.Held}
+	var owner sql.NullString
+	var acquiredAt sql.NullTime
+	var acquiredAtUnix sql.NullInt64
+	if m.trackOwner {
+		query = m.queries.lockStatusWithOwner
+		dest = append(dest, &owner)
+		if m.queries.unixTimestamps {
+			dest = append(dest, &acquiredAtUnix)
+		} else {
+			dest = append(dest, &acquiredAt)
+		}
+	}
+	if err := executor.QueryRowContext(ctx, query, key).Scan(dest...); err != nil {
+		return LockStatus{}, fmt.Errorf("get status of lock with key %s: %w", key, err)
+	}
+	status.Owner = owner.String
+	if acquiredAt.Valid {
+		status.AcquiredAt = acquiredAt.Time
+	}
+	if acquiredAtUnix.Valid {
+		status.AcquiredAt = mySQLParseTimestamp(acquiredAtUnix.Int64)
+	}
+	return status, nil
+}
+
+// selfTestLockTTL is a TTL of the throwaway lock that is used by DBManager.SelfTest.
+const selfTestLockTTL = 10 * time.Second
+
+// SelfTest checks that the distributed locks subsystem works end to end: the table exists,
+// and a lock may be initialized, acquired, extended and released.
+// A uniquely named throwaway lock is used, so real locks are not affected. The lock is deleted afterward.
+// It may be used in readiness checks or at startup before depending on the locks for critical jobs.
+func (m *DBManager) SelfTest(ctx context.Context, dbConn *sql.DB) (err error) {
+	key := "selftest-" + strings.ReplaceAll(uuid.NewString(), "-", "")[:16]
+	if err = dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+		_, execErr := tx.ExecContext(ctx, m.queries.initLock, key)
+		return execErr
+	}); err != nil {
+		return fmt.Errorf("init lock: %w", err)
+	}
+	defer func() {
+		if deleteErr := dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			_, execErr := tx.ExecContext(ctx, m.queries.deleteLock, key)
+			return execErr
+		}); deleteErr != nil && err == nil {
+			err = fmt.Errorf("delete lock: %w", deleteErr)
+		}
+	}()
+
+	// Throwaway lock shouldn't affect stats.
+	lock := DBLock{Key: key, manager: &DBManager{queries: m.queries, trackOwner: m.trackOwner, owner: m.owner}}
+	steps := []struct {
+		name string
+		fn   func(tx *sql.Tx) error
+	}{
+		{"acquire", func(tx *sql.Tx) error { return lock.Acquire(ctx, tx, selfTestLockTTL) }},
+		{"extend", func(tx *sql.Tx) error { return lock.Extend(ctx, tx) }},
+		{"release", func(tx *sql.Tx) error { return lock.Release(ctx, tx) }},
+	}
+	for _, step := range steps {
+		if err = dbkit.DoInTx(ctx, dbConn, step.fn); err != nil {
+			return fmt.Errorf("%s lock: %w", step.name, err)
+		}
+	}
+	return nil
+}
+
 // NewLock creates new initialized (but not acquired) distributed lock.
+// If the key namespace is set (see WithKeyNamespace), DBLock.Key contains the prefixed key.
 func (m *DBManager) NewLock(ctx context.Context, executor SQLExecutor, key string) (DBLock, error) {
 	if key == "" {
 		return DBLock{}, fmt.Errorf("lock key cannot be empty")
 	}
+	if m.keyNamespace != "" {
+		key = m.keyNamespace + ":" + key
+	}
 	if len(key) > 40 {
 		return DBLock{}, fmt.Errorf("lock key cannot be longer than 40 symbols")
 	}
@@ -113,8 +345,14 @@ func (l *DBLock) Acquire(ctx context.Context, executor SQLExecutor, lockTTL time
 // Please use Acquire instead of this method unless you have a good reason to use it.
 func (l *DBLock) AcquireWithStaticToken(ctx context.Context, executor SQLExecutor, token string, lockTTL time.Duration) error {
 	interval := l.manager.queries.intervalMaker(lockTTL)
-	err := execQueryAndCheckAffectedRow(ctx, executor, l.manager.queries.acquireLock,
-		[]interface{}{interval, token, l.Key, token}, ErrLockAlreadyAcquired)
+	query, args := l.manager.queries.acquireLock, []interface{}{interval, token, l.Key, token}
+	if l.manager.trackOwner {
+		query, args = l.manager.queries.acquireLockWithOwner, []interface{}{token, interval, token, l.manager.owner, l.Key, token}
+	}
+	err := execQueryAndCheckAffectedRow(ctx, executor, query, args, ErrLockAlreadyAcquired)
+	if l.manager.stats != nil && (err == nil || errors.Is(err, ErrLockAlreadyAcquired)) {
+		l.manager.stats.record(l.Key, err == nil)
+	}
 	if err != nil {
 		return err
 	}
@@ -137,6 +375,25 @@ func (l *DBLock) Extend(ctx context.Context, executor SQLExecutor) error {
 		l.manager.queries.extendLock, []interface{}{interval, l.Key, l.token}, ErrLockAlreadyReleased)
 }
 
+// TimeRemaining returns how much time is left until the lock acquired by this DBLock expires.
+// Zero or negative duration is returned if the lock is already expired, released or acquired by someone else
+// (or if it has never been acquired).
+// It's computed by the database clock, so it may be used to decide when to call Extend
+// or to detect that the lock expires earlier than expected (e.g. because of clock skew between the hosts).
+func (l *DBLock) TimeRemaining(ctx context.Context, executor SQLQueryExecutor) (time.Duration, error) {
+	if l.token == "" {
+		return 0, nil
+	}
+	var remainingMicros int64
+	if err := executor.QueryRowContext(ctx, l.manager.queries.timeRemaining, l.Key, l.token).Scan(&remainingMicros); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("get remaining time of lock with key %s: %w", l.Key, err)
+	}
+	return time.Duration(remainingMicros) * time.Microsecond, nil
+}
+
 // Token returns token of the last acquired lock.
 // May be used in logs to make the investigation process easier.
 func (l *DBLock) Token() string {
@@ -153,6 +410,10 @@ type doOptions struct {
 	periodicExtendInterval time.Duration
 	releaseTimeout         time.Duration
 	logger                 Logger
+	dbClockExtension       bool
+
+	acquireRetryMaxWait      time.Duration
+	acquireRetryBaseInterval time.Duration
 }
 
 // DoOption is an option for DoExclusively method.
@@ -186,12 +447,43 @@ func WithLogger(logger Logger) DoOption {
 	}
 }
 
+// WithAcquireRetry makes DoExclusively retry the lock acquisition while it's acquired by someone else
+// (ErrLockAlreadyAcquired is returned) until the context is canceled.
+// Interval between attempts starts from baseInterval and doubles after each attempt up to maxWait.
+// Each interval is randomly jittered, so simultaneously started instances don't retry at the same time.
+// It allows implementing a simple leader election: the instance that holds the lock is a leader,
+// others wait and take over promptly when the leader dies.
+func WithAcquireRetry(maxWait, baseInterval time.Duration) DoOption {
+	return func(o *doOptions) {
+		o.acquireRetryMaxWait = maxWait
+		o.acquireRetryBaseInterval = baseInterval
+	}
+}
+
+// WithDBClockExtension makes the periodic lock extension timing follow the database clock.
+// After acquiring and after each extension, the remaining lock TTL is queried (see DBLock.TimeRemaining)
+// and the next extension is scheduled at half of it instead of using the fixed interval.
+// If the lock turns out to be already expired, the context passed to the function is canceled.
+// If the remaining TTL can't be queried, the interval from WithPeriodicExtendInterval option is used.
+func WithDBClockExtension() DoOption {
+	return func(o *doOptions) {
+		o.dbClockExtension = true
+	}
+}
+
 // DoExclusively acquires distributed lock, calls passed function and releases the lock when the function is finished.
 // Lock is acquired with a default TTL of 1 minute. TTL can be configured with WithLockTTL option.
 // Additionally, the lock is extended periodically within a separate goroutine.
 // Extension interval can be configured with WithPeriodicExtendInterval option. By default, it's half of the lock TTL.
 // When the function is finished, acquired lock is released.
 // Timeout for lock release can be configured with WithReleaseTimeout option. By default, it's 5 seconds.
+// If the lock is already acquired, ErrLockAlreadyAcquired is returned immediately
+// unless the acquisition retry is enabled with WithAcquireRetry option.
+//
+// Lock expiration is always computed by the database clock, while the extension interval is measured by the app clock.
+// Wall clock skew between the hosts doesn't matter, but the extension may be late if the app process is paused
+// or the database is slow to respond, so the lock should have enough TTL margin.
+// WithDBClockExtension option may be used to schedule extensions by the TTL remaining in the database.
 func (l *DBLock) DoExclusively(
 	ctx context.Context,
 	dbConn *sql.DB,
@@ -215,9 +507,7 @@ func (l *DBLock) DoExclusively(
 		opts.logger = disabledLogger{}
 	}
 
-	if acquireLockErr := dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
-		return l.Acquire(ctx, tx, opts.lockTTL)
-	}); acquireLockErr != nil {
+	if acquireLockErr := l.acquireWithRetry(ctx, dbConn, &opts); acquireLockErr != nil {
 		return acquireLockErr
 	}
 
@@ -244,13 +534,18 @@ func (l *DBLock) DoExclusively(
 
 	go func() {
 		defer func() { close(periodicalExtensionExit) }()
-		ticker := time.NewTicker(opts.periodicExtendInterval)
-		defer ticker.Stop()
+		interval, ok := l.nextExtendInterval(ctx, dbConn, &opts)
+		if !ok {
+			childCtxCancel()
+			return
+		}
+		timer := time.NewTimer(interval)
+		defer timer.Stop()
 		for {
 			select {
 			case <-periodicalExtensionDone:
 				return
-			case <-ticker.C:
+			case <-timer.C:
 				if extendErr := dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
 					return l.Extend(ctx, tx)
 				}); extendErr != nil {
@@ -260,6 +555,11 @@ func (l *DBLock) DoExclusively(
 						return
 					}
 				}
+				if interval, ok = l.nextExtendInterval(ctx, dbConn, &opts); !ok {
+					childCtxCancel()
+					return
+				}
+				timer.Reset(interval)
 			}
 		}
 	}()
@@ -267,6 +567,48 @@ func (l *DBLock) DoExclusively(
 	return fn(childCtx)
 }
 
+// nextExtendInterval returns the interval after which the lock should be extended.
+// False is returned if the lock is already expired by the database clock (WithDBClockExtension option only).
+func (l *DBLock) nextExtendInterval(ctx context.Context, dbConn *sql.DB, opts *doOptions) (time.Duration, bool) {
+	if !opts.dbClockExtension {
+		return opts.periodicExtendInterval, true
+	}
+	remaining, err := l.TimeRemaining(ctx, dbConn)
+	if err != nil {
+		if ctx.Err() == nil {
+			opts.logger.Errorf("failed to get remaining time of lock with key %s and token %s, error: %v", l.Key, l.token, err)
+		}
+		return opts.periodicExtendInterval, true
+	}
+	if remaining <= 0 {
+		opts.logger.Errorf("lock with key %s and token %s is already expired", l.Key, l.token)
+		return 0, false
+	}
+	return remaining / 2, true
+}
+
+func (l *DBLock) acquireWithRetry(ctx context.Context, dbConn *sql.DB, opts *doOptions) error {
+	interval := opts.acquireRetryBaseInterval
+	for {
+		err := dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return l.Acquire(ctx, tx, opts.lockTTL)
+		})
+		if err == nil || interval <= 0 || !errors.Is(err, ErrLockAlreadyAcquired) {
+			return err
+		}
+		// Jitter the interval in the range [interval/2, interval*3/2).
+		jitteredInterval := interval/2 + time.Duration(rand.Int63n(int64(interval))) //nolint:gosec // No need for crypto rand here.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(jitteredInterval):
+		}
+		if interval *= 2; opts.acquireRetryMaxWait > 0 && interval > opts.acquireRetryMaxWait {
+			interval = opts.acquireRetryMaxWait
+		}
+	}
+}
+
 // CreateTableSQL returns SQL query for creating a table that stores distributed locks.
 // DefaultTableName is used for the table name. If you need to use a custom table name, construct DBManager and DBLock manually instead.
 func CreateTableSQL(dialect dbkit.Dialect) (string, error) {
@@ -277,6 +619,18 @@ func CreateTableSQL(dialect dbkit.Dialect) (string, error) {
 	return q.createTable, nil
 }
 
+// AllCreateTableSQL returns SQL queries for creating a table that stores distributed locks for all supported dialects
+// (see CreateTableSQL). It may be used for generating schema documentation and for reviewing the DDL across dialects.
+func AllCreateTableSQL() map[dbkit.Dialect]string {
+	result := make(map[dbkit.Dialect]string)
+	for _, dialect := range (&dbkit.Config{}).SupportedDialects() {
+		if q, err := newDBQueries(dialect, DefaultTableName); err == nil {
+			result[dialect] = q.createTable
+		}
+	}
+	return result
+}
+
 // DropTableSQL returns SQL query for dropping a table that stores distributed locks.
 // DefaultTableName is used for the table name. If you need to use a custom table name, construct DBManager and DBLock manually instead.
 func DropTableSQL(dialect dbkit.Dialect) (string, error) {
@@ -287,6 +641,26 @@ func DropTableSQL(dialect dbkit.Dialect) (string, error) {
 	return q.dropTable, nil
 }
 
+// EnsureLockTable creates a table that stores distributed locks if it doesn't exist.
+// It's a ready-to-use helper function that creates a new DBManager with the passed options and calls CreateTable on it.
+func EnsureLockTable(ctx context.Context, executor SQLExecutor, dialect dbkit.Dialect, options ...DBManagerOption) error {
+	manager, err := NewDBManager(dialect, options...)
+	if err != nil {
+		return fmt.Errorf("create DB manager: %w", err)
+	}
+	return manager.CreateTable(ctx, executor)
+}
+
+// DropLockTable drops a table that stores distributed locks if it exists.
+// It's a ready-to-use helper function that creates a new DBManager with the passed options and calls DropTable on it.
+func DropLockTable(ctx context.Context, executor SQLExecutor, dialect dbkit.Dialect, options ...DBManagerOption) error {
+	manager, err := NewDBManager(dialect, options...)
+	if err != nil {
+		return fmt.Errorf("create DB manager: %w", err)
+	}
+	return manager.DropTable(ctx, executor)
+}
+
 // DoExclusively acquires distributed lock, calls passed function and releases the lock when the function is finished.
 // It's a ready-to-use helper function that creates a new DBManager, initializes a lock with the given key, and calls DoExclusively on it.
 // DefaultTableName is used for the table name. If you need to use a custom table name, construct DBManager and DBLock manually instead.
@@ -338,37 +712,73 @@ func execQueryAndCheckAffectedRow(
 	return nil
 }
 
+// isDuplicateColumnError checks if the error is returned because the added column already exists.
+// Postgres queries use "ADD COLUMN IF NOT EXISTS", so only MySQL error is checked.
+func isDuplicateColumnError(err error) bool {
+	var mySQLErr *mysql.MySQLError
+	return errors.As(err, &mySQLErr) && mySQLErr.Number == mySQLErrDupFieldName
+}
+
 type dbQueries struct {
-	createTable   string
-	dropTable     string
-	initLock      string
-	acquireLock   string
-	releaseLock   string
-	extendLock    string
-	intervalMaker func(interval time.Duration) string
+	createTable          string
+	dropTable            string
+	addOwnerColumns      []string
+	dropOwnerColumns     []string
+	initLock             string
+	acquireLock          string
+	acquireLockWithOwner string
+	releaseLock          string
+	extendLock           string
+	deleteLock           string
+	countHeldLocks       string
+	lockStatus           string
+	lockStatusWithOwner  string
+	timeRemaining        string // Returns the number of microseconds until the lock expires.
+	intervalMaker        func(interval time.Duration) string
+	unixTimestamps       bool // True if timestamps are stored as numbers (see mySQLParseTimestamp).
 }
 
 func newDBQueries(dialect dbkit.Dialect, tableName string) (dbQueries, error) {
 	switch dialect {
 	case dbkit.DialectPostgres, dbkit.DialectPgx:
 		return dbQueries{
-			createTable:   fmt.Sprintf(postgresCreateTableQuery, tableName),
-			dropTable:     fmt.Sprintf(postgresDropTableQuery, tableName),
-			initLock:      fmt.Sprintf(postgresInitLockQuery, tableName),
-			acquireLock:   fmt.Sprintf(postgresAcquireLockQuery, tableName),
-			releaseLock:   fmt.Sprintf(postgresReleaseLockQuery, tableName),
-			extendLock:    fmt.Sprintf(postgresExtendLockQuery, tableName),
-			intervalMaker: postgresMakeInterval,
+			createTable:          fmt.Sprintf(postgresCreateTableQuery, tableName),
+			dropTable:            fmt.Sprintf(postgresDropTableQuery, tableName),
+			addOwnerColumns:      []string{fmt.Sprintf(postgresAddOwnerColumnsQuery, tableName)},
+			dropOwnerColumns:     []string{fmt.Sprintf(postgresDropOwnerColumnsQuery, tableName)},
+			initLock:             fmt.Sprintf(postgresInitLockQuery, tableName),
+			acquireLock:          fmt.Sprintf(postgresAcquireLockQuery, tableName),
+			acquireLockWithOwner: fmt.Sprintf(postgresAcquireLockWithOwnerQuery, tableName),
+			releaseLock:          fmt.Sprintf(postgresReleaseLockQuery, tableName),
+			extendLock:           fmt.Sprintf(postgresExtendLockQuery, tableName),
+			deleteLock:           fmt.Sprintf(postgresDeleteLockQuery, tableName),
+			countHeldLocks:       fmt.Sprintf(postgresCountHeldLocksQuery, tableName),
+			lockStatus:           fmt.Sprintf(postgresLockStatusQuery, tableName),
+			lockStatusWithOwner:  fmt.Sprintf(postgresLockStatusWithOwnerQuery, tableName),
+			timeRemaining:        fmt.Sprintf(postgresTimeRemainingQuery, tableName),
+			intervalMaker:        postgresMakeInterval,
 		}, nil
 	case dbkit.DialectMySQL:
 		return dbQueries{
-			createTable:   fmt.Sprintf(mySQLCreateTableQuery, tableName),
-			dropTable:     fmt.Sprintf(mySQLDropTableQuery, tableName),
-			initLock:      fmt.Sprintf(mySQLInitLockQuery, tableName),
-			acquireLock:   fmt.Sprintf(mySQLAcquireLockQuery, tableName),
-			releaseLock:   fmt.Sprintf(mySQLReleaseLockQuery, tableName),
-			extendLock:    fmt.Sprintf(mySQLExtendLockQuery, tableName),
-			intervalMaker: mySQLMakeInterval,
+			createTable: fmt.Sprintf(mySQLCreateTableQuery, tableName),
+			dropTable:   fmt.Sprintf(mySQLDropTableQuery, tableName),
+			addOwnerColumns: []string{
+				fmt.Sprintf(mySQLAddAcquiredAtColumnQuery, tableName),
+				fmt.Sprintf(mySQLAddOwnerColumnQuery, tableName),
+			},
+			dropOwnerColumns:     []string{fmt.Sprintf(mySQLDropOwnerColumnsQuery, tableName)},
+			initLock:             fmt.Sprintf(mySQLInitLockQuery, tableName),
+			acquireLock:          fmt.Sprintf(mySQLAcquireLockQuery, tableName),
+			acquireLockWithOwner: fmt.Sprintf(mySQLAcquireLockWithOwnerQuery, tableName),
+			releaseLock:          fmt.Sprintf(mySQLReleaseLockQuery, tableName),
+			extendLock:           fmt.Sprintf(mySQLExtendLockQuery, tableName),
+			deleteLock:           fmt.Sprintf(mySQLDeleteLockQuery, tableName),
+			countHeldLocks:       fmt.Sprintf(mySQLCountHeldLocksQuery, tableName),
+			lockStatus:           fmt.Sprintf(mySQLLockStatusQuery, tableName),
+			lockStatusWithOwner:  fmt.Sprintf(mySQLLockStatusWithOwnerQuery, tableName),
+			timeRemaining:        fmt.Sprintf(mySQLTimeRemainingQuery, tableName),
+			intervalMaker:        mySQLMakeInterval,
+			unixTimestamps:       true,
 		}, nil
 	default:
 		return dbQueries{}, fmt.Errorf("unsupported sql dialect %q", dialect)
@@ -379,16 +789,33 @@ type SQLExecutor interface {
 	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
 }
 
-const createTableMigrationID = "distrlock_00001_create_table"
+// SQLQueryExecutor is an interface for executing queries that return a single row (e.g. *sql.DB or *sql.Tx).
+type SQLQueryExecutor interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+const (
+	createTableMigrationID     = "distrlock_00001_create_table"
+	addOwnerColumnsMigrationID = "distrlock_00002_add_owner_columns"
+)
 
 //nolint:lll // SQL queries are more readable on single lines
 const (
-	postgresCreateTableQuery = `CREATE TABLE IF NOT EXISTS "%s" (lock_key varchar(40) PRIMARY KEY, token uuid, expire_at timestamp);`
-	postgresDropTableQuery   = `DROP TABLE IF EXISTS "%s";`
-	postgresInitLockQuery    = `INSERT INTO "%s" (lock_key) VALUES ($1) ON CONFLICT (lock_key) DO NOTHING;`
-	postgresAcquireLockQuery = `UPDATE "%s" SET expire_at = NOW() + $1::interval, token = $2 WHERE lock_key = $3 AND ((expire_at IS NULL OR expire_at < NOW()) OR token = $4);`
-	postgresReleaseLockQuery = `UPDATE "%s" SET expire_at = NULL WHERE lock_key = $1 AND token = $2 AND expire_at >= NOW();`
-	postgresExtendLockQuery  = `UPDATE "%s" SET expire_at = NOW() + $1::interval WHERE lock_key = $2 AND token = $3 AND expire_at >= NOW();`
+	postgresCreateTableQuery    = `CREATE TABLE IF NOT EXISTS "%s" (lock_key varchar(40) PRIMARY KEY, token uuid, expire_at timestamp);`
+	postgresDropTableQuery      = `DROP TABLE IF EXISTS "%s";`
+	postgresInitLockQuery       = `INSERT INTO "%s" (lock_key) VALUES ($1) ON CONFLICT (lock_key) DO NOTHING;`
+	postgresAcquireLockQuery    = `UPDATE "%s" SET expire_at = NOW() + $1::interval, token = $2 WHERE lock_key = $3 AND ((expire_at IS NULL OR expire_at < NOW()) OR token = $4);`
+	postgresReleaseLockQuery    = `UPDATE "%s" SET expire_at = NULL WHERE lock_key = $1 AND token = $2 AND expire_at >= NOW();`
+	postgresExtendLockQuery     = `UPDATE "%s" SET expire_at = NOW() + $1::interval WHERE lock_key = $2 AND token = $3 AND expire_at >= NOW();`
+	postgresDeleteLockQuery     = `DELETE FROM "%s" WHERE lock_key = $1;`
+	postgresCountHeldLocksQuery = `SELECT COUNT(*) FROM "%s" WHERE expire_at >= NOW();`
+
+	postgresAddOwnerColumnsQuery      = `ALTER TABLE "%s" ADD COLUMN IF NOT EXISTS acquired_at timestamp, ADD COLUMN IF NOT EXISTS owner varchar(255);`
+	postgresDropOwnerColumnsQuery     = `ALTER TABLE "%s" DROP COLUMN IF EXISTS acquired_at, DROP COLUMN IF EXISTS owner;`
+	postgresAcquireLockWithOwnerQuery = `UPDATE "%s" SET acquired_at = CASE WHEN token = $1 AND expire_at >= NOW() THEN acquired_at ELSE NOW() END, expire_at = NOW() + $2::interval, token = $3, owner = $4 WHERE lock_key = $5 AND ((expire_at IS NULL OR expire_at < NOW()) OR token = $6);`
+	postgresLockStatusQuery           = `SELECT expire_at IS NOT NULL AND expire_at >= NOW() FROM "%s" WHERE lock_key = $1;`
+	postgresLockStatusWithOwnerQuery  = `SELECT expire_at IS NOT NULL AND expire_at >= NOW(), owner, acquired_at FROM "%s" WHERE lock_key = $1;`
+	postgresTimeRemainingQuery        = `SELECT COALESCE(EXTRACT(EPOCH FROM (expire_at - NOW())) * 1000000, 0)::bigint FROM "%s" WHERE lock_key = $1 AND token = $2;`
 )
 
 func postgresMakeInterval(interval time.Duration) string {
@@ -397,18 +824,37 @@ func postgresMakeInterval(interval time.Duration) string {
 
 //nolint:lll // SQL queries are more readable on single lines
 const (
-	mySQLCreateTableQuery = "CREATE TABLE IF NOT EXISTS `%s` (lock_key VARCHAR(40) PRIMARY KEY, token VARCHAR(36), expire_at BIGINT);"
-	mySQLDropTableQuery   = "DROP TABLE IF EXISTS `%s`;"
-	mySQLInitLockQuery    = "INSERT IGNORE `%s` (lock_key) VALUES (?);"
-	mySQLAcquireLockQuery = "UPDATE `%s` SET expire_at = UNIX_TIMESTAMP(DATE_ADD(CURTIME(4), INTERVAL ? MICROSECOND))*10000, token = ? WHERE lock_key = ? AND ((expire_at IS NULL OR expire_at < UNIX_TIMESTAMP(CURTIME(4))*10000) OR token = ?);"
-	mySQLReleaseLockQuery = "UPDATE `%s` SET expire_at = NULL WHERE lock_key = ? AND token = ? AND expire_at >= UNIX_TIMESTAMP(CURTIME(4))*10000;"
-	mySQLExtendLockQuery  = "UPDATE `%s` SET expire_at = UNIX_TIMESTAMP(DATE_ADD(CURTIME(4), INTERVAL ? MICROSECOND))*10000 WHERE lock_key = ? AND token = ? AND expire_at >= UNIX_TIMESTAMP(CURTIME(4))*10000;"
+	mySQLCreateTableQuery    = "CREATE TABLE IF NOT EXISTS `%s` (lock_key VARCHAR(40) PRIMARY KEY, token VARCHAR(36), expire_at BIGINT);"
+	mySQLDropTableQuery      = "DROP TABLE IF EXISTS `%s`;"
+	mySQLInitLockQuery       = "INSERT IGNORE `%s` (lock_key) VALUES (?);"
+	mySQLAcquireLockQuery    = "UPDATE `%s` SET expire_at = UNIX_TIMESTAMP(DATE_ADD(CURTIME(4), INTERVAL ? MICROSECOND))*10000, token = ? WHERE lock_key = ? AND ((expire_at IS NULL OR expire_at < UNIX_TIMESTAMP(CURTIME(4))*10000) OR token = ?);"
+	mySQLReleaseLockQuery    = "UPDATE `%s` SET expire_at = NULL WHERE lock_key = ? AND token = ? AND expire_at >= UNIX_TIMESTAMP(CURTIME(4))*10000;"
+	mySQLExtendLockQuery     = "UPDATE `%s` SET expire_at = UNIX_TIMESTAMP(DATE_ADD(CURTIME(4), INTERVAL ? MICROSECOND))*10000 WHERE lock_key = ? AND token = ? AND expire_at >= UNIX_TIMESTAMP(CURTIME(4))*10000;"
+	mySQLDeleteLockQuery     = "DELETE FROM `%s` WHERE lock_key = ?;"
+	mySQLCountHeldLocksQuery = "SELECT COUNT(*) FROM `%s` WHERE expire_at >= UNIX_TIMESTAMP(CURTIME(4))*10000;"
+
+	mySQLAddAcquiredAtColumnQuery = "ALTER TABLE `%s` ADD COLUMN acquired_at BIGINT;"
+	mySQLAddOwnerColumnQuery      = "ALTER TABLE `%s` ADD COLUMN owner VARCHAR(255);"
+	mySQLDropOwnerColumnsQuery    = "ALTER TABLE `%s` DROP COLUMN acquired_at, DROP COLUMN owner;"
+	// Assignments in MySQL UPDATE are evaluated from left to right, so acquired_at must be set before token and expire_at.
+	mySQLAcquireLockWithOwnerQuery = "UPDATE `%s` SET acquired_at = IF(token = ? AND expire_at >= UNIX_TIMESTAMP(CURTIME(4))*10000, acquired_at, UNIX_TIMESTAMP(NOW(4))*10000), expire_at = UNIX_TIMESTAMP(DATE_ADD(CURTIME(4), INTERVAL ? MICROSECOND))*10000, token = ?, owner = ? WHERE lock_key = ? AND ((expire_at IS NULL OR expire_at < UNIX_TIMESTAMP(CURTIME(4))*10000) OR token = ?);"
+	mySQLLockStatusQuery           = "SELECT expire_at IS NOT NULL AND expire_at >= UNIX_TIMESTAMP(CURTIME(4))*10000 FROM `%s` WHERE lock_key = ?;"
+	mySQLLockStatusWithOwnerQuery  = "SELECT expire_at IS NOT NULL AND expire_at >= UNIX_TIMESTAMP(CURTIME(4))*10000, owner, acquired_at FROM `%s` WHERE lock_key = ?;"
+	mySQLTimeRemainingQuery        = "SELECT CAST(COALESCE(expire_at - UNIX_TIMESTAMP(CURTIME(4))*10000, 0)*100 AS SIGNED) FROM `%s` WHERE lock_key = ? AND token = ?;"
 )
 
+// mySQLErrDupFieldName is a MySQL error number that is returned when the added column already exists.
+const mySQLErrDupFieldName = 1060
+
 func mySQLMakeInterval(interval time.Duration) string {
 	return strconv.FormatInt(interval.Microseconds(), 10)
 }
 
+// mySQLParseTimestamp converts the timestamp stored as a number of 100-microsecond units since the Unix epoch.
+func mySQLParseTimestamp(v int64) time.Time {
+	return time.UnixMicro(v * 100)
+}
+
 type disabledLogger struct{}
 
 func (disabledLogger) Errorf(msg string, args ...interface{}) {}
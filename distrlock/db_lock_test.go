@@ -12,16 +12,20 @@ import (
 	"database/sql/driver"
 	"errors"
 	"fmt"
+	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
 	gotesting "testing"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/acronis/go-appkit/log/logtest"
 	"github.com/go-sql-driver/mysql"
 	"github.com/google/uuid"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "github.com/mattn/go-sqlite3"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -51,6 +55,497 @@ func TestDBLock_DoExclusively_MySQL(t *gotesting.T) {
 	runDBLockDoExclusivelyTests(t, dbkit.DialectMySQL)
 }
 
+func TestNewDBManager_TooLongTableName(t *gotesting.T) {
+	_, err := NewDBManager(dbkit.DialectMySQL, WithTableName(strings.Repeat("l", dbkit.MySQLMaxIdentifierLength+1)))
+	require.ErrorContains(t, err, "too long")
+
+	_, err = NewDBManager(dbkit.DialectMySQL, WithTableName(strings.Repeat("l", dbkit.MySQLMaxIdentifierLength)))
+	require.NoError(t, err)
+}
+
+func TestDBManager_CreateTable(t *gotesting.T) {
+	dbManager, err := NewDBManager(dbkit.DialectMySQL, WithTableName("my_locks"))
+	require.NoError(t, err)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+
+	mock.ExpectExec(dbManager.CreateTableSQL()).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, dbManager.CreateTable(context.Background(), db))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	mock.ExpectExec(dbManager.CreateTableSQL()).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 0))
+	err = dbManager.CreateTable(ctx, db)
+	require.ErrorContains(t, err, "create distributed locks table")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAllCreateTableSQL(t *gotesting.T) {
+	allSQL := AllCreateTableSQL()
+	require.Len(t, allSQL, 3)
+	for _, dialect := range []dbkit.Dialect{dbkit.DialectPostgres, dbkit.DialectPgx, dbkit.DialectMySQL} {
+		createTableSQL, err := CreateTableSQL(dialect)
+		require.NoError(t, err)
+		require.Equal(t, createTableSQL, allSQL[dialect])
+	}
+	require.Equal(t, allSQL[dbkit.DialectPostgres], allSQL[dbkit.DialectPgx])
+}
+
+func TestDBManager_WithCreateTableSQL(t *gotesting.T) {
+	const customCreateTableSQL = `CREATE TABLE IF NOT EXISTS "my_locks" (
+		lock_key varchar(40) PRIMARY KEY, token uuid, expire_at timestamp,
+		created_at timestamp DEFAULT NOW(), updated_at timestamp DEFAULT NOW()
+	) TABLESPACE locks_space;`
+	dbManager, err := NewDBManager(dbkit.DialectPostgres, WithTableName("my_locks"), WithCreateTableSQL(customCreateTableSQL))
+	require.NoError(t, err)
+	require.Equal(t, customCreateTableSQL, dbManager.CreateTableSQL())
+	require.Equal(t, []string{customCreateTableSQL}, dbManager.Migrations()[0].UpSQL())
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	mock.ExpectExec(customCreateTableSQL).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, dbManager.CreateTable(context.Background(), db))
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBManager_SelfTest(t *gotesting.T) {
+	dbManager, err := NewDBManager(dbkit.DialectMySQL, WithStats(0))
+	require.NoError(t, err)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+
+	expectExecInTx := func(query string, result driver.Result, err error) {
+		mock.ExpectBegin()
+		exec := mock.ExpectExec(query)
+		if err != nil {
+			exec.WillReturnError(err)
+			mock.ExpectRollback()
+			return
+		}
+		exec.WillReturnResult(result)
+		mock.ExpectCommit()
+	}
+
+	t.Run("all steps succeed", func(t *gotesting.T) {
+		expectExecInTx(dbManager.queries.initLock, sqlmock.NewResult(0, 1), nil)
+		expectExecInTx(dbManager.queries.acquireLock, sqlmock.NewResult(0, 1), nil)
+		expectExecInTx(dbManager.queries.extendLock, sqlmock.NewResult(0, 1), nil)
+		expectExecInTx(dbManager.queries.releaseLock, sqlmock.NewResult(0, 1), nil)
+		expectExecInTx(dbManager.queries.deleteLock, sqlmock.NewResult(0, 1), nil)
+		require.NoError(t, dbManager.SelfTest(context.Background(), db))
+		require.NoError(t, mock.ExpectationsWereMet())
+		require.Empty(t, dbManager.Stats())
+	})
+
+	t.Run("failed step is reported and lock is deleted", func(t *gotesting.T) {
+		expectExecInTx(dbManager.queries.initLock, sqlmock.NewResult(0, 1), nil)
+		expectExecInTx(dbManager.queries.acquireLock, sqlmock.NewResult(0, 1), nil)
+		mock.ExpectBegin()
+		mock.ExpectExec(dbManager.queries.extendLock).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+		expectExecInTx(dbManager.queries.deleteLock, sqlmock.NewResult(0, 1), nil)
+		err := dbManager.SelfTest(context.Background(), db)
+		require.ErrorIs(t, err, ErrLockAlreadyReleased)
+		require.ErrorContains(t, err, "extend lock")
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("table doesn't exist", func(t *gotesting.T) {
+		expectExecInTx(dbManager.queries.initLock, nil, errors.New("table doesn't exist"))
+		require.EqualError(t, dbManager.SelfTest(context.Background(), db), "init lock: table doesn't exist")
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDBManager_MigrationsRunLocker(t *gotesting.T) {
+	dbManager, err := NewDBManager(dbkit.DialectMySQL)
+	require.NoError(t, err)
+
+	lockDB, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+
+	migrationsDB, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, migrationsDB.Close()) }()
+	migrationsDB.SetMaxOpenConns(1)
+
+	migMngr, err := migrate.NewMigrationsManagerWithOpts(migrationsDB, dbkit.DialectSQLite, logtest.NewLogger(),
+		migrate.MigrationsManagerOpts{RunLocker: dbManager.MigrationsRunLocker(lockDB, "migrations")})
+	require.NoError(t, err)
+	migrations := []migrate.Migration{migrate.NewCustomMigration("00001_create_users",
+		[]string{"CREATE TABLE users (id INTEGER)"}, []string{"DROP TABLE users"}, nil, nil)}
+
+	t.Run("migrations are applied under the lock", func(t *gotesting.T) {
+		mock.ExpectExec(dbManager.CreateTableSQL()).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(dbManager.queries.initLock).WithArgs("migrations").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectBegin()
+		mock.ExpectExec(dbManager.queries.acquireLock).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectExec(dbManager.queries.releaseLock).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		require.NoError(t, migMngr.Run(migrations, migrate.MigrationsDirectionUp))
+		require.NoError(t, mock.ExpectationsWereMet())
+		migStatus, err := migMngr.Status()
+		require.NoError(t, err)
+		require.Len(t, migStatus.AppliedMigrations, 1)
+	})
+
+	t.Run("migrations are not applied if the lock is not acquired", func(t *gotesting.T) {
+		mock.ExpectExec(dbManager.CreateTableSQL()).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(dbManager.queries.initLock).WithArgs("migrations").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectBegin()
+		mock.ExpectExec(dbManager.queries.acquireLock).WillReturnError(errors.New("connection refused"))
+		mock.ExpectRollback()
+
+		require.EqualError(t, migMngr.Run(migrations, migrate.MigrationsDirectionDown), "connection refused")
+		require.NoError(t, mock.ExpectationsWereMet())
+		migStatus, err := migMngr.Status()
+		require.NoError(t, err)
+		require.Len(t, migStatus.AppliedMigrations, 1)
+	})
+}
+
+func TestEnsureLockTable(t *gotesting.T) {
+	dbManager, err := NewDBManager(dbkit.DialectPostgres, WithTableName("my_locks"))
+	require.NoError(t, err)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+
+	mock.ExpectExec(dbManager.CreateTableSQL()).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, EnsureLockTable(context.Background(), db, dbkit.DialectPostgres, WithTableName("my_locks")))
+
+	mock.ExpectExec(dbManager.DropTableSQL()).WillReturnResult(sqlmock.NewResult(0, 0))
+	require.NoError(t, DropLockTable(context.Background(), db, dbkit.DialectPostgres, WithTableName("my_locks")))
+
+	mock.ExpectExec(dbManager.DropTableSQL()).WillReturnError(errors.New("permission denied"))
+	require.EqualError(t, DropLockTable(context.Background(), db, dbkit.DialectPostgres, WithTableName("my_locks")),
+		"drop distributed locks table: permission denied")
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	require.ErrorContains(t, EnsureLockTable(context.Background(), db, "unknown"), "create DB manager")
+}
+
+func TestDBManager_NewLock_KeyNamespace(t *gotesting.T) {
+	dbManager, err := NewDBManager(dbkit.DialectMySQL, WithKeyNamespace("billing"))
+	require.NoError(t, err)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+
+	mock.ExpectExec(dbManager.queries.initLock).WithArgs("billing:job").WillReturnResult(sqlmock.NewResult(0, 1))
+	lock, err := dbManager.NewLock(context.Background(), db, "job")
+	require.NoError(t, err)
+	require.Equal(t, "billing:job", lock.Key)
+	require.NoError(t, mock.ExpectationsWereMet())
+
+	// Length limit is applied to the prefixed key.
+	_, err = dbManager.NewLock(context.Background(), db, strings.Repeat("k", 35))
+	require.EqualError(t, err, "lock key cannot be longer than 40 symbols")
+}
+
+func TestDBManager_WithOwner(t *gotesting.T) {
+	ctx := context.Background()
+
+	t.Run("hostname is used by default", func(t *gotesting.T) {
+		hostname, err := os.Hostname()
+		require.NoError(t, err)
+		dbManager, err := NewDBManager(dbkit.DialectPostgres, WithOwner(""))
+		require.NoError(t, err)
+		require.Equal(t, hostname, dbManager.owner)
+	})
+
+	t.Run("too long owner", func(t *gotesting.T) {
+		_, err := NewDBManager(dbkit.DialectPostgres, WithOwner(strings.Repeat("o", 256)))
+		require.ErrorContains(t, err, "is too long: 256 characters, max 255")
+	})
+
+	t.Run("migrations", func(t *gotesting.T) {
+		dbManager, err := NewDBManager(dbkit.DialectPostgres)
+		require.NoError(t, err)
+		require.Len(t, dbManager.Migrations(), 1)
+
+		dbManager, err = NewDBManager(dbkit.DialectPostgres, WithOwner("pod-1"))
+		require.NoError(t, err)
+		migrations := dbManager.Migrations()
+		require.Len(t, migrations, 2)
+		require.Equal(t, addOwnerColumnsMigrationID, migrations[1].ID())
+		require.Equal(t, dbManager.queries.addOwnerColumns, migrations[1].UpSQL())
+	})
+
+	t.Run("acquire and get status", func(t *gotesting.T) {
+		dbManager, err := NewDBManager(dbkit.DialectMySQL, WithOwner("pod-1"), WithKeyNamespace("billing"))
+		require.NoError(t, err)
+
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+
+		// Already existing column is skipped.
+		mock.ExpectExec(dbManager.CreateTableSQL()).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(dbManager.queries.addOwnerColumns[0]).
+			WillReturnError(&mysql.MySQLError{Number: mySQLErrDupFieldName, Message: "Duplicate column name 'acquired_at'"})
+		mock.ExpectExec(dbManager.queries.addOwnerColumns[1]).WillReturnResult(sqlmock.NewResult(0, 0))
+		require.NoError(t, dbManager.CreateTable(ctx, db))
+
+		mock.ExpectExec(dbManager.queries.initLock).WithArgs("billing:job").WillReturnResult(sqlmock.NewResult(0, 1))
+		lock, err := dbManager.NewLock(ctx, db, "job")
+		require.NoError(t, err)
+
+		mock.ExpectExec(dbManager.queries.acquireLockWithOwner).
+			WithArgs("token-1", mySQLMakeInterval(time.Minute), "token-1", "pod-1", "billing:job", "token-1").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		require.NoError(t, lock.AcquireWithStaticToken(ctx, db, "token-1", time.Minute))
+
+		acquiredAt := time.Date(2024, 5, 1, 10, 3, 0, 0, time.UTC)
+		mock.ExpectQuery(dbManager.queries.lockStatusWithOwner).WithArgs("billing:job").
+			WillReturnRows(sqlmock.NewRows([]string{"held", "owner", "acquired_at"}).
+				AddRow(true, "pod-1", acquiredAt.UnixMicro()/100))
+		status, err := dbManager.LockStatus(ctx, db, "job")
+		require.NoError(t, err)
+		require.True(t, acquiredAt.Equal(status.AcquiredAt))
+		status.AcquiredAt = time.Time{}
+		require.Equal(t, LockStatus{Key: "billing:job", Held: true, Owner: "pod-1"}, status)
+
+		mock.ExpectQuery(dbManager.queries.lockStatusWithOwner).WithArgs("billing:unknown").
+			WillReturnRows(sqlmock.NewRows([]string{"held", "owner", "acquired_at"}))
+		_, err = dbManager.LockStatus(ctx, db, "unknown")
+		require.ErrorIs(t, err, sql.ErrNoRows)
+
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("status without owner tracking", func(t *gotesting.T) {
+		dbManager, err := NewDBManager(dbkit.DialectPostgres)
+		require.NoError(t, err)
+
+		db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+
+		mock.ExpectQuery(dbManager.queries.lockStatus).WithArgs("job").
+			WillReturnRows(sqlmock.NewRows([]string{"held"}).AddRow(false))
+		status, err := dbManager.LockStatus(ctx, db, "job")
+		require.NoError(t, err)
+		require.Equal(t, LockStatus{Key: "job"}, status)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestDBLock_TimeRemaining(t *gotesting.T) {
+	ctx := context.Background()
+	dbManager, err := NewDBManager(dbkit.DialectPostgres)
+	require.NoError(t, err)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+
+	mock.ExpectExec(dbManager.queries.initLock).WithArgs("job").WillReturnResult(sqlmock.NewResult(0, 1))
+	lock, err := dbManager.NewLock(ctx, db, "job")
+	require.NoError(t, err)
+
+	// Lock is not acquired yet, database is not queried.
+	remaining, err := lock.TimeRemaining(ctx, db)
+	require.NoError(t, err)
+	require.Zero(t, remaining)
+
+	mock.ExpectExec(dbManager.queries.acquireLock).
+		WithArgs(postgresMakeInterval(time.Minute), "token-1", "job", "token-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, lock.AcquireWithStaticToken(ctx, db, "token-1", time.Minute))
+
+	mock.ExpectQuery(dbManager.queries.timeRemaining).WithArgs("job", "token-1").
+		WillReturnRows(sqlmock.NewRows([]string{"remaining"}).AddRow(int64(42_500_000)))
+	remaining, err = lock.TimeRemaining(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, 42500*time.Millisecond, remaining)
+
+	// Lock is acquired by someone else.
+	mock.ExpectQuery(dbManager.queries.timeRemaining).WithArgs("job", "token-1").
+		WillReturnRows(sqlmock.NewRows([]string{"remaining"}))
+	remaining, err = lock.TimeRemaining(ctx, db)
+	require.NoError(t, err)
+	require.Zero(t, remaining)
+
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBManager_HeldLockCount(t *gotesting.T) {
+	dbManager, err := NewDBManager(dbkit.DialectPostgres)
+	require.NoError(t, err)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+
+	mock.ExpectQuery(`SELECT COUNT(*) FROM "distributed_locks" WHERE expire_at >= NOW();`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	count, err := dbManager.HeldLockCount(context.Background(), db)
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	mock.ExpectQuery(dbManager.queries.countHeldLocks).WillReturnError(errors.New("connection refused"))
+	_, err = dbManager.HeldLockCount(context.Background(), db)
+	require.EqualError(t, err, "count held locks: connection refused")
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPrometheusMetrics_CollectHeldLocks(t *gotesting.T) {
+	dbManager, err := NewDBManager(dbkit.DialectMySQL)
+	require.NoError(t, err)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+
+	mock.ExpectQuery(dbManager.queries.countHeldLocks).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(dbManager.queries.countHeldLocks).WillReturnError(errors.New("connection refused"))
+	mock.ExpectQuery(dbManager.queries.countHeldLocks).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+
+	metrics := NewPrometheusMetrics()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		metrics.CollectHeldLocks(ctx, dbManager, db, 10*time.Millisecond, nil)
+	}()
+	// Collecting is not stopped by errors.
+	require.Eventually(t, func() bool {
+		return promtestutil.ToFloat64(metrics.HeldLocks) == 5
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	<-done
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDBLock_DoExclusively_AcquireRetry(t *gotesting.T) {
+	dbManager, err := NewDBManager(dbkit.DialectMySQL)
+	require.NoError(t, err)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+
+	mock.ExpectExec(dbManager.queries.initLock).WithArgs("leader").WillReturnResult(sqlmock.NewResult(0, 1))
+	lock, err := dbManager.NewLock(context.Background(), db, "leader")
+	require.NoError(t, err)
+
+	expectAcquire := func(rowsAffected int64) {
+		mock.ExpectBegin()
+		mock.ExpectExec(dbManager.queries.acquireLock).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "leader", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+		if rowsAffected == 0 {
+			mock.ExpectRollback()
+		} else {
+			mock.ExpectCommit()
+		}
+	}
+
+	t.Run("lock is acquired after retries", func(t *gotesting.T) {
+		expectAcquire(0)
+		expectAcquire(0)
+		expectAcquire(1)
+		mock.ExpectBegin()
+		mock.ExpectExec(dbManager.queries.releaseLock).WithArgs("leader", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		var called bool
+		require.NoError(t, lock.DoExclusively(context.Background(), db, func(ctx context.Context) error {
+			called = true
+			return nil
+		}, WithAcquireRetry(20*time.Millisecond, 10*time.Millisecond)))
+		require.True(t, called)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("no retry by default", func(t *gotesting.T) {
+		expectAcquire(0)
+		err := lock.DoExclusively(context.Background(), db, func(ctx context.Context) error {
+			return errors.New("must not be called")
+		})
+		require.ErrorIs(t, err, ErrLockAlreadyAcquired)
+		require.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("retrying is stopped when context is canceled", func(t *gotesting.T) {
+		for i := 0; i < 100; i++ {
+			expectAcquire(0)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := lock.DoExclusively(ctx, db, func(ctx context.Context) error {
+			return errors.New("must not be called")
+		}, WithAcquireRetry(20*time.Millisecond, 10*time.Millisecond))
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestDBLock_DoExclusively_DBClockExtension(t *gotesting.T) {
+	dbManager, err := NewDBManager(dbkit.DialectMySQL)
+	require.NoError(t, err)
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+
+	mock.ExpectExec(dbManager.queries.initLock).WithArgs("leader").WillReturnResult(sqlmock.NewResult(0, 1))
+	lock, err := dbManager.NewLock(context.Background(), db, "leader")
+	require.NoError(t, err)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(dbManager.queries.acquireLock).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "leader", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// Lock expires earlier than expected by the app clock, so it's extended in 20ms instead of 30s.
+	mock.ExpectQuery(dbManager.queries.timeRemaining).WithArgs("leader", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"remaining"}).AddRow(int64(40_000)))
+	mock.ExpectBegin()
+	mock.ExpectExec(dbManager.queries.extendLock).
+		WithArgs(sqlmock.AnyArg(), "leader", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	// Lock is expired by the database clock, so the function is stopped.
+	mock.ExpectQuery(dbManager.queries.timeRemaining).WithArgs("leader", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"remaining"}).AddRow(int64(0)))
+	mock.ExpectBegin()
+	mock.ExpectExec(dbManager.queries.releaseLock).WithArgs("leader", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = lock.DoExclusively(context.Background(), db, func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return errors.New("function is not stopped")
+		}
+	}, WithLockTTL(time.Minute), WithDBClockExtension())
+	require.ErrorIs(t, err, context.Canceled)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestKeyStatsTracker(t *gotesting.T) {
+	tracker := newKeyStatsTracker(2)
+	tracker.record("key1", true)
+	tracker.record("key1", false)
+	tracker.record("key2", false)
+	require.Equal(t, map[string]LockKeyStats{
+		"key1": {Acquired: 1, Contended: 1},
+		"key2": {Contended: 1},
+	}, tracker.snapshot())
+
+	// key2 is the least recently used, so it should be evicted.
+	tracker.record("key1", true)
+	tracker.record("key3", true)
+	require.Equal(t, map[string]LockKeyStats{
+		"key1": {Acquired: 2, Contended: 1},
+		"key3": {Acquired: 1},
+	}, tracker.snapshot())
+
+	mgr, err := NewDBManager(dbkit.DialectMySQL)
+	require.NoError(t, err)
+	require.Nil(t, mgr.Stats())
+}
+
 //nolint:gocyclo
 func runDBManagerTests(t *gotesting.T, dialect dbkit.Dialect) {
 	containerCtx, containerCtxClose := context.WithTimeout(context.Background(), time.Minute*2)
@@ -165,6 +660,9 @@ func runDBManagerTests(t *gotesting.T, dialect dbkit.Dialect) {
 		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
 			return lock.Acquire(ctx, tx, lockTimeout)
 		}))
+		heldCount, err := dbManager.HeldLockCount(ctx, dbConn)
+		require.NoError(t, err)
+		require.GreaterOrEqual(t, heldCount, 1)
 
 		acquireErr := dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
 			return lock.Acquire(ctx, tx, lockTimeout)
@@ -173,6 +671,32 @@ func runDBManagerTests(t *gotesting.T, dialect dbkit.Dialect) {
 		require.ErrorIs(t, acquireErr, ErrLockAlreadyAcquired)
 	})
 
+	t.Run("stats count successful and contended acquisitions", func(t *gotesting.T) {
+		const ctxTimeout = 10 * time.Second
+		const lockTimeout = 1 * time.Second
+		lockKey := uuid.NewString()
+
+		ctx, ctxCancel := context.WithTimeout(context.Background(), ctxTimeout)
+		defer ctxCancel()
+
+		statsManager, err := NewDBManager(dialect, WithStats(10))
+		require.NoError(t, err)
+
+		var lock DBLock
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) (err error) {
+			lock, err = statsManager.NewLock(ctx, tx, lockKey)
+			return err
+		}))
+		require.NoError(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock.Acquire(ctx, tx, lockTimeout)
+		}))
+		require.ErrorIs(t, dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+			return lock.Acquire(ctx, tx, lockTimeout)
+		}), ErrLockAlreadyAcquired)
+
+		require.Equal(t, map[string]LockKeyStats{lockKey: {Acquired: 1, Contended: 1}}, statsManager.Stats())
+	})
+
 	t.Run("acquire lock, release it, and acquire again", func(t *gotesting.T) {
 		const ctxTimeout = 10 * time.Second
 		const lockTimeout = 1 * time.Second
@@ -456,6 +980,59 @@ func runDBManagerTests(t *gotesting.T, dialect dbkit.Dialect) {
 		})
 		require.ErrorIs(t, extendErr, ErrLockAlreadyReleased)
 	})
+
+	t.Run("time remaining", func(t *gotesting.T) {
+		const lockTimeout = 10 * time.Second
+		ctx, ctxCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer ctxCancel()
+
+		lock, err := dbManager.NewLock(ctx, dbConn, uuid.NewString())
+		require.NoError(t, err)
+
+		require.NoError(t, lock.Acquire(ctx, dbConn, lockTimeout))
+		remaining, err := lock.TimeRemaining(ctx, dbConn)
+		require.NoError(t, err)
+		require.Greater(t, remaining, lockTimeout/2)
+		require.LessOrEqual(t, remaining, lockTimeout)
+
+		require.NoError(t, lock.Release(ctx, dbConn))
+		remaining, err = lock.TimeRemaining(ctx, dbConn)
+		require.NoError(t, err)
+		require.LessOrEqual(t, remaining, time.Duration(0))
+	})
+
+	t.Run("lock owner tracking", func(t *gotesting.T) {
+		const lockTimeout = 10 * time.Second
+		ctx, ctxCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer ctxCancel()
+
+		ownerManager, err := NewDBManager(dialect, WithOwner("pod-1"))
+		require.NoError(t, err)
+		// Columns are added to the existing table, the second call is no-op.
+		require.NoError(t, ownerManager.CreateTable(ctx, dbConn))
+		require.NoError(t, ownerManager.CreateTable(ctx, dbConn))
+
+		lockKey := uuid.NewString()
+		lock, err := ownerManager.NewLock(ctx, dbConn, lockKey)
+		require.NoError(t, err)
+
+		status, err := ownerManager.LockStatus(ctx, dbConn, lockKey)
+		require.NoError(t, err)
+		require.Equal(t, LockStatus{Key: lockKey}, status)
+
+		require.NoError(t, lock.Acquire(ctx, dbConn, lockTimeout))
+		status, err = ownerManager.LockStatus(ctx, dbConn, lockKey)
+		require.NoError(t, err)
+		require.True(t, status.Held)
+		require.Equal(t, "pod-1", status.Owner)
+		require.False(t, status.AcquiredAt.IsZero())
+
+		require.NoError(t, lock.Release(ctx, dbConn))
+		status, err = ownerManager.LockStatus(ctx, dbConn, lockKey)
+		require.NoError(t, err)
+		require.False(t, status.Held)
+		require.Equal(t, "pod-1", status.Owner)
+	})
 }
 
 func runDBLockDoExclusivelyTests(t *gotesting.T, dialect dbkit.Dialect) {
@@ -28,12 +28,7 @@ func ExampleDoExclusively() {
 	ctx := context.Background()
 
 	// Create "distributed_locks" table for locks.
-	createTableSQL, err := distrlock.CreateTableSQL(dbkit.DialectMySQL)
-	if err != nil {
-		log.Fatal(err)
-	}
-	_, err = db.ExecContext(ctx, createTableSQL)
-	if err != nil {
+	if err = distrlock.EnsureLockTable(ctx, db, dbkit.DialectMySQL); err != nil {
 		log.Fatal(err)
 	}
 
@@ -66,8 +61,7 @@ func ExampleNewDBManager() {
 	ctx := context.Background()
 
 	// Create table for locks.
-	_, err = db.ExecContext(ctx, lockManager.CreateTableSQL())
-	if err != nil {
+	if err = lockManager.CreateTable(ctx, db); err != nil {
 		log.Fatal(err)
 	}
 
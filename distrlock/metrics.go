@@ -0,0 +1,101 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package distrlock
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultHeldLocksCollectInterval is a default interval between collecting the number of held locks.
+const DefaultHeldLocksCollectInterval = 30 * time.Second
+
+// PrometheusMetricsOpts represents an options for PrometheusMetrics.
+type PrometheusMetricsOpts struct {
+	// Namespace is a namespace for metrics. It will be prepended to all metric names.
+	Namespace string
+
+	// ConstLabels is a set of labels that will be applied to all metrics.
+	ConstLabels prometheus.Labels
+}
+
+// PrometheusMetrics represents collector of distributed locks metrics.
+type PrometheusMetrics struct {
+	// HeldLocks contains a number of currently held locks in the table (see DBManager.HeldLockCount).
+	// Its unexpected growth may indicate stuck workers that don't release locks.
+	HeldLocks prometheus.Gauge
+}
+
+// NewPrometheusMetrics creates a new distributed locks metrics collector.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return NewPrometheusMetricsWithOpts(PrometheusMetricsOpts{})
+}
+
+// NewPrometheusMetricsWithOpts is a more configurable version of creating PrometheusMetrics.
+func NewPrometheusMetricsWithOpts(opts PrometheusMetricsOpts) *PrometheusMetrics {
+	heldLocks := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   opts.Namespace,
+		Name:        "db_distributed_locks_held",
+		Help:        "A number of currently held distributed locks.",
+		ConstLabels: opts.ConstLabels,
+	})
+	return &PrometheusMetrics{HeldLocks: heldLocks}
+}
+
+// MustRegister does registration of metrics collector in Prometheus and panics if any error occurs.
+func (pm *PrometheusMetrics) MustRegister() {
+	prometheus.MustRegister(pm.HeldLocks)
+}
+
+// Unregister cancels registration of metrics collector in Prometheus.
+func (pm *PrometheusMetrics) Unregister() {
+	prometheus.Unregister(pm.HeldLocks)
+}
+
+// AllMetrics returns a list of metrics of this collector. This can be used to register these metrics in push gateway.
+func (pm *PrometheusMetrics) AllMetrics() []prometheus.Collector {
+	return []prometheus.Collector{pm.HeldLocks}
+}
+
+// CollectHeldLocks periodically (with the passed interval) reports the number of held locks in the table
+// managed by the DBManager into the HeldLocks gauge. It blocks until the context is canceled.
+// If the interval is not positive, DefaultHeldLocksCollectInterval is used.
+// Errors are logged with the passed logger (may be nil) and don't stop collecting.
+func (pm *PrometheusMetrics) CollectHeldLocks(
+	ctx context.Context, manager *DBManager, executor SQLQueryExecutor, interval time.Duration, logger Logger,
+) {
+	if interval <= 0 {
+		interval = DefaultHeldLocksCollectInterval
+	}
+	if logger == nil {
+		logger = disabledLogger{}
+	}
+	collect := func() {
+		count, err := manager.HeldLockCount(ctx, executor)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Errorf("failed to collect number of held distributed locks, error: %v", err)
+			}
+			return
+		}
+		pm.HeldLocks.Set(float64(count))
+	}
+
+	collect()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			collect()
+		}
+	}
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package distrlock
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/acronis/go-dbkit/migrate"
+)
+
+// Default acquisition retry intervals that are used by the locker returned by DBManager.MigrationsRunLocker.
+const (
+	DefaultMigrationsLockRetryMaxWait      = 5 * time.Second
+	DefaultMigrationsLockRetryBaseInterval = 100 * time.Millisecond
+)
+
+type migrationsRunLocker struct {
+	manager *DBManager
+	dbConn  *sql.DB
+	key     string
+	options []DoOption
+}
+
+// MigrationsRunLocker returns migrate.RunLocker that serializes migrations runs (see migrate.MigrationsManagerOpts.RunLocker)
+// on the distributed lock with the passed key, so concurrently started instances apply migrations one by one.
+// The locks table is created (if it doesn't exist) before acquiring the lock, since it can't be created by migrations themselves.
+// The lock is acquired with retries (DefaultMigrationsLockRetryMaxWait and DefaultMigrationsLockRetryBaseInterval
+// are used, WithAcquireRetry may be passed for overriding them), extended periodically while migrations are applied,
+// and released after that. Its TTL (see WithLockTTL) protects from a crashed migrations job holding the lock forever.
+// The context passed to migrate.MigrationsManager.RunContext bounds the lock acquisition,
+// and the run is stopped before the next migration if the lock is lost.
+func (m *DBManager) MigrationsRunLocker(dbConn *sql.DB, key string, options ...DoOption) migrate.RunLocker {
+	return &migrationsRunLocker{manager: m, dbConn: dbConn, key: key, options: options}
+}
+
+// DoExclusively acquires the lock, calls passed function and releases the lock when the function is finished.
+func (l *migrationsRunLocker) DoExclusively(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := l.manager.CreateTable(ctx, l.dbConn); err != nil {
+		return err
+	}
+	lock, err := l.manager.NewLock(ctx, l.dbConn, l.key)
+	if err != nil {
+		return fmt.Errorf("create new lock: %w", err)
+	}
+	options := append([]DoOption{
+		WithAcquireRetry(DefaultMigrationsLockRetryMaxWait, DefaultMigrationsLockRetryBaseInterval),
+	}, l.options...)
+	return lock.DoExclusively(ctx, l.dbConn, fn, options...)
+}
@@ -0,0 +1,78 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package distrlock
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultStatsMaxKeys is a default maximum number of distinct lock keys tracked by DBManager stats.
+const DefaultStatsMaxKeys = 1000
+
+// LockKeyStats contains acquisition counters for a single lock key.
+type LockKeyStats struct {
+	// Acquired is a number of successful acquisitions.
+	Acquired uint64
+	// Contended is a number of failed acquisitions because the lock was already acquired by someone else.
+	Contended uint64
+}
+
+// keyStatsTracker keeps LockKeyStats for a bounded number of keys evicting the least recently used ones.
+type keyStatsTracker struct {
+	mu      sync.Mutex
+	maxKeys int
+	lru     *list.List
+	items   map[string]*list.Element
+}
+
+type keyStatsEntry struct {
+	key   string
+	stats LockKeyStats
+}
+
+func newKeyStatsTracker(maxKeys int) *keyStatsTracker {
+	if maxKeys <= 0 {
+		maxKeys = DefaultStatsMaxKeys
+	}
+	return &keyStatsTracker{maxKeys: maxKeys, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (t *keyStatsTracker) record(key string, acquired bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	elem, ok := t.items[key]
+	if ok {
+		t.lru.MoveToFront(elem)
+	} else {
+		if t.lru.Len() >= t.maxKeys {
+			oldest := t.lru.Back()
+			t.lru.Remove(oldest)
+			delete(t.items, oldest.Value.(*keyStatsEntry).key)
+		}
+		elem = t.lru.PushFront(&keyStatsEntry{key: key})
+		t.items[key] = elem
+	}
+	entry := elem.Value.(*keyStatsEntry)
+	if acquired {
+		entry.stats.Acquired++
+	} else {
+		entry.stats.Contended++
+	}
+}
+
+func (t *keyStatsTracker) snapshot() map[string]LockKeyStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make(map[string]LockKeyStats, len(t.items))
+	for key, elem := range t.items {
+		result[key] = elem.Value.(*keyStatsEntry).stats
+	}
+	return result
+}
@@ -7,8 +7,10 @@ Released under MIT license.
 package dbkit
 
 import (
+	"database/sql"
 	"fmt"
 	"sort"
+	"strconv"
 	"strings"
 
 	"net/url"
@@ -48,11 +50,42 @@ func MakeMySQLDSN(cfg *MySQLConfig) string {
 	c.DBName = cfg.Database
 	c.ParseTime = true
 	c.MultiStatements = true
+	c.TLSConfig = cfg.TLS
+	c.ConnectionAttributes = makeMySQLConnectionAttributes(cfg.ConnectionAttributes)
 	c.Params = make(map[string]string)
 	c.Params["autocommit"] = "false"
+	if cfg.SessionTxLevel {
+		if level, ok := mySQLTxIsolationLevels[sql.IsolationLevel(cfg.TxIsolationLevel)]; ok {
+			c.Params[mySQLTxIsolationParam] = "'" + level + "'"
+		}
+	}
 	return c.FormatDSN()
 }
 
+// makeMySQLConnectionAttributes makes a value of the connectionAttributes DSN parameter ("key1:value1,key2:value2").
+// Attributes are sorted by keys, so the DSN is deterministic.
+func makeMySQLConnectionAttributes(attrs map[string]string) string {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+":"+attrs[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+const mySQLTxIsolationParam = "transaction_isolation"
+
+var mySQLTxIsolationLevels = map[sql.IsolationLevel]string{
+	sql.LevelReadUncommitted: "READ-UNCOMMITTED",
+	sql.LevelReadCommitted:   "READ-COMMITTED",
+	sql.LevelRepeatableRead:  "REPEATABLE-READ",
+	sql.LevelSerializable:    "SERIALIZABLE",
+}
+
 // MakePostgresDSN makes DSN for opening Postgres database.
 func MakePostgresDSN(cfg *PostgresConfig) string {
 	sslMode := cfg.SSLMode
@@ -69,6 +102,14 @@ func MakePostgresDSN(cfg *PostgresConfig) string {
 	if cfg.SearchPath != "" {
 		connURI.RawQuery += fmt.Sprintf("&search_path=%s", url.QueryEscape(cfg.SearchPath))
 	}
+	if cfg.PgxQueryExecMode != "" {
+		connURI.RawQuery += fmt.Sprintf("&%s=%s", pgxQueryExecModeParam, url.QueryEscape(string(cfg.PgxQueryExecMode)))
+	}
+	txIsolationLevel, useTxIsolationLevel := postgresTxIsolationLevels[sql.IsolationLevel(cfg.TxIsolationLevel)]
+	useTxIsolationLevel = useTxIsolationLevel && cfg.SessionTxLevel
+	if useTxIsolationLevel {
+		connURI.RawQuery += fmt.Sprintf("&%s=%s", postgresTxIsolationParam, url.QueryEscape(txIsolationLevel))
+	}
 	if len(cfg.AdditionalParameters) == 0 {
 		return connURI.String()
 	}
@@ -79,15 +120,149 @@ func MakePostgresDSN(cfg *PostgresConfig) string {
 	if cfg.SearchPath != "" {
 		ignore["search_path"] = struct{}{}
 	}
+	if cfg.PgxQueryExecMode != "" {
+		ignore[pgxQueryExecModeParam] = struct{}{}
+	}
+	if useTxIsolationLevel {
+		ignore[postgresTxIsolationParam] = struct{}{}
+	}
 
 	return urlWithOptionalParameters(connURI, cfg.AdditionalParameters,
 		ignore)
 }
 
+const pgxQueryExecModeParam = "default_query_exec_mode"
+
+const postgresTxIsolationParam = "default_transaction_isolation"
+
+var postgresTxIsolationLevels = map[sql.IsolationLevel]string{
+	sql.LevelReadUncommitted: "read uncommitted",
+	sql.LevelReadCommitted:   "read committed",
+	sql.LevelRepeatableRead:  "repeatable read",
+	sql.LevelSerializable:    "serializable",
+}
+
 // MakeSQLiteDSN makes DSN for opening SQLite database.
+// If additional parameters are specified, DSN is built in the URI format (file:path?param=value).
 func MakeSQLiteDSN(cfg *SQLiteConfig) string {
-	// Connection params will be used here in the future.
-	return cfg.Path
+	if len(cfg.AdditionalParameters) == 0 {
+		return cfg.Path
+	}
+	dsn := cfg.Path
+	if !strings.HasPrefix(dsn, sqliteURIPrefix) {
+		dsn = sqliteURIPrefix + dsn
+	}
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	return dsn + sep + strings.Join(makeSortedQueryParts(cfg.AdditionalParameters, nil), "&")
+}
+
+const (
+	sqliteURIPrefix  = "file:"
+	sqliteMemoryPath = ":memory:"
+)
+
+// isPrivateMemory returns true if SQLite database is in-memory and is not shared across connections.
+// Such database is created per connection, so each connection in the pool sees its own empty database.
+func (cfg *SQLiteConfig) isPrivateMemory() bool {
+	dsn := MakeSQLiteDSN(cfg)
+	if dsn == sqliteMemoryPath {
+		return true
+	}
+	if !strings.HasPrefix(dsn, sqliteURIPrefix+sqliteMemoryPath) && !strings.Contains(dsn, "mode=memory") {
+		return false
+	}
+	return !strings.Contains(dsn, "cache=shared")
+}
+
+// RedactedPassword is used instead of the real password in the parameters returned by Config.DSNParameters
+// and in the config returned by Config.Redacted.
+const RedactedPassword = "[REDACTED]"
+
+// DSNParameters returns driver name and parameters that are encoded in the DSN built for the configured dialect.
+// Besides the query parameters (sslmode, search_path, etc.), connection attributes are returned
+// (e.g. host, port, user, database, path). Password is replaced with RedactedPassword.
+// It may be used for showing users which parameters will be sent to the driver.
+func (c *Config) DSNParameters() (driverName string, params map[string]string, err error) {
+	driverName, dsn := c.DriverNameAndDSN()
+	switch c.Dialect {
+	case DialectMySQL:
+		params, err = mySQLDSNParameters(dsn)
+	case DialectPostgres, DialectPgx, DialectMSSQL:
+		params, err = urlDSNParameters(dsn)
+	case DialectSQLite:
+		params, err = sqliteDSNParameters(dsn)
+	default:
+		return "", nil, fmt.Errorf("unknown dialect %q", c.Dialect)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("parse dsn: %w", err)
+	}
+	return driverName, params, nil
+}
+
+func mySQLDSNParameters(dsn string) (map[string]string, error) {
+	c, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]string{
+		"net":             c.Net,
+		"addr":            c.Addr,
+		"user":            c.User,
+		"dbname":          c.DBName,
+		"parseTime":       strconv.FormatBool(c.ParseTime),
+		"multiStatements": strconv.FormatBool(c.MultiStatements),
+	}
+	if c.Passwd != "" {
+		params["password"] = RedactedPassword
+	}
+	if c.TLSConfig != "" {
+		params["tls"] = c.TLSConfig
+	}
+	if c.ConnectionAttributes != "" {
+		params["connectionAttributes"] = c.ConnectionAttributes
+	}
+	for k, v := range c.Params {
+		params[k] = v
+	}
+	return params, nil
+}
+
+func urlDSNParameters(dsn string) (map[string]string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]string{"host": u.Hostname(), "port": u.Port()}
+	if u.User != nil {
+		params["user"] = u.User.Username()
+		if _, ok := u.User.Password(); ok {
+			params["password"] = RedactedPassword
+		}
+	}
+	if database := strings.TrimPrefix(u.Path, "/"); database != "" {
+		params["database"] = database
+	}
+	for k, v := range u.Query() {
+		params[k] = v[0]
+	}
+	return params, nil
+}
+
+func sqliteDSNParameters(dsn string) (map[string]string, error) {
+	path, rawQuery, _ := strings.Cut(dsn, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	params := map[string]string{"path": path}
+	for k, v := range query {
+		params[k] = v[0]
+	}
+	return params, nil
 }
 
 func urlWithOptionalParameters(
@@ -95,6 +270,13 @@ func urlWithOptionalParameters(
 	params map[string]string,
 	keysToIgnore map[string]struct{},
 ) string {
+	if queryParts := makeSortedQueryParts(params, keysToIgnore); len(queryParts) != 0 {
+		u.RawQuery += "&" + strings.Join(queryParts, "&")
+	}
+	return u.String()
+}
+
+func makeSortedQueryParts(params map[string]string, keysToIgnore map[string]struct{}) []string {
 	queryParts := make([]string, 0, len(params))
 	for k, v := range params {
 		if _, ok := keysToIgnore[k]; ok {
@@ -103,6 +285,5 @@ func urlWithOptionalParameters(
 		queryParts = append(queryParts, fmt.Sprintf("%s=%s", k, url.QueryEscape(v)))
 	}
 	sort.Strings(queryParts) // Sort to make DSN deterministic.
-	u.RawQuery += "&" + strings.Join(queryParts, "&")
-	return u.String()
+	return queryParts
 }
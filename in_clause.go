@@ -0,0 +1,60 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"strconv"
+	"strings"
+)
+
+// InClause builds "<column> IN (<placeholders>)" clause in the style of the passed dialect:
+// the column is quoted (each part of the qualified name like "users.id" is quoted separately),
+// placeholders are $1, $2, ... for Postgres, @p1, @p2, ... for MSSQL and ? for MySQL and SQLite.
+// Values are returned as args in the same order, so they may be passed to the query as is:
+//
+//	clause, args := dbkit.InClause(dialect, "id", ids)
+//	rows, err := db.QueryContext(ctx, "SELECT name FROM users WHERE "+clause, args...)
+//
+// Numbered placeholders start from 1, so the clause should precede other parameters of the query.
+// If values are empty, "1 = 0" clause that matches nothing is returned since the empty IN list is invalid SQL.
+func InClause(dialect Dialect, column string, values []interface{}) (clause string, args []interface{}) {
+	if len(values) == 0 {
+		return "1 = 0", nil
+	}
+	var sb strings.Builder
+	sb.WriteString(quoteQualifiedIdentifier(dialect, column))
+	sb.WriteString(" IN (")
+	for i := range values {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(placeholder(dialect, i+1))
+	}
+	sb.WriteString(")")
+	return sb.String(), append([]interface{}(nil), values...)
+}
+
+// placeholder returns the query parameter placeholder with the passed (1-based) number in the style of the dialect.
+func placeholder(dialect Dialect, num int) string {
+	switch dialect {
+	case DialectPostgres, DialectPgx:
+		return "$" + strconv.Itoa(num)
+	case DialectMSSQL:
+		return "@p" + strconv.Itoa(num)
+	default:
+		return "?"
+	}
+}
+
+// quoteQualifiedIdentifier quotes each part of the qualified name (like "users.id") separately.
+func quoteQualifiedIdentifier(dialect Dialect, name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quoteIdentifier(dialect, part)
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInClause(t *testing.T) {
+	values := []interface{}{1, "two", 3}
+	tests := []struct {
+		dialect    Dialect
+		column     string
+		wantClause string
+	}{
+		{DialectPostgres, "id", `"id" IN ($1, $2, $3)`},
+		{DialectPgx, "users.id", `"users"."id" IN ($1, $2, $3)`},
+		{DialectMySQL, "users.id", "`users`.`id` IN (?, ?, ?)"},
+		{DialectMSSQL, "id", "[id] IN (@p1, @p2, @p3)"},
+		{DialectSQLite, `we"ird`, `"we""ird" IN (?, ?, ?)`},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.dialect), func(t *testing.T) {
+			clause, args := InClause(tt.dialect, tt.column, values)
+			require.Equal(t, tt.wantClause, clause)
+			require.Equal(t, values, args)
+		})
+	}
+
+	clause, args := InClause(DialectPostgres, "id", nil)
+	require.Equal(t, "1 = 0", clause)
+	require.Empty(t, args)
+
+	dbConn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+	_, err = dbConn.Exec(`CREATE TABLE users (id INTEGER, name TEXT); INSERT INTO users VALUES (1, 'a'), (2, 'b'), (3, 'c')`)
+	require.NoError(t, err)
+
+	countIn := func(ids []interface{}) int {
+		inClause, inArgs := InClause(DialectSQLite, "users.id", ids)
+		var count int
+		require.NoError(t, dbConn.QueryRow("SELECT COUNT(*) FROM users WHERE "+inClause, inArgs...).Scan(&count))
+		return count
+	}
+	require.Equal(t, 2, countIn([]interface{}{1, 3, 4}))
+	require.Equal(t, 0, countIn(nil))
+}
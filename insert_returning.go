@@ -0,0 +1,81 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// InsertExecutor is an interface for executing insert queries (it's implemented by *sql.DB, *sql.Tx and *sql.Conn).
+type InsertExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// InsertReturningID inserts the row (column name -> value) into the table and returns the generated ID
+// using the construct of the passed dialect:
+//   - Postgres: "INSERT ... RETURNING <idCol>";
+//   - MSSQL: "INSERT ... OUTPUT INSERTED.<idCol> ...";
+//   - MySQL and SQLite: sql.Result.LastInsertId (idCol is not used since the auto-increment column is returned).
+//
+// Table (may be qualified with schema, e.g. "public.users") and column names are quoted,
+// columns are inserted in the lexical order. If the row is empty, all columns get their default values.
+func InsertReturningID(
+	ctx context.Context, executor InsertExecutor, dialect Dialect, table string, row map[string]interface{}, idCol string,
+) (int64, error) {
+	columns := make([]string, 0, len(row))
+	for col := range row {
+		columns = append(columns, col)
+	}
+	sort.Strings(columns)
+	quotedCols := make([]string, 0, len(columns))
+	placeholders := make([]string, 0, len(columns))
+	args := make([]interface{}, 0, len(columns))
+	for i, col := range columns {
+		quotedCols = append(quotedCols, quoteIdentifier(dialect, col))
+		placeholders = append(placeholders, placeholder(dialect, i+1))
+		args = append(args, row[col])
+	}
+
+	insertInto := "INSERT INTO " + quoteQualifiedIdentifier(dialect, table)
+	values := " DEFAULT VALUES"
+	if len(columns) != 0 {
+		insertInto += " (" + strings.Join(quotedCols, ", ") + ")"
+		values = " VALUES (" + strings.Join(placeholders, ", ") + ")"
+	}
+
+	var id int64
+	switch dialect {
+	case DialectPostgres, DialectPgx:
+		query := insertInto + values + " RETURNING " + quoteIdentifier(dialect, idCol)
+		if err := executor.QueryRowContext(ctx, query, args...).Scan(&id); err != nil {
+			return 0, err
+		}
+		return id, nil
+	case DialectMSSQL:
+		query := insertInto + " OUTPUT INSERTED." + quoteIdentifier(dialect, idCol) + values
+		if err := executor.QueryRowContext(ctx, query, args...).Scan(&id); err != nil {
+			return 0, err
+		}
+		return id, nil
+	case DialectMySQL, DialectSQLite:
+		if dialect == DialectMySQL && len(columns) == 0 {
+			values = " () VALUES ()" // MySQL doesn't support DEFAULT VALUES.
+		}
+		result, err := executor.ExecContext(ctx, insertInto+values, args...)
+		if err != nil {
+			return 0, err
+		}
+		return result.LastInsertId()
+	default:
+		return 0, fmt.Errorf("unsupported sql dialect %q", dialect)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInsertReturningID(t *testing.T) {
+	t.Run("sqlite", func(t *testing.T) {
+		dbConn, err := sql.Open("sqlite3", ":memory:")
+		require.NoError(t, err)
+		defer func() { require.NoError(t, dbConn.Close()) }()
+		dbConn.SetMaxOpenConns(1)
+		_, err = dbConn.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT DEFAULT 'anonymous', age INT)")
+		require.NoError(t, err)
+
+		id, err := InsertReturningID(context.Background(), dbConn, DialectSQLite, "users",
+			map[string]interface{}{"name": "Alice", "age": 30}, "id")
+		require.NoError(t, err)
+		require.Equal(t, int64(1), id)
+
+		id, err = InsertReturningID(context.Background(), dbConn, DialectSQLite, "users", nil, "id")
+		require.NoError(t, err)
+		require.Equal(t, int64(2), id)
+
+		var name string
+		require.NoError(t, dbConn.QueryRow("SELECT name FROM users WHERE id = 2").Scan(&name))
+		require.Equal(t, "anonymous", name)
+	})
+
+	tests := []struct {
+		name     string
+		dialect  Dialect
+		table    string
+		row      map[string]interface{}
+		initMock func(m sqlmock.Sqlmock)
+	}{
+		{
+			name:    "postgres",
+			dialect: DialectPostgres,
+			table:   "public.users",
+			row:     map[string]interface{}{"name": "Alice", "age": 30},
+			initMock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(`INSERT INTO "public"."users" ("age", "name") VALUES ($1, $2) RETURNING "id"`).
+					WithArgs(30, "Alice").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+			},
+		},
+		{
+			name:    "mssql",
+			dialect: DialectMSSQL,
+			table:   "users",
+			row:     map[string]interface{}{"name": "Alice"},
+			initMock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(`INSERT INTO [users] ([name]) OUTPUT INSERTED.[id] VALUES (@p1)`).
+					WithArgs("Alice").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+			},
+		},
+		{
+			name:    "mssql, default values",
+			dialect: DialectMSSQL,
+			table:   "users",
+			initMock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(`INSERT INTO [users] OUTPUT INSERTED.[id] DEFAULT VALUES`).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+			},
+		},
+		{
+			name:    "mysql",
+			dialect: DialectMySQL,
+			table:   "users",
+			row:     map[string]interface{}{"name": "Alice"},
+			initMock: func(m sqlmock.Sqlmock) {
+				m.ExpectExec("INSERT INTO `users` (`name`) VALUES (?)").
+					WithArgs("Alice").WillReturnResult(sqlmock.NewResult(42, 1))
+			},
+		},
+		{
+			name:    "mysql, default values",
+			dialect: DialectMySQL,
+			table:   "users",
+			initMock: func(m sqlmock.Sqlmock) {
+				m.ExpectExec("INSERT INTO `users` () VALUES ()").WillReturnResult(sqlmock.NewResult(42, 1))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbConn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.NoError(t, err)
+			defer func() { _ = dbConn.Close() }()
+			tt.initMock(mock)
+
+			id, err := InsertReturningID(context.Background(), dbConn, tt.dialect, tt.table, tt.row, "id")
+			require.NoError(t, err)
+			require.Equal(t, int64(42), id)
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	_, err := InsertReturningID(context.Background(), nil, "oracle", "users", nil, "id")
+	require.EqualError(t, err, `unsupported sql dialect "oracle"`)
+}
@@ -0,0 +1,40 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import "strings"
+
+// LikeEscapeChar is an escape character that is used by EscapeLike.
+// It's not a backslash, since backslash has a special meaning in string literals of some dialects (e.g. MySQL),
+// so the same ESCAPE clause may be used for all dialects.
+const LikeEscapeChar = '!'
+
+// LikeEscapeClause is an ESCAPE clause that should follow the LIKE pattern escaped by EscapeLike.
+const LikeEscapeClause = "ESCAPE '!'"
+
+// EscapeLike escapes wildcard characters (% and _, and [ for MSSQL) and the escape character itself
+// in the passed string, so it may be safely used as a part of the LIKE pattern (e.g. built from user input).
+// The pattern should be used with the explicit ESCAPE clause (see LikeEscapeClause), since the default escape character
+// differs across dialects (SQLite and MSSQL don't have it at all):
+//
+//	db.QueryContext(ctx, "SELECT id FROM users WHERE name LIKE ? "+dbkit.LikeEscapeClause,
+//		"%"+dbkit.EscapeLike(dialect, userInput)+"%")
+func EscapeLike(dialect Dialect, s string) string {
+	specialChars := "%_"
+	if dialect == DialectMSSQL {
+		specialChars += "["
+	}
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for _, r := range s {
+		if r == LikeEscapeChar || strings.ContainsRune(specialChars, r) {
+			sb.WriteRune(LikeEscapeChar)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
@@ -0,0 +1,39 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscapeLike(t *testing.T) {
+	require.Equal(t, "100!%!_off!!", EscapeLike(DialectPostgres, "100%_off!"))
+	require.Equal(t, "[abc]", EscapeLike(DialectMySQL, "[abc]"))
+	require.Equal(t, "![abc]", EscapeLike(DialectMSSQL, "[abc]"))
+	require.Equal(t, "plain text", EscapeLike(DialectSQLite, "plain text"))
+
+	dbConn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+	_, err = dbConn.Exec(`CREATE TABLE items (name TEXT); INSERT INTO items VALUES ('100% off'), ('1000 off'), ('a_b'), ('axb'), ('wow!')`)
+	require.NoError(t, err)
+
+	countLike := func(pattern string) int {
+		var count int
+		require.NoError(t, dbConn.QueryRow("SELECT COUNT(*) FROM items WHERE name LIKE ? "+LikeEscapeClause,
+			"%"+EscapeLike(DialectSQLite, pattern)+"%").Scan(&count))
+		return count
+	}
+	require.Equal(t, 1, countLike("100%"))
+	require.Equal(t, 1, countLike("a_b"))
+	require.Equal(t, 1, countLike("!"))
+	require.Equal(t, 5, countLike(""))
+}
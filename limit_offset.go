@@ -0,0 +1,45 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import "strconv"
+
+// mySQLMaxLimit is used as LIMIT when only OFFSET is needed since MySQL doesn't support OFFSET without LIMIT.
+const mySQLMaxLimit = "18446744073709551615"
+
+// LimitOffset returns pagination clause in the style of the passed dialect:
+// "LIMIT <limit> OFFSET <offset>" for Postgres, MySQL and SQLite,
+// and "OFFSET <offset> ROWS FETCH NEXT <limit> ROWS ONLY" for MSSQL.
+// Non-positive limit means no limit (only offset is applied), negative offset is treated as 0.
+//
+// MSSQL requires ORDER BY for OFFSET/FETCH, so the clause must follow the ORDER BY clause of the query
+// (it should be used anyway since the order of rows without it is not deterministic for any dialect):
+//
+//	rows, err := db.QueryContext(ctx, "SELECT id, name FROM users ORDER BY id "+dbkit.LimitOffset(dialect, 20, 40))
+func LimitOffset(dialect Dialect, limit, offset int) string {
+	if offset < 0 {
+		offset = 0
+	}
+	offsetStr := strconv.Itoa(offset)
+	if dialect == DialectMSSQL {
+		if limit <= 0 {
+			return "OFFSET " + offsetStr + " ROWS"
+		}
+		return "OFFSET " + offsetStr + " ROWS FETCH NEXT " + strconv.Itoa(limit) + " ROWS ONLY"
+	}
+	if limit > 0 {
+		return "LIMIT " + strconv.Itoa(limit) + " OFFSET " + offsetStr
+	}
+	switch dialect {
+	case DialectMySQL:
+		return "LIMIT " + mySQLMaxLimit + " OFFSET " + offsetStr
+	case DialectSQLite:
+		return "LIMIT -1 OFFSET " + offsetStr
+	default:
+		return "OFFSET " + offsetStr
+	}
+}
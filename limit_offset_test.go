@@ -0,0 +1,60 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitOffset(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect Dialect
+		limit   int
+		offset  int
+		want    string
+	}{
+		{"postgres", DialectPostgres, 20, 40, "LIMIT 20 OFFSET 40"},
+		{"pgx without limit", DialectPgx, 0, 40, "OFFSET 40"},
+		{"mysql", DialectMySQL, 20, 0, "LIMIT 20 OFFSET 0"},
+		{"mysql without limit", DialectMySQL, -1, 40, "LIMIT 18446744073709551615 OFFSET 40"},
+		{"sqlite without limit", DialectSQLite, 0, 40, "LIMIT -1 OFFSET 40"},
+		{"mssql", DialectMSSQL, 20, 40, "OFFSET 40 ROWS FETCH NEXT 20 ROWS ONLY"},
+		{"mssql without limit", DialectMSSQL, 0, -5, "OFFSET 0 ROWS"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, LimitOffset(tt.dialect, tt.limit, tt.offset))
+		})
+	}
+
+	dbConn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+	_, err = dbConn.Exec(`CREATE TABLE users (id INTEGER); INSERT INTO users VALUES (1), (2), (3), (4), (5)`)
+	require.NoError(t, err)
+
+	selectIDs := func(limit, offset int) []int {
+		rows, err := dbConn.Query("SELECT id FROM users ORDER BY id " + LimitOffset(DialectSQLite, limit, offset))
+		require.NoError(t, err)
+		defer func() { require.NoError(t, rows.Close()) }()
+		var ids []int
+		for rows.Next() {
+			var id int
+			require.NoError(t, rows.Scan(&id))
+			ids = append(ids, id)
+		}
+		require.NoError(t, rows.Err())
+		return ids
+	}
+	require.Equal(t, []int{3, 4}, selectIDs(2, 2))
+	require.Equal(t, []int{4, 5}, selectIDs(0, 3))
+}
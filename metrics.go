@@ -18,6 +18,9 @@ const PrometheusMetricsLabelQuery = "query"
 // DefaultQueryDurationBuckets is default buckets into which observations of executing SQL queries are counted.
 var DefaultQueryDurationBuckets = []float64{0.001, 0.01, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
 
+// DefaultConnWaitDurationBuckets is default buckets into which observations of waiting for a connection are counted.
+var DefaultConnWaitDurationBuckets = []float64{0.0001, 0.001, 0.01, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
 // PrometheusMetricsOpts represents an options for PrometheusMetrics.
 type PrometheusMetricsOpts struct {
 	// Namespace is a namespace for metrics. It will be prepended to all metric names.
@@ -26,6 +29,9 @@ type PrometheusMetricsOpts struct {
 	// QueryDurationBuckets is a list of buckets into which observations of executing SQL queries are counted.
 	QueryDurationBuckets []float64
 
+	// ConnWaitDurationBuckets is a list of buckets into which observations of waiting for a connection are counted.
+	ConnWaitDurationBuckets []float64
+
 	// ConstLabels is a set of labels that will be applied to all metrics.
 	ConstLabels prometheus.Labels
 
@@ -40,6 +46,10 @@ type PrometheusMetricsOpts struct {
 // PrometheusMetrics represents collector of metrics.
 type PrometheusMetrics struct {
 	QueryDurations *prometheus.HistogramVec
+
+	// ConnWaitDurations contains durations of waiting for a free connection from the pool.
+	// Long waits are a symptom of the pool exhaustion (unlike long query durations). See WithConnWaitObserver.
+	ConnWaitDurations *prometheus.HistogramVec
 }
 
 // NewPrometheusMetrics creates a new metrics collector.
@@ -65,32 +75,53 @@ func NewPrometheusMetricsWithOpts(opts PrometheusMetricsOpts) *PrometheusMetrics
 		},
 		labelNames,
 	)
-	return &PrometheusMetrics{QueryDurations: queryDurations}
+	connWaitDurationBuckets := opts.ConnWaitDurationBuckets
+	if connWaitDurationBuckets == nil {
+		connWaitDurationBuckets = DefaultConnWaitDurationBuckets
+	}
+	connWaitDurations := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Name:        "db_conn_wait_seconds",
+			Help:        "A histogram of the durations of waiting for a connection from the pool.",
+			Buckets:     connWaitDurationBuckets,
+			ConstLabels: opts.ConstLabels,
+		},
+		opts.CurriedLabelNames,
+	)
+	return &PrometheusMetrics{QueryDurations: queryDurations, ConnWaitDurations: connWaitDurations}
 }
 
 // MustCurryWith curries the metrics collector with the provided labels.
 func (pm *PrometheusMetrics) MustCurryWith(labels prometheus.Labels) *PrometheusMetrics {
 	return &PrometheusMetrics{
-		QueryDurations: pm.QueryDurations.MustCurryWith(labels).(*prometheus.HistogramVec),
+		QueryDurations:    pm.QueryDurations.MustCurryWith(labels).(*prometheus.HistogramVec),
+		ConnWaitDurations: pm.ConnWaitDurations.MustCurryWith(labels).(*prometheus.HistogramVec),
 	}
 }
 
 // MustRegister does registration of metrics collector in Prometheus and panics if any error occurs.
 func (pm *PrometheusMetrics) MustRegister() {
-	prometheus.MustRegister(pm.QueryDurations)
+	prometheus.MustRegister(pm.QueryDurations, pm.ConnWaitDurations)
 }
 
 // Unregister cancels registration of metrics collector in Prometheus.
 func (pm *PrometheusMetrics) Unregister() {
 	prometheus.Unregister(pm.QueryDurations)
+	prometheus.Unregister(pm.ConnWaitDurations)
 }
 
 // AllMetrics returns a list of metrics of this collector. This can be used to register these metrics in push gateway.
 func (pm *PrometheusMetrics) AllMetrics() []prometheus.Collector {
-	return []prometheus.Collector{pm.QueryDurations}
+	return []prometheus.Collector{pm.QueryDurations, pm.ConnWaitDurations}
 }
 
 // ObserveQueryDuration observes the duration of executing SQL query.
 func (pm *PrometheusMetrics) ObserveQueryDuration(query string, duration time.Duration) {
 	pm.QueryDurations.With(prometheus.Labels{PrometheusMetricsLabelQuery: query}).Observe(duration.Seconds())
 }
+
+// ObserveConnWait observes the duration of waiting for a connection from the pool.
+func (pm *PrometheusMetrics) ObserveConnWait(duration time.Duration) {
+	pm.ConnWaitDurations.With(prometheus.Labels{}).Observe(duration.Seconds())
+}
@@ -0,0 +1,50 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// BackfillInBatches executes the passed data-modifying query repeatedly until it affects zero rows.
+// Each execution is done in a separate committed transaction, so rows are not locked for the whole backfill
+// and transaction log doesn't grow too much. batchSize is passed as the last argument of the query,
+// so the query should limit the number of modified rows with it, e.g.:
+//
+//	UPDATE users SET status = 'active' WHERE id IN (SELECT id FROM users WHERE status IS NULL LIMIT ?)
+//
+// Context is checked between batches. It's intended to be used for large data migrations
+// that are run outside the transactional migrations (e.g. before or after MigrationsManager.Run).
+func BackfillInBatches(ctx context.Context, db *sql.DB, query string, batchSize int, args ...interface{}) error {
+	if batchSize <= 0 {
+		return fmt.Errorf("batch size should be positive, got %d", batchSize)
+	}
+	queryArgs := append(append(make([]interface{}, 0, len(args)+1), args...), batchSize)
+	for batch := 1; ; batch++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var affected int64
+		if err := dbkit.DoInTx(ctx, db, func(tx *sql.Tx) error {
+			res, err := tx.ExecContext(ctx, query, queryArgs...)
+			if err != nil {
+				return err
+			}
+			affected, err = res.RowsAffected()
+			return err
+		}); err != nil {
+			return fmt.Errorf("backfill batch #%d: %w", batch, err)
+		}
+		if affected == 0 {
+			return nil
+		}
+	}
+}
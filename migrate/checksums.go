@@ -0,0 +1,62 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/go-gorp/gorp/v3"
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+// ChecksumsTableSuffix is appended to the migrations table name for the table that stores checksums
+// of applied migrations (see MigrationsManagerOpts.VerifyDownChecksums).
+const ChecksumsTableSuffix = "_checksums"
+
+// checksumRecord is a row of the migrations checksums table.
+type checksumRecord struct {
+	ID       string `db:"id"`
+	Checksum string `db:"checksum"`
+}
+
+// addChecksumsTable adds the checksums table to the gorp.DbMap, so it's created along with the migrations table.
+func (mm *MigrationsManager) addChecksumsTable(dbMap *gorp.DbMap) {
+	tableMap := dbMap.AddTableWithNameAndSchema(checksumRecord{}, mm.gorpSchemaName(dbMap), mm.migSet.TableName+ChecksumsTableSuffix)
+	tableMap.SetKeys(false, "id")
+	if mm.opts.IDColumnLength > 0 {
+		tableMap.ColMap("id").SetMaxSize(mm.opts.IDColumnLength)
+	}
+	tableMap.ColMap("checksum").SetMaxSize(64) // Hex-encoded SHA-256.
+}
+
+// storeChecksum stores (or replaces the stale one, e.g. left by Squash) the checksum of the applied migration.
+func storeChecksum(executor gorp.SqlExecutor, id, checksum string) error {
+	if _, err := executor.Delete(&checksumRecord{ID: id}); err != nil {
+		return err
+	}
+	return executor.Insert(&checksumRecord{ID: id, Checksum: checksum})
+}
+
+// verifyChecksums checks that the stored checksums of the migrations planned to be rolled back
+// match the passed migrations, so their down SQL corresponds to what's actually applied.
+func verifyChecksums(
+	dbMap *gorp.DbMap, plannedMigrations []*migrate.PlannedMigration, migrationsByID map[string]Migration,
+) error {
+	for _, plannedMig := range plannedMigrations {
+		stored, err := dbMap.Get(checksumRecord{}, plannedMig.Id)
+		if err != nil {
+			return fmt.Errorf("get checksum of db migration %s: %w", plannedMig.Id, err)
+		}
+		if stored == nil {
+			continue // Migration is applied without the checksum.
+		}
+		if stored.(*checksumRecord).Checksum != MigrationChecksum(migrationsByID[plannedMig.Id]) {
+			return fmt.Errorf("%w: %s was changed after applying", ErrChecksumMismatch, plannedMig.Id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/go-gorp/gorp/v3"
+	"github.com/lib/pq"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// dataInsertMaxArgs limits the number of arguments of the single multi-row INSERT statement
+// that is used for loading data (999 is the lowest limit among the supported databases, it's used by older SQLite).
+const dataInsertMaxArgs = 999
+
+// MigrationData contains rows that are loaded into the table by the DataLoader migration.
+// All values are passed as text, so the database converts them to the column types.
+type MigrationData struct {
+	Table   string // May be qualified with schema (e.g. "public.countries").
+	Columns []string
+	Rows    [][]string
+}
+
+// DataLoader is an interface for Migration that loads data (e.g. large seed dataset) into the table
+// after executing its up SQL statements (in the same transaction if it's not disabled).
+// Data is loaded with COPY for Postgres (lib/pq driver, in transaction only since COPY statement is bound to the connection)
+// and with batched multi-row INSERTs otherwise (including pgx driver since COPY is not available via database/sql for it).
+// Data is not rendered by MigrationsManager.RenderMigration.
+type DataLoader interface {
+	Data() MigrationData
+}
+
+// DataMigration is a migration that loads data from the CSV (or TSV) content into the table.
+// It implements DataLoader interface.
+type DataMigration struct {
+	*CustomMigration
+	data MigrationData
+}
+
+// CopyDataMigration creates a migration that loads data from the CSV content (e.g. embedded file) into the table
+// during the up migration (see DataLoader). Content is tab-separated if the file name has ".tsv" extension.
+// The first record must be a header that lists the passed columns in the same order,
+// so column mismatch is detected before applying the migration.
+// Nothing is done during the down migration, rows may be deleted by a separate migration if needed.
+func CopyDataMigration(id, table string, columns []string, csvContent fs.File) (*DataMigration, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("data migration %s has no columns", id)
+	}
+	reader := csv.NewReader(csvContent)
+	if fileInfo, err := csvContent.Stat(); err == nil && strings.EqualFold(path.Ext(fileInfo.Name()), ".tsv") {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+	reader.FieldsPerRecord = len(columns)
+
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("data migration %s has no header", id)
+		}
+		return nil, fmt.Errorf("read header of data migration %s: %w", id, err)
+	}
+	for i, col := range columns {
+		if !strings.EqualFold(strings.TrimSpace(header[i]), col) {
+			return nil, fmt.Errorf("data migration %s header column #%d is %q, expected %q", id, i+1, header[i], col)
+		}
+	}
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read data migration %s: %w", id, err)
+	}
+	return &DataMigration{
+		CustomMigration: NewCustomMigration(id, nil, nil, nil, nil),
+		data:            MigrationData{Table: table, Columns: columns, Rows: rows},
+	}, nil
+}
+
+// Data returns rows that are loaded by the migration.
+func (m *DataMigration) Data() MigrationData {
+	return m.data
+}
+
+// loadData loads the migration data and returns the number of loaded rows.
+func (mm *MigrationsManager) loadData(data MigrationData, executor gorp.SqlExecutor, dbMap *gorp.DbMap) (int64, error) {
+	if len(data.Rows) == 0 {
+		return 0, nil
+	}
+	schema, table := "", data.Table
+	if idx := strings.LastIndex(table, "."); idx != -1 {
+		schema, table = table[:idx], table[idx+1:]
+	}
+	if tx, ok := executor.(*gorp.Transaction); ok && mm.driverDialect == dbkit.DialectPostgres {
+		return copyData(tx, schema, table, data)
+	}
+
+	rowsPerBatch := dataInsertMaxArgs / len(data.Columns)
+	if rowsPerBatch == 0 {
+		rowsPerBatch = 1
+	}
+	quotedCols := make([]string, 0, len(data.Columns))
+	for _, col := range data.Columns {
+		quotedCols = append(quotedCols, dbMap.Dialect.QuoteField(col))
+	}
+	insertPrefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES ",
+		dbMap.Dialect.QuotedTableForQuery(schema, table), strings.Join(quotedCols, ", "))
+
+	var loaded int64
+	for start := 0; start < len(data.Rows); start += rowsPerBatch {
+		batch := data.Rows[start:min(start+rowsPerBatch, len(data.Rows))]
+		var sb strings.Builder
+		sb.WriteString(insertPrefix)
+		args := make([]interface{}, 0, len(batch)*len(data.Columns))
+		for i, row := range batch {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString("(")
+			for j, val := range row {
+				if j > 0 {
+					sb.WriteString(", ")
+				}
+				sb.WriteString(dbMap.Dialect.BindVar(len(args)))
+				args = append(args, val)
+			}
+			sb.WriteString(")")
+		}
+		if _, err := executor.Exec(sb.String(), args...); err != nil {
+			return loaded, fmt.Errorf("insert rows #%d-#%d into %s: %w", start+1, start+len(batch), data.Table, err)
+		}
+		loaded += int64(len(batch))
+	}
+	return loaded, nil
+}
+
+// copyData loads data with Postgres COPY via lib/pq driver.
+func copyData(tx *gorp.Transaction, schema, table string, data MigrationData) (int64, error) {
+	query := pq.CopyIn(table, data.Columns...)
+	if schema != "" {
+		query = pq.CopyInSchema(schema, table, data.Columns...)
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return 0, fmt.Errorf("prepare copy into %s: %w", data.Table, err)
+	}
+	defer func() { _ = stmt.Close() }()
+	args := make([]interface{}, len(data.Columns))
+	for _, row := range data.Rows {
+		for i, val := range row {
+			args[i] = val
+		}
+		if _, err = stmt.Exec(args...); err != nil {
+			return 0, fmt.Errorf("copy into %s: %w", data.Table, err)
+		}
+	}
+	if _, err = stmt.Exec(); err != nil {
+		return 0, fmt.Errorf("copy into %s: %w", data.Table, err)
+	}
+	return int64(len(data.Rows)), nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/acronis/go-appkit/log"
+)
+
+// MigrationEventStatus defines possible values for status of the migration event.
+type MigrationEventStatus string
+
+// Migration event statuses.
+const (
+	MigrationEventStatusApplied MigrationEventStatus = "applied"
+	MigrationEventStatusFailed  MigrationEventStatus = "failed"
+)
+
+// MigrationEvent is a machine-readable description of the single migration applying or rolling back.
+// It's written as a single-line JSON object to the MigrationsManagerOpts.EventWriter.
+// The JSON schema is stable and may be relied upon by tooling:
+//
+//	{"id":"0001_create_users_table","direction":"up","status":"applied","duration_ms":12}
+//	{"id":"0002_seed_users_table","direction":"up","status":"failed","duration_ms":3,"error":"..."}
+//
+// Status "applied" is used for both directions, i.e. for the "down" direction it means that migration was rolled back.
+type MigrationEvent struct {
+	ID         string               `json:"id"`
+	Direction  MigrationsDirection  `json:"direction"`
+	Status     MigrationEventStatus `json:"status"`
+	DurationMs int64                `json:"duration_ms"`
+	Error      string               `json:"error,omitempty"`
+}
+
+// ProgressEventType defines possible values for type of the progress event.
+type ProgressEventType string
+
+// Progress event types.
+const (
+	ProgressEventTypeStart    ProgressEventType = "start"
+	ProgressEventTypeApplied  ProgressEventType = "applied"
+	ProgressEventTypeFinished ProgressEventType = "finished"
+	// ProgressEventTypeStatement is reported only if MigrationsManagerOpts.StatementProgress is enabled.
+	ProgressEventTypeStatement ProgressEventType = "statement"
+)
+
+// ProgressEvent describes the progress of migrations running and is passed to the MigrationsManagerOpts.Progress.
+// Type "start" is reported before the first migration (Index is 0), "applied" is reported after each applied
+// (or rolled back) migration (Index is 1-based), and "finished" is reported after the last one (Index equals Total).
+// Total is the number of migrations that are planned to be applied (or rolled back) during the run.
+// Nothing is reported if planning fails, and "finished" is not reported if some migration fails.
+// Type "statement" is reported after each executed statement of the migration that is being applied (Index is 1-based),
+// so the progress of the long migration may be tracked; loading of the migration data (see DataLoader) is counted
+// as the last statement.
+type ProgressEvent struct {
+	Type        ProgressEventType
+	Direction   MigrationsDirection
+	MigrationID string // Empty for "start" and "finished" events.
+	Index       int
+	Total       int
+
+	// Statement fields are set for "statement" events only.
+	StatementIndex int           // 1-based.
+	StatementTotal int           // Number of statements in the migration.
+	Elapsed        time.Duration // Time elapsed since the migration is started.
+}
+
+func (mm *MigrationsManager) reportProgress(event ProgressEvent) {
+	if mm.opts.Progress != nil {
+		mm.opts.Progress(event)
+	}
+}
+
+// reportStatementProgress reports the "statement" progress event via MigrationsManagerOpts.Progress,
+// or logs it if the callback is not set.
+func (mm *MigrationsManager) reportStatementProgress(event ProgressEvent) {
+	if mm.opts.Progress != nil {
+		mm.opts.Progress(event)
+		return
+	}
+	mm.logger.Info(fmt.Sprintf("db migration %s statement %d of %d is executed", event.MigrationID,
+		event.StatementIndex, event.StatementTotal), log.Int64("elapsed_ms", event.Elapsed.Milliseconds()))
+}
+
+func (mm *MigrationsManager) writeEvent(id string, direction MigrationsDirection, duration time.Duration, migErr error) {
+	if mm.opts.EventWriter == nil {
+		return
+	}
+	event := MigrationEvent{ID: id, Direction: direction, Status: MigrationEventStatusApplied, DurationMs: duration.Milliseconds()}
+	if migErr != nil {
+		event.Status = MigrationEventStatusFailed
+		event.Error = migErr.Error()
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		mm.logger.Warn("failed to marshal migration event", log.Error(err))
+		return
+	}
+	if _, err = mm.opts.EventWriter.Write(append(data, '\n')); err != nil {
+		mm.logger.Warn("failed to write migration event", log.Error(err))
+	}
+}
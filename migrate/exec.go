@@ -0,0 +1,733 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acronis/go-appkit/log"
+	"github.com/doug-martin/goqu/v9"
+	_ "github.com/doug-martin/goqu/v9/dialect/mysql"     // Register goqu dialect.
+	_ "github.com/doug-martin/goqu/v9/dialect/postgres"  // Register goqu dialect.
+	_ "github.com/doug-martin/goqu/v9/dialect/sqlite3"   // Register goqu dialect.
+	_ "github.com/doug-martin/goqu/v9/dialect/sqlserver" // Register goqu dialect.
+	"github.com/go-gorp/gorp/v3"
+	"github.com/go-sql-driver/mysql"
+	migrate "github.com/rubenv/sql-migrate"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// sortMigrations returns converted migrations in the order in which they should be applied.
+func (mm *MigrationsManager) sortMigrations(migrations []Migration, converted []*migrate.Migration) []*migrate.Migration {
+	indexes := make([]int, len(converted))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		if mm.opts.SortFunc != nil {
+			return mm.opts.SortFunc(migrations[indexes[i]], migrations[indexes[j]])
+		}
+		return converted[indexes[i]].Less(converted[indexes[j]])
+	})
+	sorted := make([]*migrate.Migration, 0, len(converted))
+	for _, idx := range indexes {
+		sorted = append(sorted, converted[idx])
+	}
+	return sorted
+}
+
+// execMax plans migrations and applies them one by one collecting timing information.
+// Original migrations (see Irreversible and DataLoader interfaces) are looked up in migrationsByID.
+// Nothing is rolled back if any of the planned migrations is irreversible.
+// The run is stopped with the context's error before the next migration (or statement) when the context is done.
+func (mm *MigrationsManager) execMax(
+	ctx context.Context, migrations []*migrate.Migration, dir migrate.MigrationDirection, limit int,
+	migrationsByID map[string]Migration,
+) (RunReport, error) {
+	var report RunReport
+	plannedMigrations, dbMap, err := mm.planMigrations(migrations, dir, limit)
+	if err != nil {
+		return report, err
+	}
+	if dir == migrate.Down {
+		for _, plannedMig := range plannedMigrations {
+			if irreversible, ok := migrationsByID[plannedMig.Id].(Irreversible); ok && irreversible.Irreversible() {
+				return report, fmt.Errorf("%w: %s", ErrIrreversibleMigration, plannedMig.Id)
+			}
+		}
+		if mm.opts.VerifyDownChecksums {
+			if err = verifyChecksums(dbMap, plannedMigrations, migrationsByID); err != nil {
+				return report, err
+			}
+		}
+	}
+	direction := migrationsDirection(dir)
+	report.Migrations = make([]MigrationReport, 0, len(plannedMigrations))
+	mm.reportProgress(ProgressEvent{Type: ProgressEventTypeStart, Direction: direction, Total: len(plannedMigrations)})
+	for i, plannedMig := range plannedMigrations {
+		if err = ctx.Err(); err != nil {
+			return report, fmt.Errorf("db migration %s is not run: %w", plannedMig.Id, err)
+		}
+		m := migrationsByID[plannedMig.Id]
+		var checksum string
+		if mm.opts.VerifyDownChecksums && dir == migrate.Up {
+			checksum = MigrationChecksum(m)
+		}
+		skipped, condErr := mm.isSkippedByCondition(ctx, m)
+		if condErr != nil {
+			return report, fmt.Errorf("check condition of db migration %s: %w", plannedMig.Id, condErr)
+		}
+		if skipped {
+			mm.logger.Info(fmt.Sprintf("db migration %s is skipped by its condition", plannedMig.Id))
+			plannedMig.Queries = nil
+			m = nil // Data is not loaded either.
+		}
+		plannedMig.Queries = mm.prepareStatements(plannedMig.Queries)
+		if !mm.opts.DisableMixedDDLWarning && mm.Dialect == dbkit.DialectMySQL &&
+			!plannedMig.DisableTransaction && mixesDDLAndDML(plannedMig.Queries) {
+			mm.logger.Warn(fmt.Sprintf("db migration %s mixes DDL and DML statements, "+
+				"DDL causes an implicit commit in MySQL, so the migration is not atomic, consider splitting it", plannedMig.Id))
+		}
+		migStartedAt := time.Now()
+		var onStatementDone func(stmtIndex, stmtTotal int)
+		if mm.opts.StatementProgress {
+			migIndex, migID := i+1, plannedMig.Id
+			onStatementDone = func(stmtIndex, stmtTotal int) {
+				mm.reportStatementProgress(ProgressEvent{Type: ProgressEventTypeStatement, Direction: direction,
+					MigrationID: migID, Index: migIndex, Total: len(plannedMigrations),
+					StatementIndex: stmtIndex, StatementTotal: stmtTotal, Elapsed: time.Since(migStartedAt)})
+			}
+		}
+		var rowsAffected []int64
+		rowsAffected, err = mm.applyPlannedMigration(ctx, plannedMig, m, dir, dbMap, checksum, onStatementDone)
+		migDuration := time.Since(migStartedAt)
+		mm.writeEvent(plannedMig.Id, direction, migDuration, err)
+		if err != nil {
+			return report, &migrate.TxError{Migration: plannedMig.Migration, Err: err}
+		}
+		if mm.opts.Metrics != nil {
+			mm.opts.Metrics.ObserveMigration(direction, migDuration)
+		}
+		report.Migrations = append(report.Migrations,
+			MigrationReport{ID: plannedMig.Id, Duration: migDuration, RowsAffected: rowsAffected, Skipped: skipped})
+		report.Applied++
+		mm.reportProgress(ProgressEvent{Type: ProgressEventTypeApplied, Direction: direction,
+			MigrationID: plannedMig.Id, Index: i + 1, Total: len(plannedMigrations)})
+	}
+	mm.reportProgress(ProgressEvent{Type: ProgressEventTypeFinished, Direction: direction,
+		Index: report.Applied, Total: len(plannedMigrations)})
+	return report, nil
+}
+
+// isSkippedByCondition checks if the migration implements Conditional interface and declines to run.
+func (mm *MigrationsManager) isSkippedByCondition(ctx context.Context, m Migration) (bool, error) {
+	conditional, ok := m.(Conditional)
+	if !ok {
+		return false, nil
+	}
+	shouldRun, err := conditional.ShouldRun(ctx, mm.db, mm.Dialect)
+	if err != nil {
+		return false, err
+	}
+	return !shouldRun, nil
+}
+
+// planMigrations decides which of the passed (already sorted) migrations should be applied.
+// Rules are the same as in sql-migrate's MigrationSet.PlanMigration:
+//   - all applied migrations must be among the passed ones;
+//   - for the up direction, not applied migrations that precede the last applied one are caught up first,
+//     then the migrations that follow the last applied one are applied (at most `limit` of them);
+//   - for the down direction, applied migrations are rolled back in the reverse order (at most `limit` of them).
+func (mm *MigrationsManager) planMigrations(
+	migrations []*migrate.Migration, dir migrate.MigrationDirection, limit int,
+) ([]*migrate.PlannedMigration, *gorp.DbMap, error) {
+	dbMap, err := mm.migrationsDBMap()
+	if err != nil {
+		return nil, nil, err
+	}
+	appliedIDs, err := mm.appliedMigrationIDs(dbMap, migrations)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indexes := make(map[string]int, len(migrations))
+	for i, m := range migrations {
+		indexes[m.Id] = i
+	}
+	applied := make(map[string]struct{}, len(appliedIDs))
+	lastAppliedIdx := -1
+	for _, id := range appliedIDs {
+		idx, ok := indexes[id]
+		if !ok {
+			return nil, nil, &migrate.PlanError{Migration: &migrate.Migration{Id: id}, ErrorMessage: "unknown migration in database"}
+		}
+		applied[id] = struct{}{}
+		if idx > lastAppliedIdx {
+			lastAppliedIdx = idx
+		}
+	}
+
+	var result []*migrate.PlannedMigration
+	switch dir {
+	case migrate.Up:
+		for _, m := range migrations[:lastAppliedIdx+1] {
+			if _, ok := applied[m.Id]; !ok {
+				result = append(result, &migrate.PlannedMigration{Migration: m, Queries: m.Up, DisableTransaction: m.DisableTransactionUp})
+			}
+		}
+		toApply := migrations[lastAppliedIdx+1:]
+		if limit > 0 && limit < len(toApply) {
+			toApply = toApply[:limit]
+		}
+		for _, m := range toApply {
+			result = append(result, &migrate.PlannedMigration{Migration: m, Queries: m.Up, DisableTransaction: m.DisableTransactionUp})
+		}
+	case migrate.Down:
+		for i := lastAppliedIdx; i >= 0 && (limit <= 0 || len(result) < limit); i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Id]; ok {
+				result = append(result, &migrate.PlannedMigration{Migration: m, Queries: m.Down, DisableTransaction: m.DisableTransactionDown})
+			}
+		}
+	}
+	return result, dbMap, nil
+}
+
+// appliedMigrationsQueryBatchSize is a max number of IDs in a single query of applied migrations
+// (see MigrationsManagerOpts.FilterAppliedMigrations).
+const appliedMigrationsQueryBatchSize = 1000
+
+// appliedMigrationIDs returns IDs of applied migrations.
+// If MigrationsManagerOpts.FilterAppliedMigrations is set, only IDs of the passed migrations are queried.
+func (mm *MigrationsManager) appliedMigrationIDs(dbMap *gorp.DbMap, migrations []*migrate.Migration) ([]string, error) {
+	if !mm.opts.FilterAppliedMigrations {
+		records, err := mm.getAppliedMigrations(dbMap)
+		if err != nil {
+			return nil, err
+		}
+		ids := make([]string, 0, len(records))
+		for _, rec := range records {
+			ids = append(ids, rec.ID)
+		}
+		return ids, nil
+	}
+
+	if err := mm.ensureMigrationsTable(dbMap); err != nil {
+		return nil, err
+	}
+	table := goqu.T(mm.migSet.TableName)
+	if mm.migSet.SchemaName != "" {
+		table = table.Schema(mm.migSet.SchemaName)
+	}
+	var ids []string
+	for start := 0; start < len(migrations); start += appliedMigrationsQueryBatchSize {
+		end := start + appliedMigrationsQueryBatchSize
+		if end > len(migrations) {
+			end = len(migrations)
+		}
+		batchIDs := make([]interface{}, 0, end-start)
+		for _, m := range migrations[start:end] {
+			batchIDs = append(batchIDs, m.Id)
+		}
+		query, args, err := mm.goquDialect().From(table).Select("id").
+			Where(goqu.C("id").In(batchIDs...)).Prepared(!mm.opts.InterpolateQueries).ToSQL()
+		if err != nil {
+			return nil, fmt.Errorf("build query of applied migrations: %w", err)
+		}
+		var batchAppliedIDs []string
+		if _, err = dbMap.Select(&batchAppliedIDs, query, args...); err != nil {
+			return nil, fmt.Errorf("query applied migrations: %w", mm.checkTableSchema(dbMap, err))
+		}
+		ids = append(ids, batchAppliedIDs...)
+	}
+	return ids, nil
+}
+
+// goquDialect returns the goqu dialect for building queries to the migrations table
+// (see MigrationsManagerOpts.GoquDialect).
+func (mm *MigrationsManager) goquDialect() goqu.DialectWrapper {
+	if mm.opts.GoquDialect != "" {
+		return goqu.Dialect(mm.opts.GoquDialect)
+	}
+	return goqu.Dialect(goquDialectName(mm.Dialect))
+}
+
+// goquDialectName returns the name of the goqu dialect for building queries in the passed SQL dialect.
+func goquDialectName(dialect dbkit.Dialect) string {
+	switch dialect {
+	case dbkit.DialectPgx:
+		return string(dbkit.DialectPostgres)
+	case dbkit.DialectMSSQL:
+		return "sqlserver"
+	default:
+		return string(dialect)
+	}
+}
+
+// checkDatabaseName checks that the connected database has the expected name (if it's specified).
+func (mm *MigrationsManager) checkDatabaseName() error {
+	if mm.opts.ExpectedDatabaseName == "" {
+		return nil
+	}
+	var query string
+	switch mm.Dialect {
+	case dbkit.DialectMySQL:
+		query = "SELECT DATABASE()"
+	case dbkit.DialectPostgres, dbkit.DialectPgx:
+		query = "SELECT current_database()"
+	case dbkit.DialectMSSQL:
+		query = "SELECT DB_NAME()"
+	case dbkit.DialectSQLite:
+		query = "SELECT file FROM pragma_database_list WHERE name = 'main'"
+	default:
+		return fmt.Errorf("unknown dialect %q", mm.Dialect)
+	}
+	var dbName sql.NullString
+	if err := mm.db.QueryRow(query).Scan(&dbName); err != nil {
+		return fmt.Errorf("get current database name: %w", err)
+	}
+	name := dbName.String
+	if mm.Dialect == dbkit.DialectSQLite {
+		if name == "" {
+			// In-memory (and temporary) database has no file, so there is no name to check.
+			mm.logger.Warn("expected database name is not checked for in-memory SQLite database")
+			return nil
+		}
+		name = filepath.Base(name)
+	}
+	if name != mm.opts.ExpectedDatabaseName {
+		return fmt.Errorf("connected to database %q, but migrations are expected to be run on %q",
+			name, mm.opts.ExpectedDatabaseName)
+	}
+	return nil
+}
+
+// prepareStatements returns statements that should be executed for the migration
+// (rewritten by StatementRewriter, guarded if ExistenceGuards is enabled and joined if ExecMultiStatement is enabled).
+// Passed slice is not modified since it belongs to the migration.
+func (mm *MigrationsManager) prepareStatements(statements []string) []string {
+	if mm.opts.StatementRewriter != nil || mm.opts.ExistenceGuards {
+		rewritten := make([]string, 0, len(statements))
+		for _, stmt := range statements {
+			if mm.opts.StatementRewriter != nil {
+				stmt = mm.opts.StatementRewriter(mm.Dialect, stmt)
+			}
+			if mm.opts.ExistenceGuards {
+				stmt = AddExistenceGuards(mm.Dialect, stmt)
+			}
+			rewritten = append(rewritten, stmt)
+		}
+		statements = rewritten
+	}
+	if mm.opts.ExecMultiStatement && len(statements) > 1 {
+		statements = []string{joinStatements(statements)}
+	}
+	return statements
+}
+
+// trimStatement removes the trailing semicolon the same way as sql-migrate does.
+func trimStatement(stmt string) string {
+	stmt = strings.TrimSuffix(stmt, "\n")
+	stmt = strings.TrimSuffix(stmt, " ")
+	return strings.TrimSuffix(stmt, ";")
+}
+
+// statementMetricAnnotation is a prefix of the comment that annotates migration statement for metrics.
+const statementMetricAnnotation = "-- @metric:"
+
+// statementMetricName returns the name from the "-- @metric: <name>" comment in the leading comments of the statement.
+// Empty string is returned if the statement isn't annotated.
+func statementMetricName(stmt string) string {
+	for _, line := range strings.Split(stmt, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "--") {
+			return ""
+		}
+		if strings.HasPrefix(line, statementMetricAnnotation) {
+			return strings.TrimSpace(strings.TrimPrefix(line, statementMetricAnnotation))
+		}
+	}
+	return ""
+}
+
+// migrationsDirection converts sql-migrate's direction to MigrationsDirection.
+func migrationsDirection(dir migrate.MigrationDirection) MigrationsDirection {
+	if dir == migrate.Down {
+		return MigrationsDirectionDown
+	}
+	return MigrationsDirectionUp
+}
+
+var (
+	ddlKeywords = []string{"CREATE", "ALTER", "DROP", "RENAME", "TRUNCATE"}
+	dmlKeywords = []string{"INSERT", "UPDATE", "DELETE", "REPLACE"}
+)
+
+// mixesDDLAndDML checks if statements contain both DDL and DML (multi-statement scripts are not split).
+func mixesDDLAndDML(statements []string) bool {
+	var hasDDL, hasDML bool
+	for _, stmt := range statements {
+		keyword := firstKeyword(stmt)
+		hasDDL = hasDDL || containsString(ddlKeywords, keyword)
+		hasDML = hasDML || containsString(dmlKeywords, keyword)
+	}
+	return hasDDL && hasDML
+}
+
+// firstKeyword returns the first word of the statement in upper case skipping leading comments.
+func firstKeyword(stmt string) string {
+	for {
+		stmt = strings.TrimSpace(stmt)
+		switch {
+		case strings.HasPrefix(stmt, "--"), strings.HasPrefix(stmt, "#"):
+			idx := strings.IndexByte(stmt, '\n')
+			if idx == -1 {
+				return ""
+			}
+			stmt = stmt[idx+1:]
+		case strings.HasPrefix(stmt, "/*"):
+			idx := strings.Index(stmt, "*/")
+			if idx == -1 {
+				return ""
+			}
+			stmt = stmt[idx+2:]
+		default:
+			end := strings.IndexFunc(stmt, func(r rune) bool {
+				return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+			})
+			if end == -1 {
+				end = len(stmt)
+			}
+			return strings.ToUpper(stmt[:end])
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// joinStatements joins SQL statements into a single multi-statement script.
+func joinStatements(statements []string) string {
+	var sb strings.Builder
+	for _, stmt := range statements {
+		stmt = strings.TrimSpace(stmt)
+		if stmt == "" {
+			continue
+		}
+		sb.WriteString(strings.TrimSuffix(stmt, ";"))
+		sb.WriteString(";\n")
+	}
+	return sb.String()
+}
+
+// migrationsDBMap creates gorp.DbMap for working with the table that stores applied migrations.
+func (mm *MigrationsManager) migrationsDBMap() (*gorp.DbMap, error) {
+	gorpDialect, ok := migrate.MigrationDialects[string(mm.Dialect)]
+	if !ok {
+		return nil, fmt.Errorf("unknown dialect %q", mm.Dialect)
+	}
+	dbMap := &gorp.DbMap{Db: mm.db, Dialect: gorpDialect}
+	tableMap := dbMap.AddTableWithNameAndSchema(migrate.MigrationRecord{}, mm.gorpSchemaName(dbMap), mm.migSet.TableName)
+	tableMap.SetKeys(false, "Id")
+	if mm.opts.IDColumnLength > 0 {
+		tableMap.ColMap("Id").SetMaxSize(mm.opts.IDColumnLength)
+	}
+	if mm.opts.VerifyDownChecksums {
+		mm.addChecksumsTable(dbMap)
+	}
+	return dbMap, nil
+}
+
+// gorpSchemaName returns the schema name of the migrations table for gorp.
+// gorp quotes the table name, but not the schema name, so the latter is quoted for Postgres,
+// where unquoted identifiers are folded to lowercase. Otherwise, a mixed-case schema would be created by gorp
+// in lowercase, while goqu queries and the catalog lookup use the name as is.
+func (mm *MigrationsManager) gorpSchemaName(dbMap *gorp.DbMap) string {
+	if mm.migSet.SchemaName == "" || mm.Dialect != dbkit.DialectPostgres {
+		return mm.migSet.SchemaName
+	}
+	return dbMap.Dialect.QuoteField(mm.migSet.SchemaName)
+}
+
+// quotedMigrationsTable returns the quoted name of the migrations table (qualified with schema if it's set).
+func (mm *MigrationsManager) quotedMigrationsTable(dbMap *gorp.DbMap) string {
+	return dbMap.Dialect.QuotedTableForQuery(mm.gorpSchemaName(dbMap), mm.migSet.TableName)
+}
+
+// ensureMigrationsTable creates the migrations table if it doesn't exist
+// or checks that it exists if MigrationsManagerOpts.DisableTableCreation is set.
+func (mm *MigrationsManager) ensureMigrationsTable(dbMap *gorp.DbMap) error {
+	if !mm.opts.DisableTableCreation {
+		if err := dbMap.CreateTablesIfNotExists(); err != nil {
+			return fmt.Errorf("create migrations table: %w", err)
+		}
+		return nil
+	}
+	exists, err := mm.migrationsTableExists(dbMap)
+	if err != nil {
+		return fmt.Errorf("check migrations table existence: %w", err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrMigrationsTableNotExist,
+			mm.quotedMigrationsTable(dbMap))
+	}
+	return nil
+}
+
+// migrationsTableExists checks whether the migrations table exists by querying the database catalog.
+func (mm *MigrationsManager) migrationsTableExists(dbMap *gorp.DbMap) (bool, error) {
+	var query string
+	args := []interface{}{mm.migSet.TableName}
+	switch normalizeDialect(mm.Dialect) {
+	case dbkit.DialectSQLite:
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = " + dbMap.Dialect.BindVar(0)
+	case dbkit.DialectPostgres:
+		query = fmt.Sprintf("SELECT COUNT(*) FROM information_schema.tables "+
+			"WHERE table_name = %s AND table_schema = COALESCE(NULLIF(%s, ''), CURRENT_SCHEMA())",
+			dbMap.Dialect.BindVar(0), dbMap.Dialect.BindVar(1))
+		args = append(args, mm.migSet.SchemaName)
+	case dbkit.DialectMySQL:
+		query = fmt.Sprintf("SELECT COUNT(*) FROM information_schema.tables "+
+			"WHERE table_name = %s AND table_schema = COALESCE(NULLIF(%s, ''), DATABASE())",
+			dbMap.Dialect.BindVar(0), dbMap.Dialect.BindVar(1))
+		args = append(args, mm.migSet.SchemaName)
+	case dbkit.DialectMSSQL:
+		query = fmt.Sprintf("SELECT COUNT(*) FROM INFORMATION_SCHEMA.TABLES "+
+			"WHERE TABLE_NAME = %s AND TABLE_SCHEMA = COALESCE(NULLIF(%s, ''), SCHEMA_NAME())",
+			dbMap.Dialect.BindVar(0), dbMap.Dialect.BindVar(1))
+		args = append(args, mm.migSet.SchemaName)
+	default:
+		return false, fmt.Errorf("unsupported dialect %q", mm.Dialect)
+	}
+	var count int
+	if err := mm.db.QueryRow(query, args...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// getAppliedMigrations returns all applied migrations sorted by ID (the migrations table is created if it doesn't exist,
+// see MigrationsManagerOpts.DisableTableCreation).
+// Unlike sql-migrate's MigrationSet.GetMigrationRecords, NULL applied_at (e.g. in the table populated by another tool)
+// is not an error, zero time is returned for such migrations.
+func (mm *MigrationsManager) getAppliedMigrations(dbMap *gorp.DbMap) ([]AppliedMigration, error) {
+	if err := mm.ensureMigrationsTable(dbMap); err != nil {
+		return nil, err
+	}
+	rows, err := mm.db.Query(fmt.Sprintf("SELECT id, applied_at FROM %s ORDER BY id ASC",
+		mm.quotedMigrationsTable(dbMap)))
+	if err != nil {
+		return nil, mm.checkTableSchema(dbMap, err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var appliedMigs []AppliedMigration
+	for rows.Next() {
+		var appliedMig AppliedMigration
+		var appliedAt sql.NullTime
+		if err = rows.Scan(&appliedMig.ID, &appliedAt); err != nil {
+			return nil, err
+		}
+		if appliedAt.Valid {
+			appliedMig.AppliedAt = appliedAt.Time
+		}
+		appliedMigs = append(appliedMigs, appliedMig)
+	}
+	return appliedMigs, rows.Err()
+}
+
+// checkTableSchema is called when the query to the migrations table fails.
+// Since the table is created only if it doesn't exist, it may have the schema of another tool (or be created manually).
+// Error wrapping ErrIncompatibleMigrationsTable and listing missing columns is returned in this case,
+// otherwise the passed error is returned as is.
+func (mm *MigrationsManager) checkTableSchema(dbMap *gorp.DbMap, queryErr error) error {
+	rows, err := mm.db.Query(fmt.Sprintf("SELECT * FROM %s WHERE 1 = 0",
+		mm.quotedMigrationsTable(dbMap)))
+	if err != nil {
+		return queryErr
+	}
+	defer func() { _ = rows.Close() }()
+	columns, err := rows.Columns()
+	if err != nil {
+		return queryErr
+	}
+	existingColumns := make(map[string]struct{}, len(columns))
+	for _, col := range columns {
+		existingColumns[strings.ToLower(col)] = struct{}{}
+	}
+	var missingColumns []string
+	for _, col := range []string{"id", "applied_at"} {
+		if _, ok := existingColumns[col]; !ok {
+			missingColumns = append(missingColumns, col)
+		}
+	}
+	if len(missingColumns) == 0 {
+		return queryErr
+	}
+	return fmt.Errorf("%w: table %s has columns %s, but %s are missing "+
+		"(it's likely created by another tool, use another table name, see MigrationsManagerOpts.TableName): %w",
+		ErrIncompatibleMigrationsTable, mm.migSet.TableName, strings.Join(columns, ", "),
+		strings.Join(missingColumns, ", "), queryErr)
+}
+
+// applyPlannedMigration executes queries of the planned migration and records (or removes) it in the migrations table.
+// Behavior is the same as in sql-migrate's MigrationSet.ExecMax.
+// The number of rows affected by each statement is returned.
+// If checksum is not empty, it's stored along with the migration record (see MigrationsManagerOpts.VerifyDownChecksums).
+// If onStatementDone is not nil, it's called after each executed statement (including ignored failures) and data loading.
+// The context is checked before each statement, so the migration is rolled back (if it's run in transaction)
+// when the context is done.
+func (mm *MigrationsManager) applyPlannedMigration(
+	ctx context.Context, plannedMig *migrate.PlannedMigration, m Migration, dir migrate.MigrationDirection, dbMap *gorp.DbMap,
+	checksum string, onStatementDone func(stmtIndex, stmtTotal int),
+) (rowsAffected []int64, err error) {
+	var executor gorp.SqlExecutor = dbMap
+	if !plannedMig.DisableTransaction {
+		var tx *gorp.Transaction
+		if tx, err = dbMap.Begin(); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				_ = tx.Rollback()
+				return
+			}
+			err = tx.Commit()
+		}()
+		executor = tx
+	}
+
+	dataLoader, hasData := m.(DataLoader)
+	hasData = hasData && dir == migrate.Up
+	stmtTotal := len(plannedMig.Queries)
+	if hasData {
+		stmtTotal++
+	}
+	if onStatementDone == nil {
+		onStatementDone = func(int, int) {}
+	}
+
+	rowsAffected = make([]int64, 0, len(plannedMig.Queries))
+	for i, stmt := range plannedMig.Queries {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		var res sql.Result
+		stmtStartedAt := time.Now()
+		if res, err = executor.Exec(trimStatement(stmt)); err != nil {
+			if dir == migrate.Up && mm.isIgnorableError(err) {
+				mm.logger.Warn(fmt.Sprintf("db migration %s statement error is ignored", plannedMig.Id), log.Error(err))
+				err = nil
+				rowsAffected = append(rowsAffected, 0)
+				onStatementDone(i+1, stmtTotal)
+				continue
+			}
+			return nil, err
+		}
+		// RowsAffected is meaningless for DDL statements and may be not supported by the driver, 0 is used in this case.
+		affected, affectedErr := res.RowsAffected()
+		if affectedErr != nil {
+			affected = 0
+		}
+		rowsAffected = append(rowsAffected, affected)
+		if mm.opts.Metrics != nil {
+			if metricName := statementMetricName(stmt); metricName != "" {
+				mm.opts.Metrics.ObserveStatement(migrationsDirection(dir), metricName, time.Since(stmtStartedAt))
+			}
+		}
+		mm.logger.Debug(fmt.Sprintf("db migration %s statement #%d affected %d rows", plannedMig.Id, i+1, affected))
+		onStatementDone(i+1, stmtTotal)
+	}
+
+	if hasData {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		var loaded int64
+		if loaded, err = mm.loadData(dataLoader.Data(), executor, dbMap); err != nil {
+			return nil, err
+		}
+		rowsAffected = append(rowsAffected, loaded)
+		mm.logger.Debug(fmt.Sprintf("db migration %s loaded %d rows", plannedMig.Id, loaded))
+		onStatementDone(stmtTotal, stmtTotal)
+	}
+
+	if dir == migrate.Up {
+		// UTC is used, so applied_at values are consistent across services in different time zones.
+		if err = executor.Insert(&migrate.MigrationRecord{Id: plannedMig.Id, AppliedAt: time.Now().UTC()}); err != nil {
+			return nil, err
+		}
+		if checksum != "" {
+			if err = storeChecksum(executor, plannedMig.Id, checksum); err != nil {
+				return nil, fmt.Errorf("store checksum: %w", err)
+			}
+		}
+		return rowsAffected, nil
+	}
+	if _, err = executor.Delete(&migrate.MigrationRecord{Id: plannedMig.Id}); err != nil {
+		return nil, err
+	}
+	if mm.opts.VerifyDownChecksums {
+		if _, err = executor.Delete(&checksumRecord{ID: plannedMig.Id}); err != nil {
+			return nil, fmt.Errorf("delete checksum: %w", err)
+		}
+	}
+	return rowsAffected, nil
+}
+
+// isIgnorableError checks if the error code is in the list of the error codes that should be ignored.
+func (mm *MigrationsManager) isIgnorableError(err error) bool {
+	if len(mm.opts.IgnoreErrorCodes) == 0 {
+		return false
+	}
+	code, ok := errorCode(err)
+	if !ok {
+		return false
+	}
+	for _, ignoredCode := range mm.opts.IgnoreErrorCodes {
+		if strings.EqualFold(ignoredCode, code) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorCode extracts dialect-specific code from the error.
+// SQLSTATE is returned for Postgres (both lib/pq and pgx), error number is returned for MySQL and MSSQL.
+func errorCode(err error) (string, bool) {
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		return pgErr.SQLState(), true
+	}
+	var mySQLErr *mysql.MySQLError
+	if errors.As(err, &mySQLErr) {
+		return strconv.Itoa(int(mySQLErr.Number)), true
+	}
+	var msSQLErr interface{ SQLErrorNumber() int32 }
+	if errors.As(err, &msSQLErr) {
+		return strconv.Itoa(int(msSQLErr.SQLErrorNumber())), true
+	}
+	return "", false
+}
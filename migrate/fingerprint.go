@@ -0,0 +1,63 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"sort"
+)
+
+// Fingerprint returns a stable hex-encoded SHA-256 hash of the whole set of migrations.
+// The hash covers IDs and up/down SQL statements of all migrations, the order of passed migrations doesn't matter.
+// The expected fingerprint may be embedded at build time and compared at startup
+// for detecting drift between the binary's expectations and the loaded migrations.
+func Fingerprint(migrations []Migration) string {
+	sorted := make([]Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ID() < sorted[j].ID()
+	})
+
+	h := sha256.New()
+	for _, m := range sorted {
+		writeFingerprintPart(h, m.ID())
+		writeFingerprintStatements(h, m.UpSQL())
+		writeFingerprintStatements(h, m.DownSQL())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// MigrationChecksum returns a stable hex-encoded SHA-256 hash of the migration's ID and up SQL statements.
+// It's stored for each applied migration and verified before rolling it back (see MigrationsManagerOpts.VerifyDownChecksums).
+func MigrationChecksum(m Migration) string {
+	h := sha256.New()
+	writeFingerprintPart(h, m.ID())
+	writeFingerprintStatements(h, m.UpSQL())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func writeFingerprintStatements(h hash.Hash, statements []string) {
+	writeFingerprintLen(h, len(statements))
+	for _, stmt := range statements {
+		writeFingerprintPart(h, stmt)
+	}
+}
+
+// writeFingerprintPart writes length-prefixed string, so different sets of parts never produce the same input.
+func writeFingerprintPart(h hash.Hash, s string) {
+	writeFingerprintLen(h, len(s))
+	_, _ = h.Write([]byte(s)) // hash.Hash.Write never returns an error.
+}
+
+func writeFingerprintLen(h hash.Hash, n int) {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(n))
+	_, _ = h.Write(buf[:])
+}
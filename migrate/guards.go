@@ -0,0 +1,115 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/acronis/go-dbkit"
+)
+
+type existenceGuard struct {
+	re       *regexp.Regexp // Matches the statement part after which the guard is inserted.
+	guard    string
+	all      bool // Guard is inserted after each match, not only at the beginning of the statement.
+	dialects []dbkit.Dialect
+}
+
+var existenceGuards = []existenceGuard{
+	{
+		re:       regexp.MustCompile(`(?i)^\s*CREATE\s+(?:(?:TEMPORARY|TEMP|UNLOGGED)\s+)?TABLE\s+`),
+		guard:    "IF NOT EXISTS ",
+		dialects: []dbkit.Dialect{dbkit.DialectPostgres, dbkit.DialectMySQL, dbkit.DialectSQLite},
+	},
+	{
+		re:       regexp.MustCompile(`(?i)^\s*CREATE\s+(?:UNIQUE\s+)?INDEX\s+(?:CONCURRENTLY\s+)?`),
+		guard:    "IF NOT EXISTS ",
+		dialects: []dbkit.Dialect{dbkit.DialectPostgres, dbkit.DialectSQLite},
+	},
+	{
+		re:       regexp.MustCompile(`(?i)^\s*DROP\s+TABLE\s+`),
+		guard:    "IF EXISTS ",
+		dialects: []dbkit.Dialect{dbkit.DialectPostgres, dbkit.DialectMySQL, dbkit.DialectSQLite},
+	},
+	{
+		re:       regexp.MustCompile(`(?i)^\s*DROP\s+INDEX\s+(?:CONCURRENTLY\s+)?`),
+		guard:    "IF EXISTS ",
+		dialects: []dbkit.Dialect{dbkit.DialectPostgres, dbkit.DialectSQLite},
+	},
+	{
+		re:       regexp.MustCompile(`(?i)\bADD\s+COLUMN\s+`),
+		guard:    "IF NOT EXISTS ",
+		all:      true,
+		dialects: []dbkit.Dialect{dbkit.DialectPostgres},
+	},
+	{
+		re:       regexp.MustCompile(`(?i)\bDROP\s+COLUMN\s+`),
+		guard:    "IF EXISTS ",
+		all:      true,
+		dialects: []dbkit.Dialect{dbkit.DialectPostgres},
+	},
+}
+
+// guardedStatementStartRe matches the beginning of the statement part that already has the guard,
+// or that can't have it (unnamed Postgres index).
+var guardedStatementStartRe = regexp.MustCompile(`(?i)^(?:IF\s|ON\s)`)
+
+// AddExistenceGuards adds existence guards ("IF NOT EXISTS" and "IF EXISTS") to the DDL statement where the dialect
+// supports them, so re-running a partially applied migration (e.g. with disabled transaction) after a crash
+// doesn't fail on already created (or dropped) objects:
+//   - Postgres: CREATE TABLE, CREATE INDEX, DROP TABLE, DROP INDEX, ALTER TABLE ... ADD COLUMN / DROP COLUMN;
+//   - SQLite: CREATE TABLE, CREATE INDEX, DROP TABLE, DROP INDEX;
+//   - MySQL: CREATE TABLE, DROP TABLE;
+//   - MSSQL: nothing.
+//
+// It's a best-effort textual transformation, not a guarantee: CREATE and DROP statements that start with comments
+// are not changed (while leading comments are skipped for ALTER TABLE statements, so their columns are guarded),
+// and the existing object is not checked to match the definition.
+// It matches the MigrationsManagerOpts.StatementRewriter signature (see also MigrationsManagerOpts.ExistenceGuards).
+func AddExistenceGuards(dialect dbkit.Dialect, stmt string) string {
+	dialect = normalizeDialect(dialect)
+	for _, g := range existenceGuards {
+		if !containsDialect(g.dialects, dialect) {
+			continue
+		}
+		if g.all {
+			stmt = insertGuardAfterEachMatch(stmt, g.re, g.guard)
+			continue
+		}
+		if loc := g.re.FindStringIndex(stmt); loc != nil && !guardedStatementStartRe.MatchString(stmt[loc[1]:]) {
+			return stmt[:loc[1]] + g.guard + stmt[loc[1]:]
+		}
+	}
+	return stmt
+}
+
+func insertGuardAfterEachMatch(stmt string, re *regexp.Regexp, guard string) string {
+	if firstKeyword(stmt) != "ALTER" {
+		return stmt
+	}
+	var sb strings.Builder
+	prevEnd := 0
+	for _, loc := range re.FindAllStringIndex(stmt, -1) {
+		sb.WriteString(stmt[prevEnd:loc[1]])
+		if !guardedStatementStartRe.MatchString(stmt[loc[1]:]) {
+			sb.WriteString(guard)
+		}
+		prevEnd = loc[1]
+	}
+	sb.WriteString(stmt[prevEnd:])
+	return sb.String()
+}
+
+func containsDialect(dialects []dbkit.Dialect, dialect dbkit.Dialect) bool {
+	for _, d := range dialects {
+		if d == dialect {
+			return true
+		}
+	}
+	return false
+}
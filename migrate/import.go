@@ -0,0 +1,185 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/doug-martin/goqu/v9"
+	"github.com/go-gorp/gorp/v3"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// migrationRecordsInsertBatchSize is a max number of records in a single multi-row INSERT statement
+// into the migrations table (each record takes 2 arguments, see dataInsertMaxArgs).
+const migrationRecordsInsertBatchSize = dataInsertMaxArgs / 2
+
+// ImportLegacyState copies records about applied migrations from the legacy table into the table
+// that is used by MigrationsManager (see MigrationsManagerOpts.TableName).
+// Legacy table should have the sql-migrate format (id and applied_at columns), e.g. "gorp_migrations" table
+// that is created by sql-migrate by default. Already recorded migrations are skipped, so the call is idempotent.
+// It allows switching a service to MigrationsManager without re-running all historical migrations.
+// The number of imported records is returned.
+func (mm *MigrationsManager) ImportLegacyState(legacyTableName string) (int, error) {
+	if legacyTableName == "" {
+		return 0, fmt.Errorf("legacy table name should not be empty")
+	}
+	if legacyTableName == mm.migSet.TableName {
+		return 0, fmt.Errorf("legacy table should differ from the migrations table %q", legacyTableName)
+	}
+
+	dbMap, err := mm.migrationsDBMap()
+	if err != nil {
+		return 0, err
+	}
+	appliedMigs, err := mm.getAppliedMigrations(dbMap)
+	if err != nil {
+		return 0, err
+	}
+	recorded := make(map[string]struct{}, len(appliedMigs))
+	for _, appliedMig := range appliedMigs {
+		recorded[appliedMig.ID] = struct{}{}
+	}
+
+	legacyRecords, err := mm.selectLegacyMigrationRecords(dbMap, legacyTableName)
+	if err != nil {
+		return 0, fmt.Errorf("select legacy migration records: %w", err)
+	}
+	var newRecords []migrationRecord
+	for _, legacyRec := range legacyRecords {
+		if _, ok := recorded[legacyRec.id]; !ok {
+			newRecords = append(newRecords, legacyRec)
+		}
+	}
+	return mm.recordMigrations(dbMap, newRecords)
+}
+
+// MarkApplied records the passed migrations as applied without executing them (e.g. when onboarding a database
+// whose schema was created by another tool, so historical migrations must not be re-run).
+// Records are inserted with multi-row INSERT statements in a single transaction, so marking hundreds of migrations
+// takes a few round trips. Already recorded migrations are skipped (their applied_at is not changed),
+// so the call is idempotent. The number of newly recorded migrations is returned.
+func (mm *MigrationsManager) MarkApplied(migrations []Migration) (int, error) {
+	for i, m := range migrations {
+		if m.ID() == "" {
+			return 0, fmt.Errorf("migration #%d has empty ID", i+1)
+		}
+	}
+
+	dbMap, err := mm.migrationsDBMap()
+	if err != nil {
+		return 0, err
+	}
+	appliedMigs, err := mm.getAppliedMigrations(dbMap)
+	if err != nil {
+		return 0, err
+	}
+	recorded := make(map[string]struct{}, len(appliedMigs)+len(migrations))
+	for _, appliedMig := range appliedMigs {
+		recorded[appliedMig.ID] = struct{}{}
+	}
+
+	// UTC is used, so applied_at values are consistent with the ones recorded by Run.
+	appliedAt := sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	var newRecords []migrationRecord
+	for _, m := range migrations {
+		if _, ok := recorded[m.ID()]; ok {
+			continue
+		}
+		recorded[m.ID()] = struct{}{}
+		newRecords = append(newRecords, migrationRecord{id: m.ID(), appliedAt: appliedAt})
+	}
+	return mm.recordMigrations(dbMap, newRecords)
+}
+
+// migrationRecord is a raw record of the migrations table (NULL applied_at is kept as is, unlike gorp's inserting).
+type migrationRecord struct {
+	id        string
+	appliedAt sql.NullTime
+}
+
+// recordMigrations inserts the passed records into the migrations table in a single transaction
+// and returns the number of inserted ones. Records are expected to be filtered by already applied migrations,
+// but conflicting ones (e.g. recorded concurrently) are skipped too where the dialect supports it (all except MSSQL).
+func (mm *MigrationsManager) recordMigrations(dbMap *gorp.DbMap, records []migrationRecord) (int, error) {
+	if len(records) == 0 {
+		return 0, nil
+	}
+	table := goqu.T(mm.migSet.TableName)
+	if mm.migSet.SchemaName != "" {
+		table = table.Schema(mm.migSet.SchemaName)
+	}
+
+	tx, err := dbMap.Begin()
+	if err != nil {
+		return 0, err
+	}
+	inserted := 0
+	for start := 0; start < len(records); start += migrationRecordsInsertBatchSize {
+		batch := records[start:min(start+migrationRecordsInsertBatchSize, len(records))]
+		rows := make([]interface{}, 0, len(batch))
+		for _, rec := range batch {
+			rows = append(rows, goqu.Record{"id": rec.id, "applied_at": rec.appliedAt})
+		}
+		ds := mm.goquDialect().Insert(table).Rows(rows...).Prepared(!mm.opts.InterpolateQueries)
+		switch mm.Dialect {
+		case dbkit.DialectMySQL:
+			// INSERT IGNORE would suppress other errors too (e.g. truncation of too long ID).
+			ds = ds.OnConflict(goqu.DoUpdate("id", goqu.Record{"id": goqu.L("id")}))
+		case dbkit.DialectMSSQL:
+		default:
+			ds = ds.OnConflict(goqu.DoNothing())
+		}
+		query, args, buildErr := ds.ToSQL()
+		if buildErr != nil {
+			_ = tx.Rollback()
+			return 0, fmt.Errorf("build query of inserting migration records: %w", buildErr)
+		}
+		result, execErr := tx.Exec(query, args...)
+		if execErr != nil {
+			_ = tx.Rollback()
+			return 0, fmt.Errorf("insert migration records #%d-#%d: %w", start+1, start+len(batch), execErr)
+		}
+		rowsAffected, rowsErr := result.RowsAffected()
+		if rowsErr != nil {
+			_ = tx.Rollback()
+			return 0, rowsErr
+		}
+		inserted += int(rowsAffected)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	if inserted != 0 {
+		mm.invalidateStatusCache()
+	}
+	return inserted, nil
+}
+
+func (mm *MigrationsManager) selectLegacyMigrationRecords(
+	dbMap *gorp.DbMap, legacyTableName string,
+) ([]migrationRecord, error) {
+	rows, err := mm.db.Query(fmt.Sprintf("SELECT id, applied_at FROM %s ORDER BY id ASC",
+		dbMap.Dialect.QuotedTableForQuery("", legacyTableName)))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var records []migrationRecord
+	for rows.Next() {
+		var rec migrationRecord
+		if err = rows.Scan(&rec.id, &rec.appliedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
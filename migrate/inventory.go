@@ -0,0 +1,127 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	migrate "github.com/rubenv/sql-migrate"
+)
+
+// ErrPendingMigrations is returned by MigrationsManager.AssertNoPendingMigrations
+// if some of the passed migrations are not applied.
+var ErrPendingMigrations = errors.New("pending db migrations")
+
+// MigrationInventoryStatus is a state of the migration in the inventory (see MigrationsManager.Inventory).
+type MigrationInventoryStatus string
+
+// Migration inventory statuses.
+const (
+	// MigrationInventoryStatusApplied means that the migration is known and applied.
+	MigrationInventoryStatusApplied MigrationInventoryStatus = "applied"
+	// MigrationInventoryStatusPending means that the migration is known, but not applied yet.
+	MigrationInventoryStatusPending MigrationInventoryStatus = "pending"
+	// MigrationInventoryStatusOrphaned means that the migration is applied, but it's unknown for the passed migrations
+	// (e.g. it was removed from the code or it's applied by a newer version of the service).
+	MigrationInventoryStatusOrphaned MigrationInventoryStatus = "orphaned"
+)
+
+// MigrationInventoryItem represents a single migration in the inventory.
+type MigrationInventoryItem struct {
+	ID        string
+	Status    MigrationInventoryStatus
+	Applied   bool      // True for applied and orphaned migrations.
+	AppliedAt time.Time // Zero for pending migrations and if it's not recorded (see AppliedMigration.AppliedAt).
+}
+
+// Inventory returns a consolidated view of the passed and applied migrations that may be used in an admin UI.
+// Passed migrations go first in the order in which they are applied by Run (see MigrationsManagerOpts.SortFunc),
+// they are followed by the orphaned ones (applied, but unknown).
+// Applied migrations are read with a single query, so the result is consistent.
+func (mm *MigrationsManager) Inventory(migrations []Migration) ([]MigrationInventoryItem, error) {
+	return mm.inventory(migrations, false)
+}
+
+// inventory builds the migration inventory. If readOnly is true, the migrations table is never created,
+// all migrations are pending if it doesn't exist.
+func (mm *MigrationsManager) inventory(migrations []Migration, readOnly bool) ([]MigrationInventoryItem, error) {
+	convertedMigrationList := make([]*migrate.Migration, 0, len(migrations))
+	for i, m := range migrations {
+		if m.ID() == "" {
+			return nil, fmt.Errorf("migration #%d has empty ID", i+1)
+		}
+		convertedMigration, err := convertMigration(m)
+		if err != nil {
+			return nil, err
+		}
+		convertedMigrationList = append(convertedMigrationList, convertedMigration)
+	}
+
+	dbMap, err := mm.migrationsDBMap()
+	if err != nil {
+		return nil, err
+	}
+	tableExists := true
+	if readOnly {
+		if tableExists, err = mm.migrationsTableExists(dbMap); err != nil {
+			return nil, fmt.Errorf("check migrations table existence: %w", err)
+		}
+	}
+	var appliedMigs []AppliedMigration
+	if tableExists {
+		if appliedMigs, err = mm.getAppliedMigrations(dbMap); err != nil {
+			return nil, fmt.Errorf("get applied migrations: %w", err)
+		}
+	}
+	appliedAt := make(map[string]time.Time, len(appliedMigs))
+	for _, appliedMig := range appliedMigs {
+		appliedAt[appliedMig.ID] = appliedMig.AppliedAt
+	}
+
+	items := make([]MigrationInventoryItem, 0, len(migrations)+len(appliedMigs))
+	known := make(map[string]struct{}, len(migrations))
+	for _, m := range mm.sortMigrations(migrations, convertedMigrationList) {
+		known[m.Id] = struct{}{}
+		item := MigrationInventoryItem{ID: m.Id, Status: MigrationInventoryStatusPending}
+		if at, ok := appliedAt[m.Id]; ok {
+			item.Status, item.Applied, item.AppliedAt = MigrationInventoryStatusApplied, true, at
+		}
+		items = append(items, item)
+	}
+	for _, appliedMig := range appliedMigs {
+		if _, ok := known[appliedMig.ID]; !ok {
+			items = append(items, MigrationInventoryItem{
+				ID: appliedMig.ID, Status: MigrationInventoryStatusOrphaned, Applied: true, AppliedAt: appliedMig.AppliedAt})
+		}
+	}
+	return items, nil
+}
+
+// AssertNoPendingMigrations checks that all passed migrations are applied, i.e. the schema matches the binary's expectations.
+// Error wrapping ErrPendingMigrations and listing IDs of not applied migrations is returned otherwise.
+// It's a read-only check, so it may be used at startup or in the readiness check of the service
+// whose migrations are applied by a separate job. The migrations table is never created by this check,
+// all passed migrations are considered pending if it doesn't exist.
+func (mm *MigrationsManager) AssertNoPendingMigrations(migrations []Migration) error {
+	items, err := mm.inventory(migrations, true)
+	if err != nil {
+		return err
+	}
+	var pendingIDs []string
+	for _, item := range items {
+		if item.Status == MigrationInventoryStatusPending {
+			pendingIDs = append(pendingIDs, item.ID)
+		}
+	}
+	if len(pendingIDs) != 0 {
+		return fmt.Errorf("%w: %s", ErrPendingMigrations, strings.Join(pendingIDs, ", "))
+	}
+	return nil
+}
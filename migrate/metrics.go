@@ -0,0 +1,132 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusMetricsLabelDirection is a label name for migration direction in Prometheus metrics.
+const PrometheusMetricsLabelDirection = "direction"
+
+// PrometheusMetricsLabelStatement is a label name for annotated migration statement in Prometheus metrics.
+const PrometheusMetricsLabelStatement = "statement"
+
+// DefaultMigrationDurationBuckets is default buckets into which observations of applying migrations are counted.
+var DefaultMigrationDurationBuckets = []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+// DefaultStatementDurationBuckets is default buckets into which observations of executing annotated statements are counted.
+var DefaultStatementDurationBuckets = []float64{0.001, 0.01, 0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+// PrometheusMetricsOpts represents an options for PrometheusMetrics.
+type PrometheusMetricsOpts struct {
+	// Namespace is a namespace for metrics. It will be prepended to all metric names.
+	Namespace string
+
+	// MigrationDurationBuckets is a list of buckets into which observations of applying migrations are counted.
+	MigrationDurationBuckets []float64
+
+	// StatementDurationBuckets is a list of buckets into which observations of executing annotated statements are counted.
+	StatementDurationBuckets []float64
+
+	// ConstLabels is a set of labels that will be applied to all metrics.
+	ConstLabels prometheus.Labels
+}
+
+// PrometheusMetrics represents collector of migrations metrics.
+// It may be passed to MigrationsManager via MigrationsManagerOpts.Metrics.
+type PrometheusMetrics struct {
+	MigrationDurations *prometheus.HistogramVec
+	MigrationsApplied  *prometheus.CounterVec
+	StatementDurations *prometheus.HistogramVec
+}
+
+// NewPrometheusMetrics creates a new migrations metrics collector.
+func NewPrometheusMetrics() *PrometheusMetrics {
+	return NewPrometheusMetricsWithOpts(PrometheusMetricsOpts{})
+}
+
+// NewPrometheusMetricsWithOpts is a more configurable version of creating PrometheusMetrics.
+func NewPrometheusMetricsWithOpts(opts PrometheusMetricsOpts) *PrometheusMetrics {
+	migrationDurationBuckets := opts.MigrationDurationBuckets
+	if migrationDurationBuckets == nil {
+		migrationDurationBuckets = DefaultMigrationDurationBuckets
+	}
+	migrationDurations := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Name:        "db_migration_duration_seconds",
+			Help:        "A histogram of the database migration durations.",
+			Buckets:     migrationDurationBuckets,
+			ConstLabels: opts.ConstLabels,
+		},
+		[]string{PrometheusMetricsLabelDirection},
+	)
+	migrationsApplied := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace:   opts.Namespace,
+			Name:        "db_migrations_applied_total",
+			Help:        "A number of successfully applied (or rolled back) database migrations.",
+			ConstLabels: opts.ConstLabels,
+		},
+		[]string{PrometheusMetricsLabelDirection},
+	)
+	statementDurationBuckets := opts.StatementDurationBuckets
+	if statementDurationBuckets == nil {
+		statementDurationBuckets = DefaultStatementDurationBuckets
+	}
+	statementDurations := prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace:   opts.Namespace,
+			Name:        "db_migration_statement_duration_seconds",
+			Help:        "A histogram of the annotated database migration statement durations.",
+			Buckets:     statementDurationBuckets,
+			ConstLabels: opts.ConstLabels,
+		},
+		[]string{PrometheusMetricsLabelDirection, PrometheusMetricsLabelStatement},
+	)
+	return &PrometheusMetrics{
+		MigrationDurations: migrationDurations,
+		MigrationsApplied:  migrationsApplied,
+		StatementDurations: statementDurations,
+	}
+}
+
+// MustRegister does registration of metrics collector in Prometheus and panics if any error occurs.
+func (pm *PrometheusMetrics) MustRegister() {
+	prometheus.MustRegister(pm.MigrationDurations, pm.MigrationsApplied, pm.StatementDurations)
+}
+
+// Unregister cancels registration of metrics collector in Prometheus.
+func (pm *PrometheusMetrics) Unregister() {
+	prometheus.Unregister(pm.MigrationDurations)
+	prometheus.Unregister(pm.MigrationsApplied)
+	prometheus.Unregister(pm.StatementDurations)
+}
+
+// AllMetrics returns a list of metrics of this collector. This can be used to register these metrics in push gateway.
+func (pm *PrometheusMetrics) AllMetrics() []prometheus.Collector {
+	return []prometheus.Collector{pm.MigrationDurations, pm.MigrationsApplied, pm.StatementDurations}
+}
+
+// ObserveMigration observes the duration of successfully applied (or rolled back) migration.
+func (pm *PrometheusMetrics) ObserveMigration(direction MigrationsDirection, duration time.Duration) {
+	labels := prometheus.Labels{PrometheusMetricsLabelDirection: string(direction)}
+	pm.MigrationDurations.With(labels).Observe(duration.Seconds())
+	pm.MigrationsApplied.With(labels).Inc()
+}
+
+// ObserveStatement observes the duration of successfully executed migration statement annotated with
+// the "-- @metric: <name>" comment.
+func (pm *PrometheusMetrics) ObserveStatement(direction MigrationsDirection, name string, duration time.Duration) {
+	pm.StatementDurations.With(prometheus.Labels{
+		PrometheusMetricsLabelDirection: string(direction),
+		PrometheusMetricsLabelStatement: name,
+	}).Observe(duration.Seconds())
+}
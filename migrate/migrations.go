@@ -8,12 +8,16 @@ Released under MIT license.
 package migrate
 
 import (
+	"context"
 	"database/sql"
 	"embed"
+	"errors"
 	"fmt"
+	"io"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/acronis/go-appkit/log"
@@ -25,6 +29,13 @@ import (
 // MigrationsTableName contains the name of table in a database that stores applied migrations.
 const MigrationsTableName = "migrations"
 
+// MySQLSafeIDColumnLength is the maximum length of utf8mb4 VARCHAR primary key column (4 bytes per character)
+// that fits into the 767-byte index limit of the older InnoDB row formats (see MigrationsManagerOpts.IDColumnLength).
+const MySQLSafeIDColumnLength = 191
+
+// mySQLMaxIDColumnLength is the maximum length of migration ID column that is created as VARCHAR (not TEXT) for MySQL.
+const mySQLMaxIDColumnLength = 255
+
 // MigrationsDirection defines possible values for direction of database migrations.
 type MigrationsDirection string
 
@@ -39,7 +50,10 @@ const MigrationsNoLimit = 0
 
 // Migration is an interface for all database migrations.
 // Migration may implement RawMigrator interface for full control.
-// Migration may implement TxDisabler interface to control transactions.
+// Migration may implement TxDisabler or DirectionalTxDisabler interface to control transactions.
+// Migration may implement Irreversible interface to forbid rolling back.
+// Migration may implement DataLoader interface to load data into the table.
+// Migration may implement Conditional interface to be skipped depending on the database server.
 type Migration interface {
 	ID() string
 	UpSQL() []string
@@ -59,6 +73,52 @@ type TxDisabler interface {
 	DisableTx() bool
 }
 
+// DirectionalTxDisabler is an interface for Migration for controlling transaction separately for each direction
+// (e.g. CREATE INDEX CONCURRENTLY can't be executed in transaction, while its DROP INDEX can).
+// It takes precedence over TxDisabler.
+type DirectionalTxDisabler interface {
+	DisableTxForDirection(direction MigrationsDirection) bool
+}
+
+// Tombstoner is an interface for Migration for marking it as intentionally empty.
+// Such "tombstone" migration has no SQL statements, but it's recorded as applied (or rolled back) as a regular one.
+// It allows retiring migration's SQL while keeping its ID, so already migrated databases aren't confused.
+type Tombstoner interface {
+	Tombstone() bool
+}
+
+// Irreversible is an interface for Migration for marking it as the one that can't be rolled back
+// (e.g. it drops a column or destroys data, so there is no meaningful down).
+// Rolling back such applied migration fails with ErrIrreversibleMigration before any migration is rolled back,
+// so the operator has to make an explicit decision instead of "succeeding" with a fake down migration.
+type Irreversible interface {
+	Irreversible() bool
+}
+
+// ErrIrreversibleMigration is returned when the irreversible migration (see Irreversible) should be rolled back.
+var ErrIrreversibleMigration = errors.New("irreversible db migration can't be rolled back")
+
+// ErrIncompatibleMigrationsTable is returned when the migrations table already exists (e.g. it's created by another tool),
+// but it doesn't have the expected columns (id and applied_at).
+var ErrIncompatibleMigrationsTable = errors.New("incompatible schema of db migrations table")
+
+// ErrMigrationsTableNotExist is returned when the migrations table doesn't exist
+// and its creation is disabled (see MigrationsManagerOpts.DisableTableCreation).
+var ErrMigrationsTableNotExist = errors.New("db migrations table doesn't exist")
+
+// ErrChecksumMismatch is returned when the migration that should be rolled back was changed after applying
+// (see MigrationsManagerOpts.VerifyDownChecksums).
+var ErrChecksumMismatch = errors.New("db migration checksum mismatch")
+
+// Conditional is an interface for Migration that may decline to run depending on the database server
+// (e.g. its version or available features), so a single set of migrations may target heterogeneous servers.
+// ShouldRun is called right before applying (or rolling back) the migration.
+// If it returns false, the migration's statements are not executed, but it's recorded as applied
+// (or its record is deleted) as a regular one and it's reported as skipped (see MigrationReport.Skipped).
+type Conditional interface {
+	ShouldRun(ctx context.Context, db *sql.DB, dialect dbkit.Dialect) (bool, error)
+}
+
 // NullMigration represents an empty basic migration that may be embedded in regular migrations
 // in order to write less code for satisfying the Migration interface.
 type NullMigration struct {
@@ -129,23 +189,182 @@ func (m *CustomMigration) DownFn() func(tx *sql.Tx) error {
 	return m.downFn
 }
 
+// TombstoneMigration represents intentionally empty migration that only reserves its ID (see Tombstoner).
+type TombstoneMigration struct {
+	NullMigration
+	id string
+}
+
+// NewTombstoneMigration creates intentionally empty migration with the passed ID.
+func NewTombstoneMigration(id string) *TombstoneMigration {
+	return &TombstoneMigration{id: id}
+}
+
+// ID returns migration identifier.
+func (m *TombstoneMigration) ID() string {
+	return m.id
+}
+
+// Tombstone returns true since the migration is intentionally empty.
+func (m *TombstoneMigration) Tombstone() bool {
+	return true
+}
+
 // MigrationsManager is an object for running migrations.
 type MigrationsManager struct {
 	db      *sql.DB
 	Dialect dbkit.Dialect
 	migSet  migrate.MigrationSet
 	logger  log.FieldLogger
+	opts    MigrationsManagerOpts
+
+	driverDialect dbkit.Dialect // Passed dialect, Dialect is normalized (pgx is replaced with postgres).
+
+	statusCacheMu        sync.Mutex
+	statusCache          *MigrationStatus
+	statusCacheUpdatedAt time.Time
 }
 
 // MigrationsManagerOpts holds the Migration Manager options to be used in NewMigrationsManagerWithOpts
 type MigrationsManagerOpts struct {
+	// TableName is a name of the table that stores applied migrations (MigrationsTableName is used by default).
+	// It must not exceed the identifier length limit of the SQL dialect (see dbkit.MaxIdentifierLength).
+	// Managers with different table names track their migrations independently (e.g. each module of the service
+	// may own its migrations in the same database), see RunAll.
+	// The name (as well as TableSchema) is always quoted in queries, so it's case-sensitive for Postgres:
+	// a mixed-case name (e.g. "MyMigrations") is not folded to lowercase and must be quoted in the custom SQL as well.
 	TableName string
+
+	// IDColumnLength is a maximum length of the migration ID (VARCHAR column), it's used only when the table is created.
+	// By default, it's 255 for MySQL, SQLite and MSSQL, and unlimited (text) for Postgres.
+	// MySQL table is created with UTF8 (utf8mb3) charset, so 255 characters fit into the 767-byte index limit
+	// of the older InnoDB row formats (MySQL 5.6 and 5.7 defaults). If the table (or the whole database) is converted
+	// to utf8mb4, MySQLSafeIDColumnLength should be used instead at the cost of shorter migration IDs.
+	// It may not exceed 255 for MySQL since the longer column can't be a primary key.
+	IDColumnLength int
+
+	// TableSchema is a schema of the table that stores applied migrations (e.g. Postgres schema or MySQL database).
+	// If it's set, the table is referenced as "schema.table" in all queries, so multi-schema setups
+	// don't depend on the search_path. The schema is created if it doesn't exist.
+	// It must not exceed the identifier length limit of the SQL dialect (see dbkit.MaxIdentifierLength).
+	// It's not supported for SQLite.
+	TableSchema string
+
+	// DisableTableCreation disables creating the migrations table (and its schema) if it doesn't exist.
+	// Error wrapping ErrMigrationsTableNotExist is returned by all methods instead
+	// (except AssertNoPendingMigrations that never creates the table and reports all migrations as pending).
+	// It allows using read-only methods (Status, StatusCached, Inventory)
+	// with the read-only database (e.g. replica) whose migrations are applied via the primary one.
+	DisableTableCreation bool
+
+	// SortFunc defines the order in which migrations are applied (reversed order is used for rolling back).
+	// It should return true if migration a must be applied before migration b.
+	// By default, the sql-migrate ordering is used: migrations with numeric ID prefixes are ordered by number,
+	// others are ordered lexically by ID.
+	SortFunc func(a, b Migration) bool
+
+	// EventWriter receives a single-line JSON object (see MigrationEvent) per each applied or rolled back migration.
+	// It's written in addition to the logging and may be used by CI systems and deploy tooling.
+	EventWriter io.Writer
+
+	// Progress is called when running of the migrations is started, after each applied (or rolled back) migration,
+	// and when running is successfully finished (see ProgressEvent).
+	// It may be used for reporting progress in a custom way (e.g. via a CLI progress bar).
+	// If it's set, informational messages about the run result are not logged (errors and warnings still are).
+	Progress func(event ProgressEvent)
+
+	// StatementProgress enables reporting progress after each executed statement of the migration
+	// (ProgressEventTypeStatement), so it's visible that the long migration (e.g. bulk data transformation) isn't hung.
+	// Events are passed to the Progress callback if it's set, otherwise they are logged with the info level.
+	StatementProgress bool
+
+	// ExecMultiStatement enables sending all SQL statements of the migration in a single Exec call
+	// instead of executing them one by one. It reduces the number of round trips to the database,
+	// but requires driver support of multi-statement execution (e.g. MySQL with multiStatements=true,
+	// which is set by dbkit.MakeMySQLDSN) and loses the per-statement error context, so it's opt-in.
+	ExecMultiStatement bool
+
+	// Metrics is used for collecting durations and number of applied (or rolled back) migrations.
+	Metrics *PrometheusMetrics
+
+	// IgnoreErrorCodes is a list of error codes (SQLSTATE for Postgres, error numbers for MySQL and MSSQL)
+	// that are treated as success when a statement of the up migration fails with them.
+	// It's a recovery path for migrations with disabled transaction that were partially applied before a failure
+	// (e.g. "42P07" (duplicate_table) for Postgres or "1050" (ER_TABLE_EXISTS_ERROR) for MySQL).
+	// Note that failed statement aborts the whole transaction in Postgres, so for it this option makes sense
+	// only for migrations with disabled transaction. Use it carefully since it may mask real errors.
+	IgnoreErrorCodes []string
+
+	// ExpectedDatabaseName is a name of the database that migrations are supposed to be run on.
+	// If it's set, the name of the connected database is checked before running migrations,
+	// and the run is aborted if it doesn't match. It protects from running migrations on a wrong database
+	// because of a misconfigured DSN. For SQLite, the name of the main database file (without directory) is checked,
+	// the check is skipped for in-memory databases since they have no file.
+	ExpectedDatabaseName string
+
+	// StatementRewriter is called for each SQL statement of the migration before its execution.
+	// It may be used for mechanical dialect-specific substitutions (e.g. AUTOINCREMENT vs AUTO_INCREMENT),
+	// so a single set of migrations may be run on several dialects.
+	StatementRewriter func(dialect dbkit.Dialect, stmt string) string
+
+	// GoquDialect overrides the name of the goqu dialect that is used for building queries to the migrations table
+	// (e.g. selecting applied migrations and MarkApplied). By default, it's derived from the SQL dialect.
+	// It may be used for fixing quoting or placeholder mismatches with Postgres-compatible engines
+	// (e.g. CockroachDB or YugabyteDB). The dialect must be registered in goqu (see goqu.RegisterDialect).
+	GoquDialect string
+
+	// ExistenceGuards enables adding existence guards ("IF NOT EXISTS" and "IF EXISTS") to DDL statements
+	// where the dialect supports them (see AddExistenceGuards), so a partially applied migration may be re-run.
+	// It's a best-effort transformation that is applied after StatementRewriter.
+	ExistenceGuards bool
+
+	// DisableMixedDDLWarning disables the warning that is logged when a transactional MySQL migration
+	// contains both DDL (e.g. CREATE TABLE, ALTER TABLE) and DML (e.g. INSERT, UPDATE) statements.
+	// DDL statements cause an implicit commit in MySQL, so such migration is not atomic
+	// and should be split into separate migrations.
+	DisableMixedDDLWarning bool
+
+	// DuplicatePrefixMode defines how migrations with distinct IDs sharing the same numeric prefix
+	// (see FindDuplicateNumericPrefixes) are handled before running:
+	// the warning is logged (DuplicatePrefixModeWarn, used by default), the run is aborted (DuplicatePrefixModeError)
+	// or they are not checked at all (DuplicatePrefixModeIgnore).
+	DuplicatePrefixMode DuplicatePrefixMode
+
+	// RunLocker serializes migrations runs across several processes (e.g. pods of the same service started at once).
+	// If it's set, migrations are applied (or rolled back) only under the lock.
+	// distrlock.DBManager.MigrationsRunLocker may be used for the locking based on the distributed locks table.
+	RunLocker RunLocker
+
+	// FilterAppliedMigrations makes Run query only applied migrations whose IDs are among the passed ones
+	// (via "WHERE id IN (...)") instead of loading the whole migrations table.
+	// It bounds memory and query cost by the size of the migration set for long-lived schemas with huge history.
+	// Since migrations that are unknown for the passed set are not loaded, they don't cause an error in this case.
+	FilterAppliedMigrations bool
+
+	// InterpolateQueries makes queries built with goqu (see FilterAppliedMigrations) use interpolated SQL
+	// instead of the prepared one with dialect-specific placeholders.
+	// It may be required for drivers or proxies (e.g. some PgBouncer modes) that don't handle placeholders properly.
+	InterpolateQueries bool
+
+	// VerifyDownChecksums makes Run store the checksum (see MigrationChecksum) of each applied migration
+	// in the additional table (TableName with ChecksumsTableSuffix) and verify it before rolling the migration back.
+	// If any of the migrations was changed after applying, its down SQL may not correspond to what's actually
+	// in the database, so the rollback is aborted with ErrChecksumMismatch before running any down SQL.
+	// Migrations applied without the stored checksum (e.g. before enabling the option or by MarkApplied) are not verified.
+	// The checksums table is created along with the migrations table (see DisableTableCreation).
+	VerifyDownChecksums bool
+}
+
+// RunLocker is an interface for serializing migrations runs.
+// DoExclusively must call fn only if the lock is acquired and release the lock when fn is finished.
+// The context passed to fn should be canceled if the lock is lost, migrations are not run after that.
+type RunLocker interface {
+	DoExclusively(ctx context.Context, fn func(ctx context.Context) error) error
 }
 
 // NewMigrationsManager creates a new MigrationsManager.
 func NewMigrationsManager(dbConn *sql.DB, dialect dbkit.Dialect, logger log.FieldLogger) (*MigrationsManager, error) {
-	migSet := migrate.MigrationSet{TableName: MigrationsTableName}
-	return &MigrationsManager{dbConn, normalizeDialect(dialect), migSet, logger}, nil
+	return NewMigrationsManagerWithOpts(dbConn, dialect, logger, MigrationsManagerOpts{})
 }
 
 // NewMigrationsManagerWithOpts creates a new MigrationsManager with custom options
@@ -155,12 +374,41 @@ func NewMigrationsManagerWithOpts(
 	logger log.FieldLogger,
 	opts MigrationsManagerOpts,
 ) (*MigrationsManager, error) {
-	tableName := opts.TableName
-	if tableName == "" {
-		tableName = MigrationsTableName
+	if opts.TableName == "" {
+		opts.TableName = MigrationsTableName
+	}
+	if maxLen := dbkit.MaxIdentifierLength(dialect); maxLen > 0 && len(opts.TableName) > maxLen {
+		return nil, fmt.Errorf("migrations table name %q is too long for %s dialect: %d characters, max %d",
+			opts.TableName, dialect, len(opts.TableName), maxLen)
 	}
-	migSet := migrate.MigrationSet{TableName: tableName}
-	return &MigrationsManager{dbConn, normalizeDialect(dialect), migSet, logger}, nil
+	if opts.TableSchema != "" {
+		if dialect == dbkit.DialectSQLite {
+			return nil, fmt.Errorf("migrations table schema is not supported for %s dialect", dialect)
+		}
+		if maxLen := dbkit.MaxIdentifierLength(dialect); maxLen > 0 && len(opts.TableSchema) > maxLen {
+			return nil, fmt.Errorf("migrations table schema %q is too long for %s dialect: %d characters, max %d",
+				opts.TableSchema, dialect, len(opts.TableSchema), maxLen)
+		}
+	}
+	if opts.VerifyDownChecksums {
+		tableName := opts.TableName + ChecksumsTableSuffix
+		if maxLen := dbkit.MaxIdentifierLength(dialect); maxLen > 0 && len(tableName) > maxLen {
+			return nil, fmt.Errorf("migrations checksums table name %q is too long for %s dialect: %d characters, max %d",
+				tableName, dialect, len(tableName), maxLen)
+		}
+	}
+	if opts.IDColumnLength < 0 || (opts.IDColumnLength > mySQLMaxIDColumnLength && dialect == dbkit.DialectMySQL) {
+		return nil, fmt.Errorf("invalid migration ID column length %d for %s dialect", opts.IDColumnLength, dialect)
+	}
+	switch opts.DuplicatePrefixMode {
+	case "", DuplicatePrefixModeWarn, DuplicatePrefixModeError, DuplicatePrefixModeIgnore:
+	default:
+		return nil, fmt.Errorf("unknown duplicate prefix mode %q", opts.DuplicatePrefixMode)
+	}
+	migSet := migrate.MigrationSet{TableName: opts.TableName, SchemaName: opts.TableSchema}
+	return &MigrationsManager{
+		db: dbConn, Dialect: normalizeDialect(dialect), migSet: migSet, logger: logger, opts: opts, driverDialect: dialect,
+	}, nil
 }
 
 // TODO: normalizeDialect sets standard lib/pq driver for pgx dialect because pgx isn't supported by sql-migrate yet.
@@ -173,12 +421,52 @@ func normalizeDialect(dialect dbkit.Dialect) dbkit.Dialect {
 
 // Run runs all passed migrations.
 func (mm *MigrationsManager) Run(migrations []Migration, direction MigrationsDirection) error {
-	return mm.RunLimit(migrations, direction, MigrationsNoLimit)
+	return mm.RunContext(context.Background(), migrations, direction)
+}
+
+// RunContext works like Run, but the passed context bounds the run.
+// It's passed to RunLocker, so it bounds the lock acquisition (e.g. retried by distrlock.WithAcquireRetry),
+// and it's checked before each migration and statement, so the run is stopped when it's done
+// (e.g. when RunLocker cancels it because the lock is lost).
+// The statement that is already being executed is not interrupted.
+func (mm *MigrationsManager) RunContext(ctx context.Context, migrations []Migration, direction MigrationsDirection) error {
+	_, err := mm.runLimit(ctx, migrations, direction, MigrationsNoLimit)
+	return err
+}
+
+// RunAll runs migrations of several independent managers (e.g. one per module of the service with its own table,
+// see MigrationsManagerOpts.TableName), migrations[i] are run by managers[i].
+// Managers are run in the passed order for the up direction and in the reversed one for the down direction,
+// so modules that depend on the schema of others may follow them. Running stops on the first failure.
+// Managers must not share the migrations table, since they would consider each other's migrations as unknown.
+func RunAll(managers []*MigrationsManager, migrations [][]Migration, direction MigrationsDirection) error {
+	if len(managers) != len(migrations) {
+		return fmt.Errorf("number of migration sets (%d) doesn't match number of managers (%d)", len(migrations), len(managers))
+	}
+	tables := make(map[string]int, len(managers))
+	for i, mm := range managers {
+		table := mm.migSet.SchemaName + "." + mm.migSet.TableName
+		if prev, ok := tables[table]; ok {
+			return fmt.Errorf("managers #%d and #%d use the same migrations table %s", prev+1, i+1, mm.migSet.TableName)
+		}
+		tables[table] = i
+	}
+
+	for k := range managers {
+		i := k
+		if direction == MigrationsDirectionDown {
+			i = len(managers) - 1 - k
+		}
+		if err := managers[i].Run(migrations[i], direction); err != nil {
+			return fmt.Errorf("run db migrations of manager #%d (table %s): %w", i+1, managers[i].migSet.TableName, err)
+		}
+	}
+	return nil
 }
 
 // convertMigration converts migration to internal sql-migrate format.
 // If migration implements RawMigrator interface, then RawMigration function is used.
-// If migration implements TxDisabler interface, then it may be not in transaction.
+// If migration implements TxDisabler (or DirectionalTxDisabler) interface, then it may be not in transaction.
 func convertMigration(m Migration) (*migrate.Migration, error) {
 	if migrator, ok := m.(RawMigrator); ok {
 		raw, err := migrator.RawMigration(m)
@@ -190,10 +478,19 @@ func convertMigration(m Migration) (*migrate.Migration, error) {
 		}
 	}
 
-	if len(m.UpSQL()) == 0 { // Check will be removed when UpFn() will be supported.
+	if tombstoner, ok := m.(Tombstoner); ok && tombstoner.Tombstone() {
+		if len(m.UpSQL()) != 0 || len(m.DownSQL()) != 0 || m.UpFn() != nil || m.DownFn() != nil {
+			return nil, fmt.Errorf("tombstone migration %s should not have any SQL statements or functions", m.ID())
+		}
+		return &migrate.Migration{Id: m.ID()}, nil
+	}
+
+	// DataLoader migration may have no up SQL statements, data is loaded in this case only.
+	_, loadsData := m.(DataLoader)
+	if len(m.UpSQL()) == 0 && !loadsData { // Check will be removed when UpFn() will be supported.
 		return nil, fmt.Errorf("migration %s should implement UpSQL", m.ID())
 	}
-	if (m.UpFn() == nil && len(m.UpSQL()) == 0) || (m.UpFn() != nil && len(m.UpSQL()) != 0) {
+	if (m.UpFn() == nil && len(m.UpSQL()) == 0 && !loadsData) || (m.UpFn() != nil && len(m.UpSQL()) != 0) {
 		// Will be actual when UpFn() will be supported.
 		return nil, fmt.Errorf("migration %s should implement either UpFn or UpSQL", m.ID())
 	}
@@ -201,36 +498,121 @@ func convertMigration(m Migration) (*migrate.Migration, error) {
 		// Will be actual when DownFn() will be supported.
 		return nil, fmt.Errorf("migration %s should implement either DownFn or DownSQL", m.ID())
 	}
-	disableTx := false
-	if disableTransactor, ok := m.(TxDisabler); ok {
-		disableTx = disableTransactor.DisableTx()
+	disableTxUp, disableTxDown := false, false
+	if directionalDisabler, ok := m.(DirectionalTxDisabler); ok {
+		disableTxUp = directionalDisabler.DisableTxForDirection(MigrationsDirectionUp)
+		disableTxDown = directionalDisabler.DisableTxForDirection(MigrationsDirectionDown)
+	} else if disableTransactor, ok := m.(TxDisabler); ok {
+		disableTxUp = disableTransactor.DisableTx()
+		disableTxDown = disableTxUp
 	}
 	return &migrate.Migration{
 		Id:                     m.ID(),
 		Up:                     m.UpSQL(),
 		Down:                   m.DownSQL(),
-		DisableTransactionUp:   disableTx,
-		DisableTransactionDown: disableTx,
+		DisableTransactionUp:   disableTxUp,
+		DisableTransactionDown: disableTxDown,
 	}, nil
 }
 
+// RenderMigration returns SQL statements that would be executed for the migration in the passed direction
+// (after applying RawMigrator, StatementRewriter, ExistenceGuards and ExecMultiStatement options) without executing them.
+// Unlike Status, it doesn't check which migrations are applied, so it may be used for reviewing
+// and unit-testing the SQL of programmatically built migrations.
+func (mm *MigrationsManager) RenderMigration(m Migration, direction MigrationsDirection) ([]string, error) {
+	convertedMigration, err := convertMigration(m)
+	if err != nil {
+		return nil, err
+	}
+	var statements []string
+	switch direction {
+	case MigrationsDirectionUp:
+		statements = convertedMigration.Up
+	case MigrationsDirectionDown:
+		statements = convertedMigration.Down
+	default:
+		return nil, fmt.Errorf("unknown direction %q", direction)
+	}
+	statements = mm.prepareStatements(statements)
+	rendered := make([]string, 0, len(statements))
+	for _, stmt := range statements {
+		rendered = append(rendered, trimStatement(stmt))
+	}
+	return rendered, nil
+}
+
 // RunLimit runs at most `limit` migrations. Pass 0 (or MigrationsNoLimit const) for no limit (or use Run).
 func (mm *MigrationsManager) RunLimit(migrations []Migration, direction MigrationsDirection, limit int) error {
+	_, err := mm.runLimit(context.Background(), migrations, direction, limit)
+	return err
+}
+
+// RunWithReport runs all passed migrations and returns a report with the timing summary.
+// The report is built in memory and may be used for logging or for asserting performance in tests.
+// If an error occurs, the report contains only migrations that were applied before the failure.
+func (mm *MigrationsManager) RunWithReport(migrations []Migration, direction MigrationsDirection) (RunReport, error) {
+	return mm.runLimit(context.Background(), migrations, direction, MigrationsNoLimit)
+}
+
+// RunLimitWithReport works like RunLimit, but returns a report (see RunWithReport).
+// RunReport.Applied is 0 when there was nothing to apply (or to roll back)
+// because the database was already in the requested state.
+// For the down direction, limit greater than the number of applied migrations is not an error,
+// all applied migrations are rolled back in this case.
+func (mm *MigrationsManager) RunLimitWithReport(
+	migrations []Migration, direction MigrationsDirection, limit int,
+) (RunReport, error) {
+	return mm.runLimit(context.Background(), migrations, direction, limit)
+}
+
+// RunLimitWithReportContext works like RunLimitWithReport, but the passed context bounds the run (see RunContext).
+func (mm *MigrationsManager) RunLimitWithReportContext(
+	ctx context.Context, migrations []Migration, direction MigrationsDirection, limit int,
+) (RunReport, error) {
+	return mm.runLimit(ctx, migrations, direction, limit)
+}
+
+// RunReport contains a timing summary of the migrations run.
+type RunReport struct {
+	// Applied is the number of applied (or rolled back) migrations, including the ones skipped by their condition.
+	// It's 0 for a successful run only if the database was already in the requested state.
+	Applied    int
+	Elapsed    time.Duration
+	Migrations []MigrationReport
+}
+
+// MigrationReport contains timing information of a single applied migration.
+type MigrationReport struct {
+	ID       string
+	Duration time.Duration
+
+	// RowsAffected contains the number of rows affected by each executed SQL statement (in the execution order).
+	// It's 0 for DDL statements and when the driver doesn't support it.
+	// The number of loaded rows is appended for the DataLoader migration.
+	RowsAffected []int64
+
+	// Skipped is true if the Conditional migration declined to run, so only its record was changed.
+	Skipped bool
+}
+
+func (mm *MigrationsManager) runLimit(
+	ctx context.Context, migrations []Migration, direction MigrationsDirection, limit int,
+) (RunReport, error) {
 	convertedMigrationList := make([]*migrate.Migration, 0, len(migrations))
+	migrationsByID := make(map[string]Migration, len(migrations))
 	for i, m := range migrations {
 		if m.ID() == "" {
-			return fmt.Errorf("migration #%d has empty ID", i+1)
+			return RunReport{}, fmt.Errorf("migration #%d has empty ID", i+1)
 		}
 
 		convertedMigration, err := convertMigration(m)
 		if err != nil {
-			return err
+			return RunReport{}, err
 		}
 		convertedMigrationList = append(convertedMigrationList, convertedMigration)
+		migrationsByID[m.ID()] = m
 	}
 
-	source := &migrate.MemoryMigrationSource{Migrations: convertedMigrationList}
-
 	var dir migrate.MigrationDirection
 	switch direction {
 	case MigrationsDirectionUp:
@@ -238,17 +620,72 @@ func (mm *MigrationsManager) RunLimit(migrations []Migration, direction Migratio
 	case MigrationsDirectionDown:
 		dir = migrate.Down
 	default:
-		return fmt.Errorf("unknown direction %q", dir)
+		return RunReport{}, fmt.Errorf("unknown direction %q", dir)
+	}
+
+	if err := mm.checkDuplicatePrefixes(migrations); err != nil {
+		return RunReport{}, err
 	}
 
-	n, err := mm.migSet.ExecMax(mm.db, string(mm.Dialect), source, dir, limit)
+	if err := mm.checkDatabaseName(); err != nil {
+		return RunReport{}, err
+	}
+
+	sortedMigrations := mm.sortMigrations(migrations, convertedMigrationList)
+	var report RunReport
+	err := mm.doExclusively(ctx, func(ctx context.Context) error {
+		startedAt := time.Now()
+		var execErr error
+		report, execErr = mm.execMax(ctx, sortedMigrations, dir, limit, migrationsByID)
+		report.Elapsed = time.Since(startedAt)
+		return execErr
+	})
+	if report.Applied != 0 {
+		mm.invalidateStatusCache()
+	}
 
-	logger := mm.logger.With(log.String("direction", string(direction)), log.Int("applied", n))
+	logger := mm.logger.With(log.String("direction", string(direction)), log.Int("applied", report.Applied),
+		log.Int64("duration_ms", report.Elapsed.Milliseconds()))
 	if err != nil {
 		logger.Error("db migration failed", log.Error(err))
-		return err
+		return report, err
+	}
+	if report.Applied == 0 {
+		if direction == MigrationsDirectionDown {
+			// Rolling back when nothing is applied is likely a mistake (e.g. wrong database).
+			logger.Warn("no db migrations to roll back")
+		} else if mm.opts.Progress == nil {
+			logger.Info("no db migrations to apply")
+		}
+		return report, nil
+	}
+	if mm.opts.Progress == nil {
+		logger.Info(fmt.Sprintf("db migration %s succeeded", direction))
+	}
+	return report, nil
+}
+
+// doExclusively calls the passed function under the RunLocker if it's configured.
+// The function receives the locker's context, which may be canceled when the lock is lost.
+func (mm *MigrationsManager) doExclusively(ctx context.Context, fn func(ctx context.Context) error) error {
+	if mm.opts.RunLocker == nil {
+		return fn(ctx)
+	}
+	return mm.opts.RunLocker.DoExclusively(ctx, fn)
+}
+
+// checkDuplicatePrefixes checks migrations for the same numeric prefixes according to the configured mode.
+func (mm *MigrationsManager) checkDuplicatePrefixes(migrations []Migration) error {
+	if mm.opts.DuplicatePrefixMode == DuplicatePrefixModeIgnore {
+		return nil
+	}
+	for _, ids := range FindDuplicateNumericPrefixes(migrations) {
+		if mm.opts.DuplicatePrefixMode == DuplicatePrefixModeError {
+			return fmt.Errorf("migrations %s have the same numeric prefix", strings.Join(ids, ", "))
+		}
+		mm.logger.Warn(fmt.Sprintf("db migrations %s have the same numeric prefix, "+
+			"it's likely a numbering conflict that should be resolved", strings.Join(ids, ", ")))
 	}
-	logger.Info("db migration up succeeded")
 	return nil
 }
 
@@ -256,22 +693,48 @@ func (mm *MigrationsManager) RunLimit(migrations []Migration, direction Migratio
 func (mm *MigrationsManager) Status() (MigrationStatus, error) {
 	var migStatus MigrationStatus
 
-	appliedMigRecords, err := mm.migSet.GetMigrationRecords(mm.db, string(mm.Dialect))
+	dbMap, err := mm.migrationsDBMap()
 	if err != nil {
-		return migStatus, fmt.Errorf("get applied migrations: %w", err)
+		return migStatus, err
 	}
-	migStatus.AppliedMigrations = make([]AppliedMigration, 0, len(appliedMigRecords))
-	for _, migRec := range appliedMigRecords {
-		migStatus.AppliedMigrations = append(migStatus.AppliedMigrations, AppliedMigration{ID: migRec.Id, AppliedAt: migRec.AppliedAt})
+	appliedMigs, err := mm.getAppliedMigrations(dbMap)
+	if err != nil {
+		return migStatus, fmt.Errorf("get applied migrations: %w", err)
 	}
+	migStatus.AppliedMigrations = make([]AppliedMigration, 0, len(appliedMigs))
+	migStatus.AppliedMigrations = append(migStatus.AppliedMigrations, appliedMigs...)
 
 	return migStatus, nil
 }
 
+// StatusCached works like Status, but caches the result for the ttl duration.
+// The cache is invalidated automatically when migrations are applied or rolled back by this MigrationsManager.
+// It may be useful for frequently called endpoints that expose the migration status.
+func (mm *MigrationsManager) StatusCached(ttl time.Duration) (MigrationStatus, error) {
+	mm.statusCacheMu.Lock()
+	defer mm.statusCacheMu.Unlock()
+
+	if mm.statusCache == nil || time.Since(mm.statusCacheUpdatedAt) >= ttl {
+		migStatus, err := mm.Status()
+		if err != nil {
+			return migStatus, err
+		}
+		mm.statusCache = &migStatus
+		mm.statusCacheUpdatedAt = time.Now()
+	}
+	return MigrationStatus{AppliedMigrations: append([]AppliedMigration(nil), mm.statusCache.AppliedMigrations...)}, nil
+}
+
+func (mm *MigrationsManager) invalidateStatusCache() {
+	mm.statusCacheMu.Lock()
+	defer mm.statusCacheMu.Unlock()
+	mm.statusCache = nil
+}
+
 // AppliedMigration represent a single already applied migration.
 type AppliedMigration struct {
 	ID        string
-	AppliedAt time.Time
+	AppliedAt time.Time // Migrations are recorded with UTC time. Zero if it's NULL (e.g. recorded by another tool).
 }
 
 // MigrationStatus is the migration status.
@@ -334,8 +797,8 @@ func LoadAllEmbedFSMigrations(fs embed.FS, dirName string) ([]Migration, error)
 		}
 		migrations = append(migrations, &CustomMigration{
 			id:      migrationID,
-			upSQL:   []string{string(upSQL)},
-			downSQL: []string{string(downSQL)},
+			upSQL:   []string{normalizeLineEndings(upSQL)},
+			downSQL: []string{normalizeLineEndings(downSQL)},
 		})
 	}
 
@@ -360,9 +823,41 @@ func LoadEmbedFSMigrations(fs embed.FS, dirName string, migrationIDs []string) (
 		}
 		migrations = append(migrations, &CustomMigration{
 			id:      migrationID,
-			upSQL:   []string{string(upSQL)},
-			downSQL: []string{string(downSQL)},
+			upSQL:   []string{normalizeLineEndings(upSQL)},
+			downSQL: []string{normalizeLineEndings(downSQL)},
 		})
 	}
 	return migrations, nil
 }
+
+// MigrationSQL contains up and down SQL of the migration (see NewMapMigrations).
+type MigrationSQL struct {
+	Up   string
+	Down string
+}
+
+// NewMapMigrations creates migrations from the in-memory map of migration ID to its SQL.
+// Migrations are the same as the ones loaded by LoadAllEmbedFSMigrations from the corresponding .up.sql and .down.sql files
+// and are sorted by ID in the same way, so it's a lightweight alternative for unit tests with inline SQL snippets.
+func NewMapMigrations(sources map[string]MigrationSQL) []Migration {
+	migrations := make([]Migration, 0, len(sources))
+	for migrationID, migrationSQL := range sources {
+		migrations = append(migrations, &CustomMigration{
+			id:      migrationID,
+			upSQL:   []string{normalizeLineEndings([]byte(migrationSQL.Up))},
+			downSQL: []string{normalizeLineEndings([]byte(migrationSQL.Down))},
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].ID() < migrations[j].ID()
+	})
+
+	return migrations
+}
+
+// normalizeLineEndings converts CRLF and CR line endings to LF,
+// so migrations authored on Windows behave identically to those authored on Unix.
+func normalizeLineEndings(sqlData []byte) string {
+	return strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(string(sqlData))
+}
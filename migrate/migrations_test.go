@@ -8,19 +8,38 @@ package migrate
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 
+	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/acronis/go-appkit/log/logtest"
+	"github.com/acronis/go-appkit/testutil"
+	"github.com/doug-martin/goqu/v9"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
+	mssql "github.com/microsoft/go-mssqldb"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	migrate "github.com/rubenv/sql-migrate"
 	"github.com/stretchr/testify/require"
 
 	"github.com/acronis/go-dbkit"
+	dbtesting "github.com/acronis/go-dbkit/internal/testing"
 )
 
 type testMigration00001CreateTables struct {
@@ -203,6 +222,500 @@ func TestMigrationsManager_RunLimit(t *testing.T) {
 	requireMigrationsApplied(t, dbConn, true, 0, 0)
 }
 
+func TestMigrationsManager_FilterAppliedMigrations(t *testing.T) {
+	for _, interpolateQueries := range []bool{false, true} {
+		t.Run(fmt.Sprintf("interpolate queries: %v", interpolateQueries), func(t *testing.T) {
+			dbConn, err := sql.Open("sqlite3", "file::memory:")
+			require.NoError(t, err)
+			dbConn.SetMaxOpenConns(1)
+			defer requireNoErrOnClose(t, dbConn)
+
+			migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+
+			migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(),
+				MigrationsManagerOpts{FilterAppliedMigrations: true, InterpolateQueries: interpolateQueries})
+			require.NoError(t, err)
+			require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionUp, 1))
+
+			// Record a historical migration that is not in the current set.
+			_, err = dbConn.Exec("INSERT INTO "+MigrationsTableName+" (id, applied_at) VALUES (?, ?)", "00000_legacy", time.Now().UTC())
+			require.NoError(t, err)
+
+			// Without filtering, the historical migration is loaded and rejected.
+			unfilteredMigMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+			require.NoError(t, err)
+			require.ErrorContains(t, unfilteredMigMngr.Run(migrations, MigrationsDirectionUp), "unknown migration in database")
+
+			require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+			requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+			migStatus, err := migMngr.Status()
+			require.NoError(t, err)
+			require.Len(t, migStatus.AppliedMigrations, 3)
+
+			require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+			requireMigrationsApplied(t, dbConn, true, 0, 0)
+		})
+	}
+}
+
+type irreversibleTestMigration struct {
+	Migration
+}
+
+func (m *irreversibleTestMigration) Irreversible() bool {
+	return true
+}
+
+func TestMigrationsManager_Irreversible(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	dbConn.SetMaxOpenConns(1)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	migrations := []Migration{
+		&irreversibleTestMigration{newTestMigration00001CreateTables()},
+		newTestMigration00002SeedTabled(),
+	}
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+	// Nothing is rolled back if the irreversible migration is planned.
+	err = migMngr.Run(migrations, MigrationsDirectionDown)
+	require.ErrorIs(t, err, ErrIrreversibleMigration)
+	require.ErrorContains(t, err, migrations[0].ID())
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+	// Reversible migrations may be rolled back.
+	require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionDown, 1))
+	requireMigrationsApplied(t, dbConn, false, 0, 0)
+	require.ErrorIs(t, migMngr.RunLimit(migrations, MigrationsDirectionDown, 1), ErrIrreversibleMigration)
+	requireMigrationsApplied(t, dbConn, false, 0, 0)
+}
+
+type conditionalTestMigration struct {
+	Migration
+	shouldRun func(ctx context.Context, db *sql.DB, dialect dbkit.Dialect) (bool, error)
+}
+
+func (m *conditionalTestMigration) ShouldRun(ctx context.Context, db *sql.DB, dialect dbkit.Dialect) (bool, error) {
+	return m.shouldRun(ctx, db, dialect)
+}
+
+func TestMigrationsManager_Conditional(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	dbConn.SetMaxOpenConns(1)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+
+	var condErr error
+	migrations := []Migration{
+		newTestMigration00001CreateTables(),
+		&conditionalTestMigration{newTestMigration00002SeedTabled(),
+			func(ctx context.Context, db *sql.DB, dialect dbkit.Dialect) (bool, error) {
+				require.Equal(t, dbkit.DialectSQLite, dialect)
+				var version string
+				if err := db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version); err != nil {
+					return false, err
+				}
+				return version == "1.0.0", condErr
+			}},
+	}
+
+	condErr = errors.New("version is unknown")
+	err = migMngr.Run(migrations, MigrationsDirectionUp)
+	require.ErrorContains(t, err, "check condition of db migration "+migrations[1].ID()+": version is unknown")
+	condErr = nil
+
+	report, err := migMngr.RunLimitWithReport(migrations, MigrationsDirectionUp, MigrationsNoLimit)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Applied) // The first migration is applied before the condition error.
+	require.Equal(t, migrations[1].ID(), report.Migrations[0].ID)
+	require.True(t, report.Migrations[0].Skipped)
+	requireMigrationsApplied(t, dbConn, false, 0, 0)
+
+	migStatus, err := migMngr.Status()
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 2)
+
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+	requireMigrationsApplied(t, dbConn, true, 0, 0)
+}
+
+func TestMigrationsManager_RunWithReport(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+
+	report, err := migMngr.RunWithReport(migrations, MigrationsDirectionUp)
+	require.NoError(t, err)
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+	require.Equal(t, 2, report.Applied)
+	require.Len(t, report.Migrations, 2)
+	var migrationsDuration time.Duration
+	for i, migReport := range report.Migrations {
+		require.Equal(t, migrations[i].ID(), migReport.ID)
+		migrationsDuration += migReport.Duration
+	}
+	require.GreaterOrEqual(t, report.Elapsed, migrationsDuration)
+	require.Equal(t, []int64{5, 2}, report.Migrations[1].RowsAffected)
+
+	// Nothing to apply, report should be empty.
+	report, err = migMngr.RunWithReport(migrations, MigrationsDirectionUp)
+	require.NoError(t, err)
+	require.Equal(t, 0, report.Applied)
+	require.Empty(t, report.Migrations)
+
+	// Rollback migrations, they should be reported in the reverse order.
+	report, err = migMngr.RunWithReport(migrations, MigrationsDirectionDown)
+	require.NoError(t, err)
+	requireMigrationsApplied(t, dbConn, true, 0, 0)
+	require.Equal(t, 2, report.Applied)
+	require.Equal(t, migrations[1].ID(), report.Migrations[0].ID)
+	require.Equal(t, []int64{5, 2}, report.Migrations[0].RowsAffected)
+	require.Equal(t, migrations[0].ID(), report.Migrations[1].ID)
+}
+
+func TestMigrationsManager_SortFunc(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	// IDs are ordered lexically in the reversed order, so only the custom SortFunc puts tables creation first.
+	migrations := []Migration{
+		NewCustomMigration("a_seed_tables", newTestMigration00002SeedTabled().UpSQL(), newTestMigration00002SeedTabled().DownSQL(), nil, nil),
+		NewCustomMigration("b_create_tables", newTestMigration00001CreateTables().UpSQL(), newTestMigration00001CreateTables().DownSQL(), nil, nil),
+	}
+	order := map[string]int{"b_create_tables": 1, "a_seed_tables": 2}
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(), MigrationsManagerOpts{
+		SortFunc: func(a, b Migration) bool { return order[a.ID()] < order[b.ID()] },
+	})
+	require.NoError(t, err)
+
+	report, err := migMngr.RunWithReport(migrations, MigrationsDirectionUp)
+	require.NoError(t, err)
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+	require.Equal(t, []string{"b_create_tables", "a_seed_tables"}, []string{report.Migrations[0].ID, report.Migrations[1].ID})
+
+	report, err = migMngr.RunWithReport(migrations, MigrationsDirectionDown)
+	require.NoError(t, err)
+	requireMigrationsApplied(t, dbConn, true, 0, 0)
+	require.Equal(t, []string{"a_seed_tables", "b_create_tables"}, []string{report.Migrations[0].ID, report.Migrations[1].ID})
+}
+
+func TestMigrationsManager_TombstoneMigration(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	migrations := []Migration{
+		newTestMigration00001CreateTables(),
+		NewTombstoneMigration("00002_retired_migration"),
+		newTestMigration00002SeedTabled(),
+	}
+
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+	migStatus, err := migMngr.Status()
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 3)
+	require.Equal(t, "00002_retired_migration", migStatus.AppliedMigrations[1].ID)
+
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+	requireMigrationsApplied(t, dbConn, true, 0, 0)
+	migStatus, err = migMngr.Status()
+	require.NoError(t, err)
+	require.Empty(t, migStatus.AppliedMigrations)
+}
+
+func TestMigrationsManager_EventWriter(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	var eventsBuf bytes.Buffer
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(),
+		MigrationsManagerOpts{EventWriter: &eventsBuf})
+	require.NoError(t, err)
+
+	parseEvents := func() []MigrationEvent {
+		var events []MigrationEvent
+		for _, line := range strings.Split(strings.TrimSpace(eventsBuf.String()), "\n") {
+			var event MigrationEvent
+			require.NoError(t, json.Unmarshal([]byte(line), &event))
+			require.GreaterOrEqual(t, event.DurationMs, int64(0))
+			event.DurationMs = 0
+			events = append(events, event)
+		}
+		eventsBuf.Reset()
+		return events
+	}
+
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	require.Equal(t, []MigrationEvent{
+		{ID: migrations[0].ID(), Direction: MigrationsDirectionUp, Status: MigrationEventStatusApplied},
+		{ID: migrations[1].ID(), Direction: MigrationsDirectionUp, Status: MigrationEventStatusApplied},
+	}, parseEvents())
+
+	failedMigration := NewCustomMigration("00003_invalid", []string{"INSERT INTO unknown_table VALUES (1)"}, nil, nil, nil)
+	require.Error(t, migMngr.Run(append(migrations, failedMigration), MigrationsDirectionUp))
+	events := parseEvents()
+	require.Len(t, events, 1)
+	require.Equal(t, failedMigration.ID(), events[0].ID)
+	require.Equal(t, MigrationEventStatusFailed, events[0].Status)
+	require.Contains(t, events[0].Error, "no such table")
+
+	require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionDown, MigrationsNoLimit))
+	require.Equal(t, []MigrationEvent{
+		{ID: migrations[1].ID(), Direction: MigrationsDirectionDown, Status: MigrationEventStatusApplied},
+		{ID: migrations[0].ID(), Direction: MigrationsDirectionDown, Status: MigrationEventStatusApplied},
+	}, parseEvents())
+}
+
+func TestMigrationsManager_Progress(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	dbConn.SetMaxOpenConns(1)
+
+	var events []ProgressEvent
+	logRecorder := logtest.NewRecorder()
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logRecorder, MigrationsManagerOpts{
+		Progress: func(event ProgressEvent) { events = append(events, event) },
+	})
+	require.NoError(t, err)
+
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	require.Equal(t, []ProgressEvent{
+		{Type: ProgressEventTypeStart, Direction: MigrationsDirectionUp, Total: 2},
+		{Type: ProgressEventTypeApplied, Direction: MigrationsDirectionUp, MigrationID: migrations[0].ID(), Index: 1, Total: 2},
+		{Type: ProgressEventTypeApplied, Direction: MigrationsDirectionUp, MigrationID: migrations[1].ID(), Index: 2, Total: 2},
+		{Type: ProgressEventTypeFinished, Direction: MigrationsDirectionUp, Index: 2, Total: 2},
+	}, events)
+	_, found := logRecorder.FindEntry("db migration up succeeded")
+	require.False(t, found)
+
+	events = nil
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	require.Equal(t, []ProgressEvent{
+		{Type: ProgressEventTypeStart, Direction: MigrationsDirectionUp},
+		{Type: ProgressEventTypeFinished, Direction: MigrationsDirectionUp},
+	}, events)
+
+	events = nil
+	failedMigration := NewCustomMigration("00003_invalid", []string{"INSERT INTO unknown_table VALUES (1)"}, nil, nil, nil)
+	require.Error(t, migMngr.Run(append(migrations, failedMigration), MigrationsDirectionUp))
+	require.Equal(t, []ProgressEvent{{Type: ProgressEventTypeStart, Direction: MigrationsDirectionUp, Total: 1}}, events)
+	_, found = logRecorder.FindEntry("db migration failed")
+	require.True(t, found)
+}
+
+func TestMigrationsManager_StatementProgress(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	dbConn.SetMaxOpenConns(1)
+
+	var events []ProgressEvent
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(), MigrationsManagerOpts{
+		Progress:          func(event ProgressEvent) { events = append(events, event) },
+		StatementProgress: true,
+	})
+	require.NoError(t, err)
+
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	for i := range events {
+		require.GreaterOrEqual(t, events[i].Elapsed, time.Duration(0))
+		events[i].Elapsed = 0
+	}
+	stmtEvent := func(migIndex, stmtIndex int) ProgressEvent {
+		return ProgressEvent{Type: ProgressEventTypeStatement, Direction: MigrationsDirectionUp,
+			MigrationID: migrations[migIndex-1].ID(), Index: migIndex, Total: 2, StatementIndex: stmtIndex, StatementTotal: 2}
+	}
+	require.Equal(t, []ProgressEvent{
+		{Type: ProgressEventTypeStart, Direction: MigrationsDirectionUp, Total: 2},
+		stmtEvent(1, 1),
+		stmtEvent(1, 2),
+		{Type: ProgressEventTypeApplied, Direction: MigrationsDirectionUp, MigrationID: migrations[0].ID(), Index: 1, Total: 2},
+		stmtEvent(2, 1),
+		stmtEvent(2, 2),
+		{Type: ProgressEventTypeApplied, Direction: MigrationsDirectionUp, MigrationID: migrations[1].ID(), Index: 2, Total: 2},
+		{Type: ProgressEventTypeFinished, Direction: MigrationsDirectionUp, Index: 2, Total: 2},
+	}, events)
+
+	// Statement progress is logged if the callback is not set.
+	logRecorder := logtest.NewRecorder()
+	migMngr, err = NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logRecorder,
+		MigrationsManagerOpts{StatementProgress: true})
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+	_, found := logRecorder.FindEntry(fmt.Sprintf("db migration %s statement 2 of 2 is executed", migrations[1].ID()))
+	require.True(t, found)
+}
+
+func TestMigrationsManager_ExecMultiStatement(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(),
+		MigrationsManagerOpts{ExecMultiStatement: true})
+	require.NoError(t, err)
+
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+	requireMigrationsApplied(t, dbConn, true, 0, 0)
+
+	require.Equal(t, "CREATE TABLE t1 (id INT);\nCREATE TABLE t2 (id INT);\n",
+		joinStatements([]string{"CREATE TABLE t1 (id INT);", "  ", "CREATE TABLE t2 (id INT)\n"}))
+}
+
+func TestMigrationsManager_RunLimitWithReport_Down(t *testing.T) {
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+	tests := []struct {
+		name             string
+		upLimit          int
+		downLimit        int
+		wantRolledBack   []string
+		wantLogMsg       string
+		wantAppliedAfter int
+	}{
+		{
+			name:       "fresh database",
+			upLimit:    -1,
+			downLimit:  MigrationsNoLimit,
+			wantLogMsg: "no db migrations to roll back",
+		},
+		{
+			name:             "limit is greater than the number of applied migrations",
+			upLimit:          1,
+			downLimit:        2,
+			wantRolledBack:   []string{migrations[0].ID()},
+			wantLogMsg:       "db migration down succeeded",
+			wantAppliedAfter: 0,
+		},
+		{
+			name:             "limit is less than the number of applied migrations",
+			upLimit:          MigrationsNoLimit,
+			downLimit:        1,
+			wantRolledBack:   []string{migrations[1].ID()},
+			wantLogMsg:       "db migration down succeeded",
+			wantAppliedAfter: 1,
+		},
+		{
+			name:           "no limit",
+			upLimit:        MigrationsNoLimit,
+			downLimit:      MigrationsNoLimit,
+			wantRolledBack: []string{migrations[1].ID(), migrations[0].ID()},
+			wantLogMsg:     "db migration down succeeded",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbConn, err := sql.Open("sqlite3", "file::memory:")
+			require.NoError(t, err)
+			defer requireNoErrOnClose(t, dbConn)
+			dbConn.SetMaxOpenConns(1)
+
+			logRecorder := logtest.NewRecorder()
+			migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logRecorder)
+			require.NoError(t, err)
+			if tt.upLimit >= 0 {
+				require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionUp, tt.upLimit))
+			}
+			logRecorder.Reset()
+
+			report, err := migMngr.RunLimitWithReport(migrations, MigrationsDirectionDown, tt.downLimit)
+			require.NoError(t, err)
+			require.Equal(t, len(tt.wantRolledBack), report.Applied)
+			for i, migReport := range report.Migrations {
+				require.Equal(t, tt.wantRolledBack[i], migReport.ID)
+			}
+			_, found := logRecorder.FindEntry(tt.wantLogMsg)
+			require.True(t, found, "log entry %q not found", tt.wantLogMsg)
+
+			migStatus, err := migMngr.Status()
+			require.NoError(t, err)
+			require.Len(t, migStatus.AppliedMigrations, tt.wantAppliedAfter)
+		})
+	}
+}
+
+func TestMigrationsManager_Metrics(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	mc := NewPrometheusMetrics()
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(), MigrationsManagerOpts{Metrics: mc})
+	require.NoError(t, err)
+
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionDown, 1))
+
+	upLabels := prometheus.Labels{PrometheusMetricsLabelDirection: string(MigrationsDirectionUp)}
+	testutil.RequireSamplesCountInHistogram(t, mc.MigrationDurations.With(upLabels).(prometheus.Histogram), 2)
+	require.Equal(t, 2.0, promtestutil.ToFloat64(mc.MigrationsApplied.With(upLabels)))
+	downLabels := prometheus.Labels{PrometheusMetricsLabelDirection: string(MigrationsDirectionDown)}
+	testutil.RequireSamplesCountInHistogram(t, mc.MigrationDurations.With(downLabels).(prometheus.Histogram), 1)
+	require.Equal(t, 1.0, promtestutil.ToFloat64(mc.MigrationsApplied.With(downLabels)))
+}
+
+func TestMigrationsManager_StatementMetrics(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	mc := NewPrometheusMetrics()
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(), MigrationsManagerOpts{Metrics: mc})
+	require.NoError(t, err)
+
+	migrations := []Migration{NewCustomMigration("00001_create_annotated_table", []string{
+		"-- @metric: create_annotated_table\nCREATE TABLE annotated (id INTEGER NOT NULL PRIMARY KEY, name TEXT)",
+		"-- @metric: create_annotated_index\nCREATE INDEX annotated_name_idx ON annotated (name)",
+		"INSERT INTO annotated (id, name) VALUES (1, 'foo')",
+	}, []string{"-- @metric: drop_annotated_table\nDROP TABLE annotated"}, nil, nil)}
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+
+	requireStatementObserved := func(direction MigrationsDirection, name string) {
+		t.Helper()
+		hist := mc.StatementDurations.With(prometheus.Labels{
+			PrometheusMetricsLabelDirection: string(direction),
+			PrometheusMetricsLabelStatement: name,
+		}).(prometheus.Histogram)
+		testutil.RequireSamplesCountInHistogram(t, hist, 1)
+	}
+	requireStatementObserved(MigrationsDirectionUp, "create_annotated_table")
+	requireStatementObserved(MigrationsDirectionUp, "create_annotated_index")
+	requireStatementObserved(MigrationsDirectionDown, "drop_annotated_table")
+	require.Equal(t, 3, promtestutil.CollectAndCount(mc.StatementDurations))
+}
+
+func TestStatementMetricName(t *testing.T) {
+	require.Equal(t, "create_users_index", statementMetricName("-- @metric: create_users_index\nCREATE INDEX ..."))
+	require.Equal(t, "seed", statementMetricName("\n  -- seeds users\n  -- @metric:seed\nINSERT INTO users ..."))
+	require.Equal(t, "", statementMetricName("CREATE TABLE users (id INTEGER)\n-- @metric: not_leading"))
+	require.Equal(t, "", statementMetricName("-- just a comment\nDROP TABLE users"))
+}
+
 func TestMigrationsManager_Status(t *testing.T) {
 	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
 	require.NoError(t, err)
@@ -227,6 +740,158 @@ func TestMigrationsManager_Status(t *testing.T) {
 	require.True(t, exist)
 	require.Equal(t, migrations[len(migrations)-1].ID(), lastAppliedMig.ID)
 	require.WithinDuration(t, time.Now(), lastAppliedMig.AppliedAt, time.Second)
+
+	// Applied time is recorded in UTC.
+	var rawAppliedAt string
+	require.NoError(t, dbConn.QueryRow("SELECT CAST(applied_at AS TEXT) FROM migrations WHERE id = ?", lastAppliedMig.ID).Scan(&rawAppliedAt))
+	require.True(t, strings.HasSuffix(rawAppliedAt, "+00:00"), rawAppliedAt)
+}
+
+func TestMigrationsManager_Inventory(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	dbConn.SetMaxOpenConns(1)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+
+	// Migrations are passed in the reversed order, but they are listed in the order of applying.
+	migrations := []Migration{newTestMigration00002SeedTabled(), newTestMigration00001CreateTables()}
+	items, err := migMngr.Inventory(migrations)
+	require.NoError(t, err)
+	require.Equal(t, []MigrationInventoryItem{
+		{ID: migrations[1].ID(), Status: MigrationInventoryStatusPending},
+		{ID: migrations[0].ID(), Status: MigrationInventoryStatusPending},
+	}, items)
+
+	require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionUp, 1))
+	items, err = migMngr.Inventory(migrations[:1])
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	require.Equal(t, MigrationInventoryItem{ID: migrations[0].ID(), Status: MigrationInventoryStatusPending}, items[0])
+	require.Equal(t, migrations[1].ID(), items[1].ID)
+	require.Equal(t, MigrationInventoryStatusOrphaned, items[1].Status)
+	require.True(t, items[1].Applied)
+	require.WithinDuration(t, time.Now(), items[1].AppliedAt, time.Second)
+
+	items, err = migMngr.Inventory(migrations)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	require.Equal(t, MigrationInventoryStatusApplied, items[0].Status)
+	require.True(t, items[0].Applied)
+	require.False(t, items[0].AppliedAt.IsZero())
+	require.Equal(t, MigrationInventoryItem{ID: migrations[0].ID(), Status: MigrationInventoryStatusPending}, items[1])
+
+	_, err = migMngr.Inventory([]Migration{NewCustomMigration("", nil, nil, nil, nil)})
+	require.EqualError(t, err, "migration #1 has empty ID")
+}
+
+func TestMigrationsManager_AssertNoPendingMigrations(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	dbConn.SetMaxOpenConns(1)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+
+	err = migMngr.AssertNoPendingMigrations(migrations)
+	require.ErrorIs(t, err, ErrPendingMigrations)
+	require.EqualError(t, err, "pending db migrations: "+migrations[0].ID()+", "+migrations[1].ID())
+
+	// The check is read-only, the migrations table is not created.
+	var tablesCount int
+	require.NoError(t, dbConn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tablesCount))
+	require.Zero(t, tablesCount)
+
+	require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionUp, 1))
+	require.EqualError(t, migMngr.AssertNoPendingMigrations(migrations), "pending db migrations: "+migrations[1].ID())
+
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	require.NoError(t, migMngr.AssertNoPendingMigrations(migrations))
+
+	// Orphaned migrations are not pending.
+	require.NoError(t, migMngr.AssertNoPendingMigrations(migrations[:1]))
+}
+
+func TestMigrationsManager_NullAppliedAt(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	dbConn.SetMaxOpenConns(1)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+	require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionUp, 1))
+
+	// Another tool may record migrations without applied_at.
+	_, err = dbConn.Exec("UPDATE " + MigrationsTableName + " SET applied_at = NULL")
+	require.NoError(t, err)
+
+	migStatus, err := migMngr.Status()
+	require.NoError(t, err)
+	require.Equal(t, []AppliedMigration{{ID: migrations[0].ID()}}, migStatus.AppliedMigrations)
+
+	items, err := migMngr.Inventory(migrations)
+	require.NoError(t, err)
+	require.Equal(t, []MigrationInventoryItem{
+		{ID: migrations[0].ID(), Status: MigrationInventoryStatusApplied, Applied: true},
+		{ID: migrations[1].ID(), Status: MigrationInventoryStatusPending},
+	}, items)
+
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+	// NULL applied_at is kept on importing the legacy state.
+	_, err = dbConn.Exec("CREATE TABLE legacy_migrations (id VARCHAR(255) PRIMARY KEY, applied_at DATETIME)")
+	require.NoError(t, err)
+	_, err = dbConn.Exec("INSERT INTO legacy_migrations (id, applied_at) VALUES ('00000_legacy', NULL)")
+	require.NoError(t, err)
+	imported, err := migMngr.ImportLegacyState("legacy_migrations")
+	require.NoError(t, err)
+	require.Equal(t, 1, imported)
+	migStatus, err = migMngr.Status()
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 3)
+	require.Equal(t, AppliedMigration{ID: "00000_legacy"}, migStatus.AppliedMigrations[0])
+}
+
+func TestMigrationsManager_StatusCached(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	otherMigMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+
+	migStatus, err := migMngr.StatusCached(time.Hour)
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 0)
+
+	// Migrations applied by another manager are not visible until TTL expires.
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+	require.NoError(t, otherMigMngr.RunLimit(migrations, MigrationsDirectionUp, 1))
+	migStatus, err = migMngr.StatusCached(time.Hour)
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 0)
+	migStatus, err = migMngr.StatusCached(0)
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 1)
+
+	// Cache is invalidated when migrations are applied or rolled back by the same manager.
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	migStatus, err = migMngr.StatusCached(time.Hour)
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 2)
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+	migStatus, err = migMngr.StatusCached(time.Hour)
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 0)
 }
 
 func TestCreationMigrationManagerWithOpts(t *testing.T) {
@@ -257,6 +922,215 @@ func TestCreationMigrationManagerWithOpts(t *testing.T) {
 	require.Equal(t, 0, rowsNum)
 }
 
+func TestNewMigrationsManagerWithOpts_TooLongTableName(t *testing.T) {
+	tableName := strings.Repeat("m", dbkit.PostgresMaxIdentifierLength+1)
+
+	_, err := NewMigrationsManagerWithOpts(nil, dbkit.DialectPostgres, logtest.NewLogger(), MigrationsManagerOpts{TableName: tableName})
+	require.ErrorContains(t, err, "too long")
+
+	_, err = NewMigrationsManagerWithOpts(nil, dbkit.DialectMySQL, logtest.NewLogger(), MigrationsManagerOpts{TableName: tableName})
+	require.NoError(t, err)
+}
+
+func TestNewMigrationsManagerWithOpts_TableSchema(t *testing.T) {
+	tooLongSchema := strings.Repeat("s", dbkit.PostgresMaxIdentifierLength+1)
+	_, err := NewMigrationsManagerWithOpts(nil, dbkit.DialectPgx, logtest.NewLogger(), MigrationsManagerOpts{TableSchema: tooLongSchema})
+	require.ErrorContains(t, err, "too long")
+
+	_, err = NewMigrationsManagerWithOpts(nil, dbkit.DialectSQLite, logtest.NewLogger(), MigrationsManagerOpts{TableSchema: "app"})
+	require.EqualError(t, err, "migrations table schema is not supported for sqlite3 dialect")
+
+	migMngr, err := NewMigrationsManagerWithOpts(nil, dbkit.DialectPostgres, logtest.NewLogger(), MigrationsManagerOpts{TableSchema: "app"})
+	require.NoError(t, err)
+	dbMap, err := migMngr.migrationsDBMap()
+	require.NoError(t, err)
+	tableMap, err := dbMap.TableFor(reflect.TypeOf(migrate.MigrationRecord{}), false)
+	require.NoError(t, err)
+	require.Contains(t, tableMap.SqlForCreate(true), `create table if not exists "app"."migrations"`)
+}
+
+func TestMigrationsManager_IncompatibleTable(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	dbConn.SetMaxOpenConns(1)
+
+	// Table of another migration tool.
+	_, err = dbConn.Exec("CREATE TABLE migrations (version INTEGER PRIMARY KEY, dirty BOOLEAN)")
+	require.NoError(t, err)
+
+	migrations := []Migration{newTestMigration00001CreateTables()}
+	for _, filterApplied := range []bool{false, true} {
+		migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(),
+			MigrationsManagerOpts{FilterAppliedMigrations: filterApplied})
+		require.NoError(t, err)
+		err = migMngr.Run(migrations, MigrationsDirectionUp)
+		require.ErrorIs(t, err, ErrIncompatibleMigrationsTable)
+		require.ErrorContains(t, err, "table migrations has columns version, dirty, but id, applied_at are missing")
+	}
+
+	// Other errors are returned as is.
+	_, err = dbConn.Exec("DROP TABLE migrations")
+	require.NoError(t, err)
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	require.NoError(t, dbConn.Close())
+	_, err = migMngr.Status()
+	require.NotErrorIs(t, err, ErrIncompatibleMigrationsTable)
+	require.ErrorContains(t, err, "database is closed")
+}
+
+func TestMigrationsManager_DisableTableCreation(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	dbConn.SetMaxOpenConns(1)
+
+	migrations := []Migration{newTestMigration00001CreateTables()}
+	readOnlyMigMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(),
+		MigrationsManagerOpts{DisableTableCreation: true})
+	require.NoError(t, err)
+
+	_, err = readOnlyMigMngr.Status()
+	require.ErrorIs(t, err, ErrMigrationsTableNotExist)
+	_, err = readOnlyMigMngr.Inventory(migrations)
+	require.ErrorIs(t, err, ErrMigrationsTableNotExist)
+	require.ErrorIs(t, readOnlyMigMngr.AssertNoPendingMigrations(migrations), ErrPendingMigrations)
+	require.ErrorIs(t, readOnlyMigMngr.Run(migrations, MigrationsDirectionUp), ErrMigrationsTableNotExist)
+
+	var tablesCount int
+	require.NoError(t, dbConn.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'").Scan(&tablesCount))
+	require.Zero(t, tablesCount)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+
+	migStatus, err := readOnlyMigMngr.Status()
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 1)
+	require.NoError(t, readOnlyMigMngr.AssertNoPendingMigrations(migrations))
+}
+
+func TestMigrationsManager_MixedCaseTableName(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	dbConn.SetMaxOpenConns(1)
+
+	runMixedCaseTableNameTest(t, dbConn, dbkit.DialectSQLite)
+
+	// Postgres folds unquoted identifiers to lowercase, so the table name must be always quoted.
+	migMngr, err := NewMigrationsManagerWithOpts(nil, dbkit.DialectPgx, logtest.NewLogger(),
+		MigrationsManagerOpts{TableName: "MyMigrations", TableSchema: "MySchema"})
+	require.NoError(t, err)
+	dbMap, err := migMngr.migrationsDBMap()
+	require.NoError(t, err)
+	tableMap, err := dbMap.TableFor(reflect.TypeOf(migrate.MigrationRecord{}), false)
+	require.NoError(t, err)
+	require.Contains(t, tableMap.SqlForCreate(true), `"MySchema"."MyMigrations"`)
+}
+
+func TestMigrationsManager_MixedCaseTableName_Postgres(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*2)
+	defer cancel()
+
+	dbConn, stop := dbtesting.MustRunAndOpenTestDB(ctx, string(dbkit.DialectPostgres))
+	defer func() { require.NoError(t, stop(ctx)) }()
+
+	runMixedCaseTableNameTest(t, dbConn, dbkit.DialectPostgres)
+
+	// The table name is quoted, so it's not folded to lowercase.
+	var tableNames []string
+	rows, err := dbConn.Query("SELECT table_name FROM information_schema.tables " +
+		"WHERE table_schema = CURRENT_SCHEMA() AND lower(table_name) = 'mymigrations'")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, rows.Close()) }()
+	for rows.Next() {
+		var tableName string
+		require.NoError(t, rows.Scan(&tableName))
+		tableNames = append(tableNames, tableName)
+	}
+	require.NoError(t, rows.Err())
+	require.Equal(t, []string{"MyMigrations"}, tableNames)
+}
+
+func runMixedCaseTableNameTest(t *testing.T, dbConn *sql.DB, dialect dbkit.Dialect) {
+	t.Helper()
+
+	const tableName = "MyMigrations"
+	migrations := []Migration{
+		NewCustomMigration("00001_create_users", []string{"CREATE TABLE users (id INT)"}, []string{"DROP TABLE users"}, nil, nil),
+		NewCustomMigration("00002_create_notes", []string{"CREATE TABLE notes (id INT)"}, []string{"DROP TABLE notes"}, nil, nil),
+	}
+	newManager := func(opts MigrationsManagerOpts) *MigrationsManager {
+		opts.TableName = tableName
+		migMngr, err := NewMigrationsManagerWithOpts(dbConn, dialect, logtest.NewLogger(), opts)
+		require.NoError(t, err)
+		return migMngr
+	}
+
+	migMngr := newManager(MigrationsManagerOpts{})
+	require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionUp, 1))
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+
+	// Table existence is checked in the database catalog with the same name.
+	readOnlyMigMngr := newManager(MigrationsManagerOpts{DisableTableCreation: true})
+	migStatus, err := readOnlyMigMngr.Status()
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 2)
+	require.NoError(t, readOnlyMigMngr.AssertNoPendingMigrations(migrations))
+
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+	migStatus, err = migMngr.Status()
+	require.NoError(t, err)
+	require.Empty(t, migStatus.AppliedMigrations)
+
+	n, err := migMngr.MarkApplied([]Migration{
+		NewCustomMigration("00003_baseline", []string{"DROP TABLE users"}, nil, nil, nil)})
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	migStatus, err = readOnlyMigMngr.Status()
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 1)
+	require.Equal(t, "00003_baseline", migStatus.AppliedMigrations[0].ID)
+}
+
+func TestMigrationsManagerOpts_GoquDialect(t *testing.T) {
+	selectSQL := func(opts MigrationsManagerOpts) string {
+		migMngr, err := NewMigrationsManagerWithOpts(nil, dbkit.DialectPgx, logtest.NewLogger(), opts)
+		require.NoError(t, err)
+		query, _, err := migMngr.goquDialect().From("migrations").Select("id").Where(goqu.C("id").Eq("1")).Prepared(true).ToSQL()
+		require.NoError(t, err)
+		return query
+	}
+	require.Equal(t, `SELECT "id" FROM "migrations" WHERE ("id" = $1)`, selectSQL(MigrationsManagerOpts{}))
+	require.Equal(t, "SELECT `id` FROM `migrations` WHERE (`id` = ?)", selectSQL(MigrationsManagerOpts{GoquDialect: "mysql"}))
+}
+
+func TestNewMigrationsManagerWithOpts_IDColumnLength(t *testing.T) {
+	_, err := NewMigrationsManagerWithOpts(nil, dbkit.DialectMySQL, logtest.NewLogger(), MigrationsManagerOpts{IDColumnLength: 256})
+	require.EqualError(t, err, "invalid migration ID column length 256 for mysql dialect")
+	_, err = NewMigrationsManagerWithOpts(nil, dbkit.DialectSQLite, logtest.NewLogger(), MigrationsManagerOpts{IDColumnLength: -1})
+	require.EqualError(t, err, "invalid migration ID column length -1 for sqlite3 dialect")
+
+	createTableSQL := func(dialect dbkit.Dialect, idColumnLength int) string {
+		migMngr, err := NewMigrationsManagerWithOpts(nil, dialect, logtest.NewLogger(),
+			MigrationsManagerOpts{IDColumnLength: idColumnLength})
+		require.NoError(t, err)
+		dbMap, err := migMngr.migrationsDBMap()
+		require.NoError(t, err)
+		tableMap, err := dbMap.TableFor(reflect.TypeOf(migrate.MigrationRecord{}), false)
+		require.NoError(t, err)
+		return tableMap.SqlForCreate(true)
+	}
+	require.Contains(t, createTableSQL(dbkit.DialectMySQL, 0), "`id` varchar(255) not null primary key")
+	require.Contains(t, createTableSQL(dbkit.DialectMySQL, MySQLSafeIDColumnLength), "`id` varchar(191) not null primary key")
+	require.Contains(t, createTableSQL(dbkit.DialectPostgres, 0), `"id" text not null primary key`)
+	require.Contains(t, createTableSQL(dbkit.DialectPostgres, 100), `"id" varchar(100) not null primary key`)
+}
+
 func requireNoErrOnClose(t *testing.T, closer io.Closer) {
 	t.Helper()
 	require.NoError(t, closer.Close())
@@ -317,6 +1191,7 @@ func TestMigrationsManager_supportRawMigration(t *testing.T) {
 //go:embed testdata/missing-down-file/*.sql
 //go:embed testdata/missing-up-file/*.sql
 //go:embed testdata/invalid-suffix/*.sql
+//go:embed testdata/crlf/*.sql
 var testFS embed.FS
 
 func TestAllLoadEmbedFSMigrations(t *testing.T) {
@@ -475,3 +1350,834 @@ func TestLoadEmbedFSMigrations(t *testing.T) {
 		})
 	}
 }
+
+func TestNewMapMigrations(t *testing.T) {
+	migrations := NewMapMigrations(map[string]MigrationSQL{
+		"0002_create_notes_table": {Up: "CREATE TABLE notes (id INTEGER PRIMARY KEY);", Down: "DROP TABLE notes;"},
+		"0001_create_users_table": {Up: "CREATE TABLE users (id INTEGER PRIMARY KEY);\r\n", Down: "DROP TABLE users;"},
+	})
+	require.Len(t, migrations, 2)
+	require.Equal(t, "0001_create_users_table", migrations[0].ID())
+	require.Equal(t, []string{"CREATE TABLE users (id INTEGER PRIMARY KEY);\n"}, migrations[0].UpSQL())
+	require.Equal(t, "0002_create_notes_table", migrations[1].ID())
+	require.Equal(t, []string{"DROP TABLE notes;"}, migrations[1].DownSQL())
+
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	dbConn.SetMaxOpenConns(1)
+
+	migManager, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	require.NoError(t, migManager.Run(migrations, MigrationsDirectionUp))
+	migStatus, err := migManager.Status()
+	require.NoError(t, err)
+	require.Len(t, migStatus.AppliedMigrations, 2)
+	require.NoError(t, migManager.Run(migrations, MigrationsDirectionDown))
+}
+
+func TestLoadAllEmbedFSMigrations_CRLF(t *testing.T) {
+	migrations, err := LoadAllEmbedFSMigrations(testFS, "testdata/crlf")
+	require.NoError(t, err)
+	require.Len(t, migrations, 1)
+	for _, sqlData := range append(migrations[0].UpSQL(), migrations[0].DownSQL()...) {
+		require.NotContains(t, sqlData, "\r")
+	}
+
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	migManager, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	require.NoError(t, migManager.Run(migrations, MigrationsDirectionUp))
+	var usersCount int
+	require.NoError(t, dbConn.QueryRow("select count(*) from users").Scan(&usersCount))
+	require.NoError(t, migManager.Run(migrations, MigrationsDirectionDown))
+}
+
+func TestFingerprint(t *testing.T) {
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+	fingerprint := Fingerprint(migrations)
+	require.Len(t, fingerprint, 64)
+
+	// The order of migrations doesn't matter.
+	require.Equal(t, fingerprint, Fingerprint([]Migration{migrations[1], migrations[0]}))
+
+	// Any change of ID or SQL statements changes the fingerprint.
+	require.NotEqual(t, fingerprint, Fingerprint(migrations[:1]))
+	changedMigration := NewCustomMigration(migrations[1].ID(), migrations[1].UpSQL(), []string{"DELETE FROM users"}, nil, nil)
+	require.NotEqual(t, fingerprint, Fingerprint([]Migration{migrations[0], changedMigration}))
+	require.NotEqual(t,
+		Fingerprint([]Migration{NewCustomMigration("0001", []string{"a", "b"}, nil, nil, nil)}),
+		Fingerprint([]Migration{NewCustomMigration("0001", []string{"ab"}, nil, nil, nil)}))
+}
+
+func TestMigrationsManager_isIgnorableError(t *testing.T) {
+	migManager, err := NewMigrationsManagerWithOpts(nil, dbkit.DialectPostgres, logtest.NewLogger(), MigrationsManagerOpts{
+		IgnoreErrorCodes: []string{"42P07", "1050", "2714"},
+	})
+	require.NoError(t, err)
+
+	require.True(t, migManager.isIgnorableError(fmt.Errorf("wrapped: %w", &pq.Error{Code: "42P07"})))
+	require.True(t, migManager.isIgnorableError(&pgconn.PgError{Code: "42P07"}))
+	require.True(t, migManager.isIgnorableError(&mysql.MySQLError{Number: 1050}))
+	require.True(t, migManager.isIgnorableError(mssql.Error{Number: 2714}))
+
+	require.False(t, migManager.isIgnorableError(&pq.Error{Code: "23505"}))
+	require.False(t, migManager.isIgnorableError(&mysql.MySQLError{Number: 1062}))
+	require.False(t, migManager.isIgnorableError(fmt.Errorf("42P07")))
+
+	// Error codes are not ignored by default.
+	migManager, err = NewMigrationsManager(nil, dbkit.DialectPostgres, logtest.NewLogger())
+	require.NoError(t, err)
+	require.False(t, migManager.isIgnorableError(&pq.Error{Code: "42P07"}))
+}
+
+type testDirectionalTxMigration struct {
+	*CustomMigration
+}
+
+func (m *testDirectionalTxMigration) DisableTx() bool {
+	return false
+}
+
+func (m *testDirectionalTxMigration) DisableTxForDirection(direction MigrationsDirection) bool {
+	return direction == MigrationsDirectionUp
+}
+
+func TestConvertMigration_DisableTx(t *testing.T) {
+	rawMig, err := convertMigration(newTestMigration00004NoTransaction())
+	require.NoError(t, err)
+	require.True(t, rawMig.DisableTransactionUp)
+	require.True(t, rawMig.DisableTransactionDown)
+
+	rawMig, err = convertMigration(&testDirectionalTxMigration{NewCustomMigration(
+		"00001_create_index_concurrently",
+		[]string{"CREATE INDEX CONCURRENTLY idx_users_name ON users(name)"},
+		[]string{"DROP INDEX idx_users_name"}, nil, nil)})
+	require.NoError(t, err)
+	require.True(t, rawMig.DisableTransactionUp)
+	require.False(t, rawMig.DisableTransactionDown)
+}
+
+func TestBackfillInBatches(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	_, err = dbConn.Exec(`CREATE TABLE backfill_users (id INTEGER PRIMARY KEY, status TEXT)`)
+	require.NoError(t, err)
+	defer func() {
+		_, dropErr := dbConn.Exec(`DROP TABLE backfill_users`)
+		require.NoError(t, dropErr)
+	}()
+	for i := 0; i < 10; i++ {
+		_, err = dbConn.Exec(`INSERT INTO backfill_users(status) VALUES (NULL)`)
+		require.NoError(t, err)
+	}
+
+	const backfillQuery = `UPDATE backfill_users SET status = ? WHERE id IN (SELECT id FROM backfill_users WHERE status IS NULL LIMIT ?)`
+	require.NoError(t, BackfillInBatches(context.Background(), dbConn, backfillQuery, 3, "active"))
+	var activeCount int
+	require.NoError(t, dbConn.QueryRow(`SELECT COUNT(*) FROM backfill_users WHERE status = 'active'`).Scan(&activeCount))
+	require.Equal(t, 10, activeCount)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, BackfillInBatches(ctx, dbConn, backfillQuery, 3, "active"), context.Canceled)
+
+	require.EqualError(t, BackfillInBatches(context.Background(), dbConn, backfillQuery, 0, "active"),
+		"batch size should be positive, got 0")
+}
+
+func TestMigrationsManager_ExpectedDatabaseName(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "app.db"))
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(), MigrationsManagerOpts{
+		ExpectedDatabaseName: "analytics.db",
+	})
+	require.NoError(t, err)
+	require.EqualError(t, migMngr.Run(migrations, MigrationsDirectionUp),
+		`connected to database "app.db", but migrations are expected to be run on "analytics.db"`)
+	requireMigrationsApplied(t, dbConn, true, 0, 0)
+
+	migMngr, err = NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(), MigrationsManagerOpts{
+		ExpectedDatabaseName: "app.db",
+	})
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+	// In-memory database has no file name, so it's not checked.
+	memDBConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, memDBConn)
+	memDBConn.SetMaxOpenConns(1)
+	migMngr, err = NewMigrationsManagerWithOpts(memDBConn, dbkit.DialectSQLite, logtest.NewLogger(), MigrationsManagerOpts{
+		ExpectedDatabaseName: "app.db",
+	})
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	requireMigrationsApplied(t, memDBConn, false, 5, 2)
+}
+
+func TestMigrationsManager_StatementRewriter(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+
+	upSQL := []string{`CREATE TABLE rewritten (id INTEGER PRIMARY KEY {{AUTOINCREMENT}}, name TEXT)`}
+	migrations := []Migration{NewCustomMigration("00001_create_rewritten_table", upSQL, []string{`DROP TABLE rewritten`}, nil, nil)}
+	var rewriterDialects []dbkit.Dialect
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(), MigrationsManagerOpts{
+		StatementRewriter: func(dialect dbkit.Dialect, stmt string) string {
+			rewriterDialects = append(rewriterDialects, dialect)
+			if dialect == dbkit.DialectMySQL {
+				return strings.ReplaceAll(stmt, "{{AUTOINCREMENT}}", "AUTO_INCREMENT")
+			}
+			return strings.ReplaceAll(stmt, "{{AUTOINCREMENT}}", "AUTOINCREMENT")
+		},
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	_, err = dbConn.Exec(`INSERT INTO rewritten(name) VALUES ("Albert")`)
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+	require.Equal(t, []dbkit.Dialect{dbkit.DialectSQLite, dbkit.DialectSQLite}, rewriterDialects)
+
+	// Migration's statements are not modified.
+	require.Equal(t, `CREATE TABLE rewritten (id INTEGER PRIMARY KEY {{AUTOINCREMENT}}, name TEXT)`, migrations[0].UpSQL()[0])
+}
+
+func TestMigrationsManager_RenderMigration(t *testing.T) {
+	migration := NewCustomMigration("00001_create_users",
+		[]string{"CREATE TABLE users (id INTEGER PRIMARY KEY {{AUTOINCREMENT}});", "CREATE INDEX idx_users_id ON users(id);\n"},
+		[]string{"DROP TABLE users;"}, nil, nil)
+	migMngr, err := NewMigrationsManagerWithOpts(nil, dbkit.DialectMySQL, logtest.NewLogger(), MigrationsManagerOpts{
+		StatementRewriter: func(dialect dbkit.Dialect, stmt string) string {
+			return strings.ReplaceAll(stmt, "{{AUTOINCREMENT}}", "AUTO_INCREMENT")
+		},
+	})
+	require.NoError(t, err)
+
+	statements, err := migMngr.RenderMigration(migration, MigrationsDirectionUp)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY AUTO_INCREMENT)",
+		"CREATE INDEX idx_users_id ON users(id)",
+	}, statements)
+
+	statements, err = migMngr.RenderMigration(migration, MigrationsDirectionDown)
+	require.NoError(t, err)
+	require.Equal(t, []string{"DROP TABLE users"}, statements)
+
+	_, err = migMngr.RenderMigration(migration, "sideways")
+	require.EqualError(t, err, `unknown direction "sideways"`)
+}
+
+func TestAddExistenceGuards(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect dbkit.Dialect
+		stmt    string
+		want    string
+	}{
+		{"pg create table", dbkit.DialectPostgres, "CREATE TABLE users (id INT)", "CREATE TABLE IF NOT EXISTS users (id INT)"},
+		{"pgx create table", dbkit.DialectPgx, "create unlogged table users (id INT)", "create unlogged table IF NOT EXISTS users (id INT)"},
+		{"pg create index", dbkit.DialectPostgres,
+			"CREATE UNIQUE INDEX CONCURRENTLY idx_users ON users(id)", "CREATE UNIQUE INDEX CONCURRENTLY IF NOT EXISTS idx_users ON users(id)"},
+		{"pg unnamed index", dbkit.DialectPostgres, "CREATE INDEX ON users(id)", "CREATE INDEX ON users(id)"},
+		{"pg drop index", dbkit.DialectPostgres, "DROP INDEX idx_users", "DROP INDEX IF EXISTS idx_users"},
+		{"pg alter table", dbkit.DialectPostgres,
+			"ALTER TABLE users ADD COLUMN name TEXT, ADD COLUMN IF NOT EXISTS age INT, DROP COLUMN email",
+			"ALTER TABLE users ADD COLUMN IF NOT EXISTS name TEXT, ADD COLUMN IF NOT EXISTS age INT, DROP COLUMN IF EXISTS email"},
+		{"pg commented create table", dbkit.DialectPostgres,
+			"-- users\nCREATE TABLE users (id INT)", "-- users\nCREATE TABLE users (id INT)"},
+		{"pg commented alter table", dbkit.DialectPostgres,
+			"/* add name */ ALTER TABLE users ADD COLUMN name TEXT", "/* add name */ ALTER TABLE users ADD COLUMN IF NOT EXISTS name TEXT"},
+		{"pg insert", dbkit.DialectPostgres,
+			"INSERT INTO notes (text) VALUES ('ADD COLUMN x')", "INSERT INTO notes (text) VALUES ('ADD COLUMN x')"},
+		{"already guarded", dbkit.DialectSQLite, "DROP TABLE IF EXISTS users", "DROP TABLE IF EXISTS users"},
+		{"sqlite create index", dbkit.DialectSQLite, "CREATE INDEX idx_users ON users(id)", "CREATE INDEX IF NOT EXISTS idx_users ON users(id)"},
+		{"sqlite alter table", dbkit.DialectSQLite, "ALTER TABLE users ADD COLUMN name TEXT", "ALTER TABLE users ADD COLUMN name TEXT"},
+		{"mysql drop table", dbkit.DialectMySQL, "DROP TABLE users", "DROP TABLE IF EXISTS users"},
+		{"mysql create index", dbkit.DialectMySQL, "CREATE INDEX idx_users ON users(id)", "CREATE INDEX idx_users ON users(id)"},
+		{"mssql create table", dbkit.DialectMSSQL, "CREATE TABLE users (id INT)", "CREATE TABLE users (id INT)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, AddExistenceGuards(tt.dialect, tt.stmt))
+		})
+	}
+}
+
+func TestMigrationsManager_ExistenceGuards(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	dbConn.SetMaxOpenConns(1)
+
+	// Tables are created, but the migration is not recorded (e.g. the process crashed in the middle).
+	_, err = dbConn.Exec("CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)")
+	require.NoError(t, err)
+
+	migration := NewCustomMigration("00001_create_users",
+		[]string{"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)", "CREATE INDEX idx_users_name ON users(name)"},
+		[]string{"DROP INDEX idx_users_name", "DROP TABLE users"}, nil, nil)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	require.ErrorContains(t, migMngr.Run([]Migration{migration}, MigrationsDirectionUp), "already exists")
+
+	migMngr, err = NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(),
+		MigrationsManagerOpts{ExistenceGuards: true})
+	require.NoError(t, err)
+	require.NoError(t, migMngr.Run([]Migration{migration}, MigrationsDirectionUp))
+
+	statements, err := migMngr.RenderMigration(migration, MigrationsDirectionDown)
+	require.NoError(t, err)
+	require.Equal(t, []string{"DROP INDEX IF EXISTS idx_users_name", "DROP TABLE IF EXISTS users"}, statements)
+	require.NoError(t, migMngr.Run([]Migration{migration}, MigrationsDirectionDown))
+}
+
+func TestMigrationsManager_loadData(t *testing.T) {
+	data := MigrationData{Table: "countries", Columns: []string{"code", "name"},
+		Rows: [][]string{{"US", "United States"}, {"DE", "Germany"}}}
+
+	loadInTx := func(t *testing.T, dialect dbkit.Dialect, setExpectations func(mock sqlmock.Sqlmock)) {
+		t.Helper()
+		dbConn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+		require.NoError(t, err)
+		defer func() { _ = dbConn.Close() }()
+
+		migMngr, err := NewMigrationsManager(dbConn, dialect, logtest.NewLogger())
+		require.NoError(t, err)
+		dbMap, err := migMngr.migrationsDBMap()
+		require.NoError(t, err)
+
+		mock.ExpectBegin()
+		setExpectations(mock)
+		mock.ExpectCommit()
+
+		tx, err := dbMap.Begin()
+		require.NoError(t, err)
+		loaded, err := migMngr.loadData(data, tx, dbMap)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), loaded)
+		require.NoError(t, tx.Commit())
+		require.NoError(t, mock.ExpectationsWereMet())
+	}
+
+	t.Run("lib/pq driver uses COPY", func(t *testing.T) {
+		loadInTx(t, dbkit.DialectPostgres, func(mock sqlmock.Sqlmock) {
+			prep := mock.ExpectPrepare(pq.CopyIn("countries", "code", "name"))
+			prep.ExpectExec().WithArgs("US", "United States").WillReturnResult(sqlmock.NewResult(0, 1))
+			prep.ExpectExec().WithArgs("DE", "Germany").WillReturnResult(sqlmock.NewResult(0, 1))
+			prep.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 0))
+		})
+	})
+
+	t.Run("pgx driver uses multi-row INSERT", func(t *testing.T) {
+		loadInTx(t, dbkit.DialectPgx, func(mock sqlmock.Sqlmock) {
+			mock.ExpectExec(`INSERT INTO "countries" ("code", "name") VALUES ($1, $2), ($3, $4)`).
+				WithArgs("US", "United States", "DE", "Germany").WillReturnResult(sqlmock.NewResult(0, 2))
+		})
+	})
+}
+
+func TestCopyDataMigration(t *testing.T) {
+	var csvContent strings.Builder
+	csvContent.WriteString("code,name\n")
+	for i := 1; i <= 1200; i++ {
+		fmt.Fprintf(&csvContent, "c%d,\"Country, #%d\"\n", i, i)
+	}
+	fsys := fstest.MapFS{
+		"countries.csv":     {Data: []byte(csvContent.String())},
+		"countries.tsv":     {Data: []byte("code\tname\nc0\tCountry \"0\"\n")},
+		"invalid.csv":       {Data: []byte("name,code\nc1,Country 1\n")},
+		"empty.csv":         {Data: nil},
+		"wrong-columns.csv": {Data: []byte("code,name\nc1\n")},
+	}
+	openData := func(name string) fs.File {
+		f, err := fsys.Open(name)
+		require.NoError(t, err)
+		return f
+	}
+
+	t.Run("load data", func(t *testing.T) {
+		dbConn, err := sql.Open("sqlite3", "file::memory:")
+		require.NoError(t, err)
+		defer requireNoErrOnClose(t, dbConn)
+		dbConn.SetMaxOpenConns(1)
+
+		dataMigration, err := CopyDataMigration("00002_seed_countries", "countries", []string{"code", "name"},
+			openData("countries.csv"))
+		require.NoError(t, err)
+		tsvMigration, err := CopyDataMigration("00003_seed_more_countries", "countries", []string{"code", "name"},
+			openData("countries.tsv"))
+		require.NoError(t, err)
+		migrations := []Migration{
+			NewCustomMigration("00001_create_countries",
+				[]string{"CREATE TABLE countries (code TEXT PRIMARY KEY, name TEXT NOT NULL)"}, []string{"DROP TABLE countries"}, nil, nil),
+			dataMigration,
+			tsvMigration,
+		}
+
+		migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+		require.NoError(t, err)
+		report, err := migMngr.RunLimitWithReport(migrations, MigrationsDirectionUp, MigrationsNoLimit)
+		require.NoError(t, err)
+		require.Equal(t, []int64{1200}, report.Migrations[1].RowsAffected)
+		require.Equal(t, []int64{1}, report.Migrations[2].RowsAffected)
+
+		var count int
+		require.NoError(t, dbConn.QueryRow("SELECT COUNT(*) FROM countries").Scan(&count))
+		require.Equal(t, 1201, count)
+		var name string
+		require.NoError(t, dbConn.QueryRow("SELECT name FROM countries WHERE code = 'c1200'").Scan(&name))
+		require.Equal(t, "Country, #1200", name)
+		require.NoError(t, dbConn.QueryRow("SELECT name FROM countries WHERE code = 'c0'").Scan(&name))
+		require.Equal(t, `Country "0"`, name)
+
+		// Data migrations are rolled back without doing anything.
+		require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionDown, 2))
+		require.NoError(t, dbConn.QueryRow("SELECT COUNT(*) FROM countries").Scan(&count))
+		require.Equal(t, 1201, count)
+	})
+
+	t.Run("invalid content", func(t *testing.T) {
+		_, err := CopyDataMigration("00002_seed", "countries", []string{"code", "name"}, openData("invalid.csv"))
+		require.EqualError(t, err, `data migration 00002_seed header column #1 is "name", expected "code"`)
+		_, err = CopyDataMigration("00002_seed", "countries", []string{"code", "name"}, openData("empty.csv"))
+		require.EqualError(t, err, "data migration 00002_seed has no header")
+		_, err = CopyDataMigration("00002_seed", "countries", []string{"code", "name"}, openData("wrong-columns.csv"))
+		require.ErrorIs(t, err, csv.ErrFieldCount)
+		_, err = CopyDataMigration("00002_seed", "countries", nil, openData("countries.csv"))
+		require.EqualError(t, err, "data migration 00002_seed has no columns")
+	})
+}
+
+func TestMigrationsManager_ImportLegacyState(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "legacy.db"))
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+
+	// Apply the first migration with sql-migrate using its default table.
+	legacyMigSet := migrate.MigrationSet{}
+	rawMig, err := convertMigration(migrations[0])
+	require.NoError(t, err)
+	_, err = legacyMigSet.Exec(dbConn, string(dbkit.DialectSQLite),
+		&migrate.MemoryMigrationSource{Migrations: []*migrate.Migration{rawMig}}, migrate.Up)
+	require.NoError(t, err)
+
+	migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	imported, err := migMngr.ImportLegacyState("gorp_migrations")
+	require.NoError(t, err)
+	require.Equal(t, 1, imported)
+
+	// Already recorded migrations are skipped.
+	imported, err = migMngr.ImportLegacyState("gorp_migrations")
+	require.NoError(t, err)
+	require.Equal(t, 0, imported)
+
+	// Only the second migration should be applied.
+	report, err := migMngr.RunWithReport(migrations, MigrationsDirectionUp)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Applied)
+	require.Equal(t, migrations[1].ID(), report.Migrations[0].ID)
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+	_, err = migMngr.ImportLegacyState(MigrationsTableName)
+	require.Error(t, err)
+}
+
+func TestMigrationsManager_MarkApplied(t *testing.T) {
+	for _, interpolate := range []bool{false, true} {
+		dbConn, err := sql.Open("sqlite3", "file::memory:")
+		require.NoError(t, err)
+		dbConn.SetMaxOpenConns(1)
+
+		migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(),
+			MigrationsManagerOpts{InterpolateQueries: interpolate})
+		require.NoError(t, err)
+		migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+		require.NoError(t, migMngr.RunLimit(migrations, MigrationsDirectionUp, 1))
+		migStatus, err := migMngr.Status()
+		require.NoError(t, err)
+		firstAppliedAt := migStatus.AppliedMigrations[0].AppliedAt
+
+		// More migrations than fit into a single INSERT statement.
+		for i := 3; i <= 1200; i++ {
+			migrations = append(migrations, NewCustomMigration(fmt.Sprintf("%05d_historical", i), []string{"DROP TABLE users"}, nil, nil, nil))
+		}
+		recorded, err := migMngr.MarkApplied(migrations)
+		require.NoError(t, err)
+		require.Equal(t, 1199, recorded)
+
+		// Already recorded migrations are skipped.
+		recorded, err = migMngr.MarkApplied(migrations)
+		require.NoError(t, err)
+		require.Zero(t, recorded)
+
+		migStatus, err = migMngr.Status()
+		require.NoError(t, err)
+		require.Len(t, migStatus.AppliedMigrations, 1200)
+		require.Equal(t, firstAppliedAt, migStatus.AppliedMigrations[0].AppliedAt)
+		require.False(t, migStatus.AppliedMigrations[1].AppliedAt.IsZero())
+
+		// Marked migrations are not executed.
+		report, err := migMngr.RunWithReport(migrations, MigrationsDirectionUp)
+		require.NoError(t, err)
+		require.Zero(t, report.Applied)
+		requireMigrationsApplied(t, dbConn, false, 0, 0)
+
+		_, err = migMngr.MarkApplied([]Migration{NewCustomMigration("", nil, nil, nil, nil)})
+		require.EqualError(t, err, "migration #1 has empty ID")
+		require.NoError(t, dbConn.Close())
+	}
+}
+
+func TestRunAll(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	dbConn.SetMaxOpenConns(1)
+
+	newManager := func(tableName string) *MigrationsManager {
+		migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(),
+			MigrationsManagerOpts{TableName: tableName})
+		require.NoError(t, err)
+		return migMngr
+	}
+	authMigMngr, billingMigMngr := newManager("auth_migrations"), newManager("billing_migrations")
+	// Both modules start numbering from 00001, IDs don't clash since they're tracked separately.
+	authMigrations := []Migration{NewCustomMigration("00001_create_accounts",
+		[]string{"CREATE TABLE accounts (id INTEGER PRIMARY KEY)"}, []string{"DROP TABLE accounts"}, nil, nil)}
+	billingMigrations := []Migration{NewCustomMigration("00001_create_invoices",
+		[]string{"CREATE TABLE invoices (id INTEGER PRIMARY KEY, account_id INTEGER REFERENCES accounts(id))"},
+		[]string{"DROP TABLE invoices"}, nil, nil)}
+	managers := []*MigrationsManager{authMigMngr, billingMigMngr}
+	migrations := [][]Migration{authMigrations, billingMigrations}
+
+	require.NoError(t, RunAll(managers, migrations, MigrationsDirectionUp))
+	for i, mm := range managers {
+		migStatus, err := mm.Status()
+		require.NoError(t, err)
+		require.Len(t, migStatus.AppliedMigrations, 1)
+		require.Equal(t, migrations[i][0].ID(), migStatus.AppliedMigrations[0].ID)
+		require.NoError(t, mm.AssertNoPendingMigrations(migrations[i]))
+	}
+
+	require.NoError(t, RunAll(managers, migrations, MigrationsDirectionDown))
+	var tablesCount int
+	require.NoError(t, dbConn.QueryRow(
+		"SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name IN ('accounts', 'invoices')").Scan(&tablesCount))
+	require.Zero(t, tablesCount)
+
+	// Running stops on the first failure.
+	brokenMigrations := [][]Migration{
+		{NewCustomMigration("00001_broken", []string{"CREATE TABLE"}, nil, nil, nil)},
+		billingMigrations,
+	}
+	err = RunAll(managers, brokenMigrations, MigrationsDirectionUp)
+	require.ErrorContains(t, err, "run db migrations of manager #1 (table auth_migrations)")
+	migStatus, err := billingMigMngr.Status()
+	require.NoError(t, err)
+	require.Empty(t, migStatus.AppliedMigrations)
+
+	err = RunAll([]*MigrationsManager{authMigMngr, newManager("auth_migrations")}, migrations, MigrationsDirectionUp)
+	require.EqualError(t, err, "managers #1 and #2 use the same migrations table auth_migrations")
+	err = RunAll(managers, migrations[:1], MigrationsDirectionUp)
+	require.EqualError(t, err, "number of migration sets (1) doesn't match number of managers (2)")
+}
+
+func TestMigrationsManager_Squash(t *testing.T) {
+	historicalMigrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+	const baselineID = "00003_baseline"
+	// Baseline fails if it's executed on the existing database since tables already exist.
+	baseline := NewCustomMigration(baselineID,
+		append(append([]string(nil), historicalMigrations[0].UpSQL()...), historicalMigrations[1].UpSQL()...), nil, nil, nil)
+	squashedMigrations := []Migration{
+		NewTombstoneMigration(historicalMigrations[0].ID()), NewTombstoneMigration(historicalMigrations[1].ID()), baseline}
+
+	newManager := func(t *testing.T) (*MigrationsManager, *sql.DB) {
+		t.Helper()
+		dbConn, err := sql.Open("sqlite3", "file::memory:")
+		require.NoError(t, err)
+		t.Cleanup(func() { requireNoErrOnClose(t, dbConn) })
+		dbConn.SetMaxOpenConns(1)
+		migMngr, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+		require.NoError(t, err)
+		return migMngr, dbConn
+	}
+	requireSquashed := func(t *testing.T, migMngr *MigrationsManager, dbConn *sql.DB) {
+		t.Helper()
+		migStatus, err := migMngr.Status()
+		require.NoError(t, err)
+		require.Len(t, migStatus.AppliedMigrations, 3)
+		report, err := migMngr.RunWithReport(squashedMigrations, MigrationsDirectionUp)
+		require.NoError(t, err)
+		require.Zero(t, report.Applied)
+		requireMigrationsApplied(t, dbConn, false, 5, 2)
+	}
+
+	t.Run("existing database", func(t *testing.T) {
+		migMngr, dbConn := newManager(t)
+		require.NoError(t, migMngr.Run(historicalMigrations, MigrationsDirectionUp))
+		require.NoError(t, migMngr.Squash(squashedMigrations, baselineID))
+		requireSquashed(t, migMngr, dbConn)
+		require.NoError(t, migMngr.Squash(squashedMigrations, baselineID))
+	})
+
+	t.Run("fresh database", func(t *testing.T) {
+		migMngr, dbConn := newManager(t)
+		require.NoError(t, migMngr.Squash(squashedMigrations, baselineID))
+		requireSquashed(t, migMngr, dbConn)
+		require.NoError(t, migMngr.Squash(squashedMigrations, baselineID))
+	})
+
+	t.Run("partially migrated database", func(t *testing.T) {
+		migMngr, _ := newManager(t)
+		require.NoError(t, migMngr.RunLimit(historicalMigrations, MigrationsDirectionUp, 1))
+		err := migMngr.Squash(squashedMigrations, baselineID)
+		require.ErrorIs(t, err, ErrPendingMigrations)
+		require.ErrorContains(t, err, historicalMigrations[1].ID())
+	})
+
+	t.Run("baseline is not found", func(t *testing.T) {
+		migMngr, _ := newManager(t)
+		err := migMngr.Squash(squashedMigrations[:2], baselineID)
+		require.EqualError(t, err, "baseline migration 00003_baseline is not found in the passed migrations")
+	})
+}
+
+func TestMixesDDLAndDML(t *testing.T) {
+	require.True(t, mixesDDLAndDML([]string{
+		"CREATE TABLE users (id INT)",
+		"-- seed users\n/* first user */ insert INTO users VALUES (1)",
+	}))
+	require.False(t, mixesDDLAndDML([]string{"CREATE TABLE users (id INT)", "ALTER TABLE users ADD name TEXT"}))
+	require.False(t, mixesDDLAndDML([]string{"INSERT INTO users VALUES (1)", "UPDATE users SET id = 2"}))
+	require.False(t, mixesDDLAndDML([]string{"/* CREATE TABLE users */ SELECT 1", "DELETE FROM users"}))
+	require.Equal(t, "ALTER", firstKeyword("  # comment\n\tALTER TABLE users ADD name TEXT"))
+	require.Equal(t, "", firstKeyword("/* unterminated comment"))
+}
+
+func TestFindDuplicateNumericPrefixes(t *testing.T) {
+	newMigrations := func(ids ...string) []Migration {
+		migrations := make([]Migration, 0, len(ids))
+		for _, id := range ids {
+			migrations = append(migrations, NewCustomMigration(id, nil, nil, nil, nil))
+		}
+		return migrations
+	}
+
+	require.Empty(t, FindDuplicateNumericPrefixes(newMigrations("0001_init", "0002_users", "add_notes", "add_tags")))
+	require.Equal(t, [][]string{{"0003_bar", "0003_foo", "3_baz"}, {"0005_a", "05_b"}},
+		FindDuplicateNumericPrefixes(newMigrations("0005_a", "0003_foo", "0001_init", "0003_bar", "05_b", "3_baz")))
+}
+
+func TestMigrationsManager_DuplicatePrefixMode(t *testing.T) {
+	migrations := []Migration{
+		NewCustomMigration("0001_create_foo", []string{"CREATE TABLE foo (id INTEGER)"}, []string{"DROP TABLE foo"}, nil, nil),
+		NewCustomMigration("0001_create_bar", []string{"CREATE TABLE bar (id INTEGER)"}, []string{"DROP TABLE bar"}, nil, nil),
+	}
+	const wantWarnMsg = "db migrations 0001_create_bar, 0001_create_foo have the same numeric prefix, " +
+		"it's likely a numbering conflict that should be resolved"
+
+	tests := []struct {
+		name        string
+		mode        DuplicatePrefixMode
+		wantErrMsg  string
+		wantWarning bool
+	}{
+		{name: "warning is logged by default", wantWarning: true},
+		{name: "warn", mode: DuplicatePrefixModeWarn, wantWarning: true},
+		{name: "error", mode: DuplicatePrefixModeError, wantErrMsg: "migrations 0001_create_bar, 0001_create_foo have the same numeric prefix"},
+		{name: "ignore", mode: DuplicatePrefixModeIgnore},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbConn, err := sql.Open("sqlite3", "file::memory:")
+			require.NoError(t, err)
+			defer requireNoErrOnClose(t, dbConn)
+			dbConn.SetMaxOpenConns(1)
+
+			logRecorder := logtest.NewRecorder()
+			migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logRecorder,
+				MigrationsManagerOpts{DuplicatePrefixMode: tt.mode})
+			require.NoError(t, err)
+
+			err = migMngr.Run(migrations, MigrationsDirectionUp)
+			if tt.wantErrMsg != "" {
+				require.EqualError(t, err, tt.wantErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			_, found := logRecorder.FindEntry(wantWarnMsg)
+			require.Equal(t, tt.wantWarning, found)
+		})
+	}
+
+	_, err := NewMigrationsManagerWithOpts(nil, dbkit.DialectSQLite, logtest.NewLogger(),
+		MigrationsManagerOpts{DuplicatePrefixMode: "strict"})
+	require.EqualError(t, err, `unknown duplicate prefix mode "strict"`)
+}
+
+type testCtxKey struct{}
+
+type testRunLocker struct {
+	calls    int
+	err      error
+	lockLost bool
+	ctx      context.Context
+}
+
+func (l *testRunLocker) DoExclusively(ctx context.Context, fn func(ctx context.Context) error) error {
+	l.calls++
+	l.ctx = ctx
+	if l.err != nil {
+		return l.err
+	}
+	if l.lockLost {
+		lockCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		return fn(lockCtx)
+	}
+	return fn(ctx)
+}
+
+func TestOpenAndMigrate(t *testing.T) {
+	cfg := &dbkit.Config{Dialect: dbkit.DialectSQLite, SQLite: dbkit.SQLiteConfig{Path: filepath.Join(t.TempDir(), "test.db")}}
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+
+	locker := &testRunLocker{}
+	var lockerDB *sql.DB
+	ctx := context.WithValue(context.Background(), testCtxKey{}, "open")
+	dbConn, err := OpenAndMigrate(ctx, cfg, migrations, logtest.NewLogger(), OpenAndMigrateOpts{
+		NewRunLocker: func(db *sql.DB) RunLocker {
+			lockerDB = db
+			return locker
+		},
+	})
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	require.Equal(t, 1, locker.calls)
+	require.Same(t, dbConn, lockerDB)
+	require.Equal(t, "open", locker.ctx.Value(testCtxKey{}))
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+	failedMigration := NewCustomMigration("00003_invalid", []string{"INSERT INTO unknown_table VALUES (1)"}, nil, nil, nil)
+	failedDB, err := OpenAndMigrate(context.Background(), cfg, append(migrations, failedMigration), logtest.NewLogger(),
+		OpenAndMigrateOpts{})
+	require.ErrorContains(t, err, "apply migrations: ")
+	require.Nil(t, failedDB)
+
+	_, err = OpenAndMigrate(context.Background(), &dbkit.Config{Dialect: "unknown"}, migrations, logtest.NewLogger(),
+		OpenAndMigrateOpts{})
+	require.ErrorContains(t, err, "open database: ")
+}
+
+func TestMigrationsManager_RunLocker(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	dbConn.SetMaxOpenConns(1)
+
+	locker := &testRunLocker{}
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(), MigrationsManagerOpts{RunLocker: locker})
+	require.NoError(t, err)
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	require.Equal(t, 1, locker.calls)
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+	// Migrations are not rolled back if the lock is not acquired.
+	locker.err = errors.New("lock is not acquired")
+	require.ErrorIs(t, migMngr.Run(migrations, MigrationsDirectionDown), locker.err)
+	require.Equal(t, 2, locker.calls)
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+}
+
+func TestMigrationsManager_RunContext(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	dbConn.SetMaxOpenConns(1)
+
+	locker := &testRunLocker{}
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(), MigrationsManagerOpts{RunLocker: locker})
+	require.NoError(t, err)
+	migrations := []Migration{newTestMigration00001CreateTables(), newTestMigration00002SeedTabled()}
+
+	// Migrations are not applied after the lock is lost.
+	locker.lockLost = true
+	err = migMngr.RunContext(context.Background(), migrations, MigrationsDirectionUp)
+	require.ErrorIs(t, err, context.Canceled)
+	requireMigrationsApplied(t, dbConn, true, 0, 0)
+
+	// Context is passed to the locker.
+	ctx := context.WithValue(context.Background(), testCtxKey{}, "run")
+	locker.lockLost = false
+	require.NoError(t, migMngr.RunContext(ctx, migrations, MigrationsDirectionUp))
+	require.Equal(t, "run", locker.ctx.Value(testCtxKey{}))
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+	// Canceled context stops the run without the locker too.
+	migMngr, err = NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	canceledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = migMngr.RunLimitWithReportContext(canceledCtx, migrations, MigrationsDirectionDown, MigrationsNoLimit)
+	require.ErrorIs(t, err, context.Canceled)
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+}
+
+func TestMigrationsManager_VerifyDownChecksums(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", "file::memory:")
+	require.NoError(t, err)
+	defer requireNoErrOnClose(t, dbConn)
+	dbConn.SetMaxOpenConns(1)
+
+	migMngr, err := NewMigrationsManagerWithOpts(dbConn, dbkit.DialectSQLite, logtest.NewLogger(),
+		MigrationsManagerOpts{VerifyDownChecksums: true})
+	require.NoError(t, err)
+	seedMigration := newTestMigration00002SeedTabled()
+	migrations := []Migration{newTestMigration00001CreateTables(), seedMigration}
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionUp))
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+	var storedChecksum string
+	require.NoError(t, dbConn.QueryRow("SELECT checksum FROM migrations"+ChecksumsTableSuffix+" WHERE id = ?",
+		seedMigration.ID()).Scan(&storedChecksum))
+	require.Equal(t, MigrationChecksum(seedMigration), storedChecksum)
+
+	// Migration is changed after applying, so nothing is rolled back.
+	changedSeedMigration := NewCustomMigration(seedMigration.ID(),
+		[]string{`INSERT INTO users(name) VALUES("Albert")`}, seedMigration.DownSQL(), nil, nil)
+	require.NotEqual(t, MigrationChecksum(seedMigration), MigrationChecksum(changedSeedMigration))
+	err = migMngr.Run([]Migration{newTestMigration00001CreateTables(), changedSeedMigration}, MigrationsDirectionDown)
+	require.ErrorIs(t, err, ErrChecksumMismatch)
+	requireMigrationsApplied(t, dbConn, false, 5, 2)
+
+	require.NoError(t, migMngr.Run(migrations, MigrationsDirectionDown))
+	requireMigrationsApplied(t, dbConn, true, 0, 0)
+	var checksumsCount int
+	require.NoError(t, dbConn.QueryRow("SELECT COUNT(*) FROM migrations"+ChecksumsTableSuffix).Scan(&checksumsCount))
+	require.Equal(t, 0, checksumsCount)
+
+	// Migrations applied without checksums are not verified.
+	migMngrNoChecksums, err := NewMigrationsManager(dbConn, dbkit.DialectSQLite, logtest.NewLogger())
+	require.NoError(t, err)
+	require.NoError(t, migMngrNoChecksums.Run(migrations, MigrationsDirectionUp))
+	require.NoError(t, migMngr.Run([]Migration{newTestMigration00001CreateTables(), changedSeedMigration}, MigrationsDirectionDown))
+	requireMigrationsApplied(t, dbConn, true, 0, 0)
+}
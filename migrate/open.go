@@ -0,0 +1,72 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/acronis/go-appkit/log"
+
+	"github.com/acronis/go-dbkit"
+)
+
+// OpenAndMigrateOpts holds the options to be used in OpenAndMigrate.
+type OpenAndMigrateOpts struct {
+	// MigrationsManagerOpts are used for creating MigrationsManager.
+	MigrationsManagerOpts
+
+	// OpenOptions are passed to dbkit.Open.
+	OpenOptions []dbkit.OpenOption
+
+	// NewRunLocker creates RunLocker for the opened database (e.g. distrlock.DBManager.MigrationsRunLocker),
+	// so several instances of the service may be started simultaneously.
+	// It takes precedence over MigrationsManagerOpts.RunLocker.
+	NewRunLocker func(db *sql.DB) RunLocker
+}
+
+// OpenAndMigrate opens the database (see dbkit.Open), checks the connection and applies all pending migrations,
+// so the returned *sql.DB is ready to serve. The database is closed if any step fails.
+// Context is used for checking the connection and bounds the migrations run (see MigrationsManager.RunContext),
+// including the lock acquisition when RunLocker (or NewRunLocker) is set.
+func OpenAndMigrate(
+	ctx context.Context, cfg *dbkit.Config, migrations []Migration, logger log.FieldLogger, opts OpenAndMigrateOpts,
+) (*sql.DB, error) {
+	db, err := dbkit.Open(cfg, false, opts.OpenOptions...)
+	if err != nil {
+		if db != nil {
+			_ = db.Close()
+		}
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	if err = migrateOpenedDB(ctx, db, cfg.Dialect, migrations, logger, opts); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func migrateOpenedDB(
+	ctx context.Context, db *sql.DB, dialect dbkit.Dialect, migrations []Migration, logger log.FieldLogger, opts OpenAndMigrateOpts,
+) error {
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping database: %w", err)
+	}
+	migMngrOpts := opts.MigrationsManagerOpts
+	if opts.NewRunLocker != nil {
+		migMngrOpts.RunLocker = opts.NewRunLocker(db)
+	}
+	migMngr, err := NewMigrationsManagerWithOpts(db, dialect, logger, migMngrOpts)
+	if err != nil {
+		return err
+	}
+	if err = migMngr.RunContext(ctx, migrations, MigrationsDirectionUp); err != nil {
+		return fmt.Errorf("apply migrations: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"sort"
+	"strings"
+)
+
+// DuplicatePrefixMode defines how MigrationsManager handles migrations
+// that have distinct IDs with the same numeric prefix (e.g. "0003_foo" and "0003_bar").
+// Such migrations are sorted adjacently, but usually it's a numbering conflict after branches merging.
+type DuplicatePrefixMode string
+
+// Duplicate numeric prefix handling modes.
+const (
+	DuplicatePrefixModeWarn   DuplicatePrefixMode = "warn"
+	DuplicatePrefixModeError  DuplicatePrefixMode = "error"
+	DuplicatePrefixModeIgnore DuplicatePrefixMode = "ignore"
+)
+
+// FindDuplicateNumericPrefixes returns groups of migration IDs that share the same numeric prefix.
+// Prefixes are compared as numbers, so "0003_foo" and "3_bar" are in the same group.
+// Migrations without numeric prefix are skipped. Groups and IDs inside them are sorted.
+func FindDuplicateNumericPrefixes(migrations []Migration) [][]string {
+	idsByPrefix := make(map[string][]string)
+	for _, m := range migrations {
+		id := m.ID()
+		digitsEnd := strings.IndexFunc(id, func(r rune) bool { return r < '0' || r > '9' })
+		if digitsEnd == -1 {
+			digitsEnd = len(id)
+		}
+		if digitsEnd == 0 {
+			continue
+		}
+		prefix := strings.TrimLeft(id[:digitsEnd], "0")
+		idsByPrefix[prefix] = append(idsByPrefix[prefix], id)
+	}
+
+	var groups [][]string
+	for _, ids := range idsByPrefix {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Strings(ids)
+		groups = append(groups, ids)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i][0] < groups[j][0]
+	})
+	return groups
+}
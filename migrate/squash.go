@@ -0,0 +1,88 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package migrate
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Squash collapses the history of applied migrations into a baseline.
+// Migrations must contain the baseline migration with the newBaselineID ID (it creates the schema consolidated from
+// the squashed migrations, e.g. dumped from the up-to-date database) and the squashed historical migrations.
+// Only IDs of the squashed migrations are used, so they may be replaced with tombstones (see NewTombstoneMigration),
+// and the baseline ID should follow them in the sort order (see MigrationsManagerOpts.SortFunc).
+//   - For a fresh database (no passed migrations are applied), the baseline migration is applied,
+//     and the squashed migrations are recorded as applied.
+//   - For an existing database (all squashed migrations are applied), the baseline migration is recorded as applied
+//     without executing it. Error is returned if only some of the squashed migrations are applied,
+//     since the baseline would skip the pending ones.
+//
+// Squash is idempotent: if it was interrupted, the next call completes the recording.
+// After squashing, the tombstones of the squashed migrations should still be passed to Run along with the baseline,
+// since applied migrations that are unknown for the passed set are an error (unless FilterAppliedMigrations is enabled).
+func (mm *MigrationsManager) Squash(migrations []Migration, newBaselineID string) error {
+	var baseline Migration
+	squashed := make([]Migration, 0, len(migrations))
+	for i, m := range migrations {
+		switch m.ID() {
+		case "":
+			return fmt.Errorf("migration #%d has empty ID", i+1)
+		case newBaselineID:
+			baseline = m
+		default:
+			squashed = append(squashed, m)
+		}
+	}
+	if baseline == nil {
+		return fmt.Errorf("baseline migration %s is not found in the passed migrations", newBaselineID)
+	}
+
+	migStatus, err := mm.Status()
+	if err != nil {
+		return err
+	}
+	applied := make(map[string]struct{}, len(migStatus.AppliedMigrations))
+	for _, appliedMig := range migStatus.AppliedMigrations {
+		applied[appliedMig.ID] = struct{}{}
+	}
+	_, baselineApplied := applied[newBaselineID]
+	var appliedSquashedCount int
+	var pendingSquashedIDs []string
+	for _, m := range squashed {
+		if _, ok := applied[m.ID()]; ok {
+			appliedSquashedCount++
+		} else {
+			pendingSquashedIDs = append(pendingSquashedIDs, m.ID())
+		}
+	}
+
+	switch {
+	case baselineApplied:
+		// Baseline is applied (or recorded) already, recording of the squashed migrations may be not finished.
+	case appliedSquashedCount == 0:
+		// Fresh database.
+		if err = mm.Run([]Migration{baseline}, MigrationsDirectionUp); err != nil {
+			return fmt.Errorf("apply baseline migration %s: %w", newBaselineID, err)
+		}
+	case len(pendingSquashedIDs) == 0:
+		// Existing database with the whole squashed history.
+		if _, err = mm.MarkApplied([]Migration{baseline}); err != nil {
+			return fmt.Errorf("record baseline migration %s: %w", newBaselineID, err)
+		}
+		mm.logger.Info(fmt.Sprintf("db migration %s is recorded as baseline of %d squashed migrations", newBaselineID, len(squashed)))
+		return nil
+	default:
+		return fmt.Errorf("%w: %s (apply them before squashing into baseline %s)",
+			ErrPendingMigrations, strings.Join(pendingSquashedIDs, ", "), newBaselineID)
+	}
+
+	if _, err = mm.MarkApplied(squashed); err != nil {
+		return fmt.Errorf("record squashed migrations: %w", err)
+	}
+	return nil
+}
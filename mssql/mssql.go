@@ -30,6 +30,9 @@ func init() {
 		}
 		return false
 	})
+	dbkit.RegisterIsUndefinedTableFunc(&mssql.Driver{}, func(err error) bool {
+		return CheckMSSQLError(err, ErrCodeInvalidObjectName)
+	})
 }
 
 // ErrCode defines the type for MSSQL error codes.
@@ -40,6 +43,7 @@ const (
 	ErrDeadlock                 ErrCode = 1205
 	ErrCodeUniqueViolation      ErrCode = 2627
 	ErrCodeUniqueIndexViolation ErrCode = 2601
+	ErrCodeInvalidObjectName    ErrCode = 208 // Table (or other object) doesn't exist.
 )
 
 // CheckMSSQLError checks if the passed error relates to MSSQL,
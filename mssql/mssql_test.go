@@ -25,6 +25,11 @@ func TestMSSQLIsRetryable(t *testing.T) {
 	require.True(t, isRetryable(fmt.Errorf("wrapped error: %w", mssql.Error{Number: 1205})))
 }
 
+func TestMSSQLIsUndefinedTable(t *testing.T) {
+	require.True(t, dbkit.IsUndefinedTable(fmt.Errorf("wrapped error: %w", mssql.Error{Number: 208})))
+	require.False(t, dbkit.IsUndefinedTable(mssql.Error{Number: 1205}))
+}
+
 func TestCheckMSSQLError(t *testing.T) {
 	var err error
 	err = mssql.Error{Number: 1205}
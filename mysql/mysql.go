@@ -34,6 +34,19 @@ func init() {
 		}
 		return false
 	})
+	dbkit.RegisterIsConnectionErrorFunc(&mysql.MySQLDriver{}, func(err error) bool {
+		var mySQLError *mysql.MySQLError
+		if errors.As(err, &mySQLError) {
+			switch mySQLError.Number {
+			case uint16(ErrServerShutdown), uint16(ErrAbortingConnection), uint16(ErrConnectionKilled):
+				return true
+			}
+		}
+		return errors.Is(err, mysql.ErrInvalidConn)
+	})
+	dbkit.RegisterIsUndefinedTableFunc(&mysql.MySQLDriver{}, func(err error) bool {
+		return CheckMySQLError(err, ErrCodeNoSuchTable)
+	})
 }
 
 // ErrCode defines the type for MySQL error codes.
@@ -44,6 +57,12 @@ const (
 	ErrCodeDupEntry ErrCode = 1062
 	ErrDeadlock     ErrCode = 1213
 	ErrLockTimedOut ErrCode = 1205
+
+	ErrServerShutdown     ErrCode = 1053
+	ErrAbortingConnection ErrCode = 1152
+	ErrConnectionKilled   ErrCode = 1927
+
+	ErrCodeNoSuchTable ErrCode = 1146
 )
 
 // CheckMySQLError checks if the passed error relates to MySQL,
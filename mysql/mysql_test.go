@@ -46,6 +46,22 @@ func TestMySQLIsRetryable(t *testing.T) {
 	})))
 }
 
+func TestMySQLIsConnectionError(t *testing.T) {
+	require.True(t, dbkit.IsConnectionError(mysql.ErrInvalidConn))
+	require.True(t, dbkit.IsConnectionError(&mysql.MySQLError{Number: uint16(ErrServerShutdown)}))
+	require.True(t, dbkit.IsConnectionError(fmt.Errorf("wrapped error: %w", &mysql.MySQLError{
+		Number: uint16(ErrConnectionKilled),
+	})))
+	require.False(t, dbkit.IsConnectionError(&mysql.MySQLError{Number: uint16(ErrDeadlock)}))
+}
+
+func TestMySQLIsUndefinedTable(t *testing.T) {
+	require.True(t, dbkit.IsUndefinedTable(fmt.Errorf("wrapped error: %w", &mysql.MySQLError{
+		Number: uint16(ErrCodeNoSuchTable), Message: "Table 'db.users' doesn't exist",
+	})))
+	require.False(t, dbkit.IsUndefinedTable(&mysql.MySQLError{Number: uint16(ErrDeadlock)}))
+}
+
 // TestCheckMySQLError covers behavior of CheckMySQLError func.
 func TestCheckMySQLError(t *testing.T) {
 	var deadlockErr ErrCode = 1213
@@ -13,6 +13,7 @@ package pgx
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/jackc/pgx/v5/pgconn"
 	pg "github.com/jackc/pgx/v5/stdlib"
@@ -37,8 +38,29 @@ func init() {
 		}
 		return false
 	})
+	dbkit.RegisterIsConnectionErrorFunc(&pg.Driver{}, func(err error) bool {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			if strings.HasPrefix(pgErr.Code, connectionExceptionErrClass) {
+				return true
+			}
+			switch ErrCode(pgErr.Code) {
+			case ErrCodeAdminShutdown, ErrCodeCrashShutdown, ErrCodeCannotConnectNow:
+				return true
+			}
+			return false
+		}
+		var connectErr *pgconn.ConnectError
+		return errors.As(err, &connectErr)
+	})
+	dbkit.RegisterIsUndefinedTableFunc(&pg.Driver{}, func(err error) bool {
+		return CheckPostgresError(err, ErrCodeUndefinedTable)
+	})
 }
 
+// connectionExceptionErrClass is a class of Postgres error codes (08xxx) that relate to the connection failures.
+const connectionExceptionErrClass = "08"
+
 // ErrCode defines the type for Pgx error codes.
 type ErrCode string
 
@@ -48,6 +70,10 @@ const (
 	ErrCodeDeadlockDetected     ErrCode = "40P01"
 	ErrCodeSerializationFailure ErrCode = "40001"
 	ErrFeatureNotSupported      ErrCode = "0A000"
+	ErrCodeAdminShutdown        ErrCode = "57P01"
+	ErrCodeCrashShutdown        ErrCode = "57P02"
+	ErrCodeCannotConnectNow     ErrCode = "57P03"
+	ErrCodeUndefinedTable       ErrCode = "42P01"
 )
 
 // CheckPostgresError checks if the passed error relates to Postgres,
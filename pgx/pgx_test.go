@@ -42,6 +42,30 @@ func TestPostgresIsRetryable(t *gotesting.T) {
 	require.False(t, isRetryable(driver.ErrBadConn))
 }
 
+func TestPostgresIsConnectionError(t *gotesting.T) {
+	for _, code := range []ErrCode{ErrCodeAdminShutdown, ErrCodeCrashShutdown, ErrCodeCannotConnectNow, "08006"} {
+		require.True(t, dbkit.IsConnectionError(fmt.Errorf("wrapped error: %w", &pgconn.PgError{Code: string(code)})))
+	}
+	require.False(t, dbkit.IsConnectionError(&pgconn.PgError{Code: string(ErrCodeDeadlockDetected)}))
+}
+
+func TestPostgresIsUndefinedTable(t *gotesting.T) {
+	require.True(t, dbkit.IsUndefinedTable(fmt.Errorf("wrapped error: %w", &pgconn.PgError{Code: string(ErrCodeUndefinedTable)})))
+	require.False(t, dbkit.IsUndefinedTable(&pgconn.PgError{Code: "42703"})) // undefined_column
+}
+
+func TestDetectDialect_Pgx(t *gotesting.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	dbConn, stop := testing.MustRunAndOpenTestDB(ctx, string(dbkit.DialectPgx))
+	defer func() { require.NoError(t, stop(ctx)) }()
+
+	dialect, err := dbkit.DetectDialect(ctx, dbConn)
+	require.NoError(t, err)
+	require.Equal(t, dbkit.DialectPgx, dialect)
+}
+
 func TestCheckInvalidCachedPlanError(t *gotesting.T) {
 	ctx, ctxCancel := context.WithTimeout(context.Background(), time.Minute*2)
 	defer ctxCancel()
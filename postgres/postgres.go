@@ -34,8 +34,27 @@ func init() {
 		}
 		return false
 	})
+	dbkit.RegisterIsConnectionErrorFunc(&pq.Driver{}, func(err error) bool {
+		var pgErr *pq.Error
+		if errors.As(err, &pgErr) {
+			if pgErr.Code.Class() == connectionExceptionErrClass {
+				return true
+			}
+			switch ErrCode(pgErr.Code.Name()) {
+			case ErrCodeAdminShutdown, ErrCodeCrashShutdown, ErrCodeCannotConnectNow:
+				return true
+			}
+		}
+		return false
+	})
+	dbkit.RegisterIsUndefinedTableFunc(&pq.Driver{}, func(err error) bool {
+		return CheckPostgresError(err, ErrCodeUndefinedTable)
+	})
 }
 
+// connectionExceptionErrClass is a class of Postgres error codes (08xxx) that relate to the connection failures.
+const connectionExceptionErrClass = "08"
+
 // ErrCode defines the type for Postgres error codes.
 type ErrCode string
 
@@ -44,6 +63,10 @@ const (
 	ErrCodeUniqueViolation      ErrCode = "unique_violation"
 	ErrCodeDeadlockDetected     ErrCode = "deadlock_detected"
 	ErrCodeSerializationFailure ErrCode = "serialization_failure"
+	ErrCodeAdminShutdown        ErrCode = "admin_shutdown"
+	ErrCodeCrashShutdown        ErrCode = "crash_shutdown"
+	ErrCodeCannotConnectNow     ErrCode = "cannot_connect_now"
+	ErrCodeUndefinedTable       ErrCode = "undefined_table"
 )
 
 // CheckPostgresError checks if the passed error relates to Postgres,
@@ -24,3 +24,14 @@ func TestPostgresIsRetryable(t *testing.T) {
 	require.False(t, isRetryable(driver.ErrBadConn))
 	require.True(t, isRetryable(fmt.Errorf("wrapped error: %w", &pg.Error{Code: "40P01"})))
 }
+
+func TestPostgresIsUndefinedTable(t *testing.T) {
+	require.True(t, dbkit.IsUndefinedTable(fmt.Errorf("wrapped error: %w", &pg.Error{Code: "42P01"})))
+	require.False(t, dbkit.IsUndefinedTable(&pg.Error{Code: "42703"})) // undefined_column
+}
+
+func TestPostgresIsConnectionError(t *testing.T) {
+	require.True(t, dbkit.IsConnectionError(&pg.Error{Code: "57P01"}))
+	require.True(t, dbkit.IsConnectionError(fmt.Errorf("wrapped error: %w", &pg.Error{Code: "08006"})))
+	require.False(t, dbkit.IsConnectionError(&pg.Error{Code: "40P01"}))
+}
@@ -9,12 +9,16 @@ package dbkit
 import (
 	"database/sql/driver"
 	"reflect"
+	"sort"
 
 	"github.com/acronis/go-appkit/retry"
 )
 
 var retryableErrors = map[reflect.Type]retry.IsRetryable{}
 
+// retryableDrivers stores drivers for which IsRetryable functions were registered (one driver per type).
+var retryableDrivers = map[reflect.Type]driver.Driver{}
+
 // GetIsRetryable returns a function that can tell for a given driver if error is retryable.
 func GetIsRetryable(d driver.Driver) retry.IsRetryable {
 	t := reflect.TypeOf(d)
@@ -28,6 +32,36 @@ func isRetryableNoDriver(error) bool {
 	return false
 }
 
+// IsRetryableError tells if the error is considered retryable for the given driver by registered IsRetryable functions.
+// It doesn't require any DB connection, so may be used for debugging and testing of the registered functions.
+func IsRetryableError(d driver.Driver, err error) bool {
+	return GetIsRetryable(d)(err)
+}
+
+// RegisteredRetryableDrivers returns drivers for which IsRetryable functions are registered.
+// Drivers are sorted by their type names.
+func RegisteredRetryableDrivers() []driver.Driver {
+	types := make([]reflect.Type, 0, len(retryableDrivers))
+	for t := range retryableDrivers {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return typeName(types[i]) < typeName(types[j])
+	})
+	drivers := make([]driver.Driver, 0, len(types))
+	for _, t := range types {
+		drivers = append(drivers, retryableDrivers[t])
+	}
+	return drivers
+}
+
+func typeName(t reflect.Type) string {
+	if t == nil {
+		return ""
+	}
+	return t.String()
+}
+
 // RegisterIsRetryableFunc registers callback to determinate specific DB error is retryable or not.
 // Several registered functions will be called one after another in FIFO order before some function returns true.
 // Note: this function is not concurrent-safe. Typical scenario: register all custom IsRetryable in module init()
@@ -40,10 +74,12 @@ func RegisterIsRetryableFunc(d driver.Driver, retryable retry.IsRetryable) {
 		}
 		return retryable(e)
 	}
+	retryableDrivers[t] = d
 }
 
 // UnregisterAllIsRetryableFuncs removes previously registered IsRetryable function for the given driver.
 func UnregisterAllIsRetryableFuncs(d driver.Driver) {
 	t := reflect.TypeOf(d)
 	delete(retryableErrors, t)
+	delete(retryableDrivers, t)
 }
@@ -8,12 +8,15 @@ package dbkit
 
 import (
 	"context"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
 
 	"github.com/acronis/go-appkit/retry"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMultipleIsRetryError(t *testing.T) {
@@ -48,3 +51,26 @@ func TestMultipleIsRetryError(t *testing.T) {
 	})
 	assert.Equal(t, "", called)
 }
+
+type testRetryableDriver struct {
+	driver.Driver
+}
+
+func TestRegisteredRetryableDrivers(t *testing.T) {
+	retryableErr := errors.New("retryable error")
+	d := &testRetryableDriver{}
+	require.NotContains(t, RegisteredRetryableDrivers(), driver.Driver(d))
+	require.False(t, IsRetryableError(d, retryableErr))
+
+	RegisterIsRetryableFunc(d, func(e error) bool {
+		return errors.Is(e, retryableErr)
+	})
+	defer UnregisterAllIsRetryableFuncs(d)
+	require.Contains(t, RegisteredRetryableDrivers(), driver.Driver(d))
+	require.True(t, IsRetryableError(d, fmt.Errorf("wrapped: %w", retryableErr)))
+	require.False(t, IsRetryableError(d, errors.New("other error")))
+
+	UnregisterAllIsRetryableFuncs(d)
+	require.NotContains(t, RegisteredRetryableDrivers(), driver.Driver(d))
+	require.False(t, IsRetryableError(d, retryableErr))
+}
@@ -0,0 +1,241 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// OpenRouted opens a database handle that transparently routes read statements to the replica
+// and everything else to the primary database.
+//
+// Each connection in the pool consists of a primary connection and a lazily established replica connection.
+// Statements are routed by the following rules:
+//   - queries starting with SELECT (except SELECT ... FOR UPDATE/SHARE) that are executed outside
+//     of a transaction go to the replica;
+//   - all statements within a transaction and all other statements go to the primary.
+//
+// Caveats that should be taken into account:
+//   - replication lag: data written to the primary may not be visible on the replica immediately,
+//     so reads that must see own writes should be executed within a transaction;
+//   - SELECT statements with side effects (e.g. calling functions that modify data) will fail on the replica;
+//   - routing is based on the statement text only, prepared statements are routed at preparation time;
+//   - each connection in the pool may hold up to 2 physical connections (one per database).
+//
+// Pool parameters (MaxOpenConns, etc.) are taken from the primaryCfg.
+// Both configurations must have the same dialect.
+func OpenRouted(primaryCfg, replicaCfg *Config, ping bool) (*sql.DB, error) {
+	if primaryCfg.Dialect != replicaCfg.Dialect {
+		return nil, fmt.Errorf("primary (%s) and replica (%s) dialects must be the same", primaryCfg.Dialect, replicaCfg.Dialect)
+	}
+	primaryConnector, err := NewConnector(primaryCfg)
+	if err != nil {
+		return nil, fmt.Errorf("make primary connector: %w", err)
+	}
+	replicaConnector, err := NewConnector(replicaCfg)
+	if err != nil {
+		return nil, fmt.Errorf("make replica connector: %w", err)
+	}
+	db := sql.OpenDB(&routedConnector{primary: primaryConnector, replica: replicaConnector})
+	return db, InitOpenedDB(db, primaryCfg, ping)
+}
+
+type routedConnector struct {
+	primary driver.Connector
+	replica driver.Connector
+}
+
+func (c *routedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	primaryConn, err := c.primary.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &routedConn{primary: primaryConn, replicaConnector: c.replica}, nil
+}
+
+// Driver returns the primary driver, so the registered IsRetryable functions keep working.
+func (c *routedConnector) Driver() driver.Driver {
+	return c.primary.Driver()
+}
+
+// routedConn is used by database/sql from a single goroutine at a time, mutex protects only lazy replica connecting
+// and closing that may happen concurrently (e.g. during the pool cleanup).
+type routedConn struct {
+	primary          driver.Conn
+	replicaConnector driver.Connector
+	mu               sync.Mutex
+	replica          driver.Conn
+	inTx             bool
+}
+
+var (
+	_ driver.Conn               = (*routedConn)(nil)
+	_ driver.ConnBeginTx        = (*routedConn)(nil)
+	_ driver.ConnPrepareContext = (*routedConn)(nil)
+	_ driver.ExecerContext      = (*routedConn)(nil)
+	_ driver.QueryerContext     = (*routedConn)(nil)
+	_ driver.Pinger             = (*routedConn)(nil)
+	_ driver.SessionResetter    = (*routedConn)(nil)
+	_ driver.Validator          = (*routedConn)(nil)
+	_ driver.NamedValueChecker  = (*routedConn)(nil)
+)
+
+func (c *routedConn) connFor(ctx context.Context, query string) (driver.Conn, error) {
+	if c.inTx || !isReadOnlyQuery(query) {
+		return c.primary, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.replica == nil {
+		replica, err := c.replicaConnector.Connect(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("connect to replica: %w", err)
+		}
+		c.replica = replica
+	}
+	return c.replica, nil
+}
+
+func (c *routedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *routedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	conn, err := c.connFor(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if preparer, ok := conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return conn.Prepare(query)
+}
+
+func (c *routedConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *routedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	var err error
+	if beginner, ok := c.primary.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.primary.Begin() //nolint:staticcheck // Fallback for drivers that don't implement driver.ConnBeginTx.
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.inTx = true
+	return &routedTx{Tx: tx, conn: c}, nil
+}
+
+func (c *routedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := c.primary.(driver.ExecerContext); ok {
+		return execer.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *routedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	conn, err := c.connFor(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if queryer, ok := conn.(driver.QueryerContext); ok {
+		return queryer.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *routedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.primary.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *routedConn) ResetSession(ctx context.Context) error {
+	for _, conn := range c.conns() {
+		if resetter, ok := conn.(driver.SessionResetter); ok {
+			if err := resetter.ResetSession(ctx); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *routedConn) IsValid() bool {
+	for _, conn := range c.conns() {
+		if validator, ok := conn.(driver.Validator); ok && !validator.IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *routedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.primary.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c *routedConn) Close() error {
+	var firstErr error
+	for _, conn := range c.conns() {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (c *routedConn) conns() []driver.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.replica == nil {
+		return []driver.Conn{c.primary}
+	}
+	return []driver.Conn{c.primary, c.replica}
+}
+
+type routedTx struct {
+	driver.Tx
+	conn *routedConn
+}
+
+func (tx *routedTx) Commit() error {
+	tx.conn.inTx = false
+	return tx.Tx.Commit()
+}
+
+func (tx *routedTx) Rollback() error {
+	tx.conn.inTx = false
+	return tx.Tx.Rollback()
+}
+
+// isReadOnlyQuery returns true if the query may be executed on the replica.
+func isReadOnlyQuery(query string) bool {
+	query = strings.TrimSpace(query)
+	const selectKeyword = "SELECT"
+	if len(query) < len(selectKeyword) || !strings.EqualFold(query[:len(selectKeyword)], selectKeyword) {
+		return false
+	}
+	return !lockingClauseRe.MatchString(query)
+}
+
+// lockingClauseRe matches locking clauses of SELECT (including Postgres FOR NO KEY UPDATE and FOR KEY SHARE),
+// the keywords may be separated by any whitespace (e.g. new lines in multi-line queries).
+var lockingClauseRe = regexp.MustCompile(`(?i)\bFOR\s+(?:NO\s+KEY\s+)?(?:KEY\s+)?(?:UPDATE|SHARE)\b`)
@@ -0,0 +1,77 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenRouted(t *testing.T) {
+	makeCfg := func(path string) *Config {
+		return &Config{Dialect: DialectSQLite, SQLite: SQLiteConfig{Path: path}, MaxOpenConns: 2, MaxIdleConns: 2}
+	}
+	primaryCfg, replicaCfg := makeCfg(t.TempDir()+"/primary.db"), makeCfg(t.TempDir()+"/replica.db")
+	for _, cfg := range []*Config{primaryCfg, replicaCfg} {
+		dbConn, err := Open(cfg, true)
+		require.NoError(t, err)
+		_, err = dbConn.Exec("CREATE TABLE settings (name TEXT)")
+		require.NoError(t, err)
+		_, err = dbConn.Exec("INSERT INTO settings (name) VALUES (?)", cfg.SQLite.Path)
+		require.NoError(t, err)
+		require.NoError(t, dbConn.Close())
+	}
+
+	dbConn, err := OpenRouted(primaryCfg, replicaCfg, true)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	selectName := func(queryer interface {
+		QueryRow(query string, args ...any) *sql.Row
+	}) string {
+		var name string
+		require.NoError(t, queryer.QueryRow("SELECT name FROM settings").Scan(&name))
+		return name
+	}
+
+	// Reads outside of transaction go to the replica.
+	require.Equal(t, replicaCfg.SQLite.Path, selectName(dbConn))
+
+	// Writes go to the primary.
+	_, err = dbConn.Exec("UPDATE settings SET name = 'updated'")
+	require.NoError(t, err)
+	require.Equal(t, replicaCfg.SQLite.Path, selectName(dbConn))
+
+	// All statements within transaction go to the primary.
+	require.NoError(t, DoInTx(context.Background(), dbConn, func(tx *sql.Tx) error {
+		require.Equal(t, "updated", selectName(tx))
+		return nil
+	}))
+
+	// Reads are routed to the replica again after the transaction is finished.
+	require.Equal(t, replicaCfg.SQLite.Path, selectName(dbConn))
+
+	_, err = OpenRouted(primaryCfg, &Config{Dialect: DialectMySQL}, false)
+	require.EqualError(t, err, "primary (sqlite3) and replica (mysql) dialects must be the same")
+}
+
+func TestIsReadOnlyQuery(t *testing.T) {
+	require.True(t, isReadOnlyQuery("SELECT 1"))
+	require.True(t, isReadOnlyQuery("  select * from users"))
+	require.False(t, isReadOnlyQuery("SELECT * FROM users FOR UPDATE"))
+	require.False(t, isReadOnlyQuery("select * from users for share"))
+	require.False(t, isReadOnlyQuery("SELECT *\nFROM users\nWHERE id = 1\nFOR UPDATE"))
+	require.False(t, isReadOnlyQuery("SELECT * FROM users\tFOR\tSHARE SKIP LOCKED"))
+	require.False(t, isReadOnlyQuery("SELECT * FROM users FOR NO KEY UPDATE"))
+	require.False(t, isReadOnlyQuery("UPDATE users SET name = 'foo'"))
+	require.False(t, isReadOnlyQuery("WITH t AS (DELETE FROM users RETURNING *) SELECT * FROM t"))
+	require.False(t, isReadOnlyQuery("SEL"))
+}
@@ -9,10 +9,17 @@ Released under MIT license.
 // To register sqlite as retryable func use side effect import like so:
 //
 //	import _ "github.com/acronis/go-dbkit/sqlite"
+//
+// SQLite has no server-side statement timeout, context is the only way to stop a long-running statement.
+// The driver interrupts the statement (sqlite3_interrupt) when the context passed to ExecContext, QueryContext
+// or QueryRowContext is done, so these methods should be used within dbkit.DoInTx with its context.
+// Statements executed with methods without context (Exec, Query, QueryRow) can't be interrupted:
+// they run until completion even if the context of the transaction is canceled (the rollback waits for them).
 package sqlite
 
 import (
 	"errors"
+	"strings"
 
 	"github.com/mattn/go-sqlite3"
 
@@ -31,6 +38,12 @@ func init() {
 		}
 		return false
 	})
+	dbkit.RegisterIsUndefinedTableFunc(&sqlite3.SQLiteDriver{}, func(err error) bool {
+		// SQLite has no specific error code for the missing table, generic SQLITE_ERROR is returned.
+		var sqliteErr sqlite3.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrError &&
+			strings.HasPrefix(sqliteErr.Error(), "no such table")
+	})
 }
 
 // CheckSQLiteError checks if the passed error relates to SQLite,
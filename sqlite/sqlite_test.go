@@ -140,6 +140,38 @@ func TestSqliteIsRetryable(t *testing.T) {
 	})))
 }
 
+func TestSqliteInterruptOnContextDone(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	startedAt := time.Now()
+	err = dbkit.DoInTx(ctx, dbConn, func(tx *sql.Tx) error {
+		// Infinite query that is stopped only by the interruption.
+		_, execErr := tx.ExecContext(ctx,
+			"CREATE TABLE numbers AS WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT x FROM c")
+		return execErr
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(startedAt), 5*time.Second)
+}
+
+func TestSqliteIsUndefinedTable(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	_, err = dbConn.Exec("SELECT * FROM foo")
+	require.True(t, dbkit.IsUndefinedTable(fmt.Errorf("wrapped error: %w", err)))
+	_, err = dbConn.Exec(createFooTable)
+	require.NoError(t, err)
+	_, err = dbConn.Exec("SELECT unknown FROM foo")
+	require.Error(t, err)
+	require.False(t, dbkit.IsUndefinedTable(err))
+}
+
 func TestCheckSQLiteError(t *testing.T) {
 	err := sqlite3.Error{
 		Code:         sqlite3.ErrIoErr,
@@ -0,0 +1,69 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTLSRequired is returned by Open if Config.RequireTLS is set, but the connection is not configured to use TLS.
+var ErrTLSRequired = errors.New("tls is required")
+
+// mssqlEncryptModes contains values of the MSSQL encrypt parameter that enable encryption for the whole connection.
+var mssqlEncryptModes = map[string]struct{}{"true": {}, "yes": {}, "mandatory": {}, "strict": {}}
+
+// ValidateTLS checks that the connection configured for the dialect is encrypted:
+//   - Postgres sslmode must be verify-ca or verify-full;
+//   - MySQL tls parameter must be "true" or a name of the custom TLS config
+//     ("false", "skip-verify" and "preferred" are rejected);
+//   - MSSQL encrypt parameter must be on ("true", "yes", "mandatory" or "strict");
+//   - SQLite is always allowed since it works with local files.
+//
+// Returned error wraps ErrTLSRequired.
+func (c *Config) ValidateTLS() error {
+	switch c.Dialect {
+	case DialectPostgres, DialectPgx:
+		sslMode := c.Postgres.SSLMode
+		if sslMode == "" {
+			sslMode = PostgresDefaultSSLMode
+		}
+		if sslMode != PostgresSSLModeVerifyCA && sslMode != PostgresSSLModeVerifyFull {
+			return fmt.Errorf("%w: postgres sslmode is %q, %q or %q is expected",
+				ErrTLSRequired, sslMode, PostgresSSLModeVerifyCA, PostgresSSLModeVerifyFull)
+		}
+	case DialectMySQL:
+		switch strings.ToLower(c.MySQL.TLS) {
+		case "", "false", "skip-verify", "preferred":
+			return fmt.Errorf("%w: mysql tls parameter is %q, \"true\" or custom TLS config name is expected",
+				ErrTLSRequired, c.MySQL.TLS)
+		}
+	case DialectMSSQL:
+		encrypt := ""
+		for k, v := range c.MSSQL.AdditionalParameters {
+			if strings.EqualFold(k, "encrypt") {
+				encrypt = v
+			}
+		}
+		if _, ok := mssqlEncryptModes[strings.ToLower(encrypt)]; !ok {
+			return fmt.Errorf("%w: mssql encrypt parameter is %q, encryption must be on", ErrTLSRequired, encrypt)
+		}
+	case DialectSQLite:
+		// No network connection is used.
+	default:
+		return fmt.Errorf("%w: unknown dialect %q", ErrTLSRequired, c.Dialect)
+	}
+	return nil
+}
+
+func (c *Config) validateRequiredTLS() error {
+	if !c.RequireTLS {
+		return nil
+	}
+	return c.ValidateTLS()
+}
@@ -0,0 +1,90 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_ValidateTLS(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        *Config
+		wantErrMsg string
+	}{
+		{
+			name: "postgres, verify-full",
+			cfg:  &Config{Dialect: DialectPostgres, Postgres: PostgresConfig{SSLMode: PostgresSSLModeVerifyFull}},
+		},
+		{
+			name: "pgx, default sslmode",
+			cfg:  &Config{Dialect: DialectPgx},
+		},
+		{
+			name:       "postgres, require",
+			cfg:        &Config{Dialect: DialectPostgres, Postgres: PostgresConfig{SSLMode: PostgresSSLModeRequire}},
+			wantErrMsg: `tls is required: postgres sslmode is "require", "verify-ca" or "verify-full" is expected`,
+		},
+		{
+			name: "mysql, tls is enabled",
+			cfg:  &Config{Dialect: DialectMySQL, MySQL: MySQLConfig{TLS: "true"}},
+		},
+		{
+			name: "mysql, custom tls config",
+			cfg:  &Config{Dialect: DialectMySQL, MySQL: MySQLConfig{TLS: "custom"}},
+		},
+		{
+			name:       "mysql, tls is not set",
+			cfg:        &Config{Dialect: DialectMySQL},
+			wantErrMsg: `tls is required: mysql tls parameter is "", "true" or custom TLS config name is expected`,
+		},
+		{
+			name:       "mysql, skip-verify",
+			cfg:        &Config{Dialect: DialectMySQL, MySQL: MySQLConfig{TLS: "skip-verify"}},
+			wantErrMsg: `tls is required: mysql tls parameter is "skip-verify", "true" or custom TLS config name is expected`,
+		},
+		{
+			name: "mssql, encrypt is on",
+			cfg:  &Config{Dialect: DialectMSSQL, MSSQL: MSSQLConfig{AdditionalParameters: map[string]string{"Encrypt": "true"}}},
+		},
+		{
+			name:       "mssql, encrypt is disabled",
+			cfg:        &Config{Dialect: DialectMSSQL, MSSQL: MSSQLConfig{AdditionalParameters: map[string]string{"encrypt": "disable"}}},
+			wantErrMsg: `tls is required: mssql encrypt parameter is "disable", encryption must be on`,
+		},
+		{
+			name: "sqlite",
+			cfg:  &Config{Dialect: DialectSQLite},
+		},
+	}
+	for i := range tests {
+		tt := tests[i]
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.ValidateTLS()
+			if tt.wantErrMsg == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrTLSRequired)
+			require.EqualError(t, err, tt.wantErrMsg)
+		})
+	}
+}
+
+func TestOpen_RequireTLS(t *testing.T) {
+	cfg := &Config{Dialect: DialectPostgres, Postgres: PostgresConfig{SSLMode: PostgresSSLModeDisable}, RequireTLS: true}
+	_, err := Open(cfg, false)
+	require.ErrorIs(t, err, ErrTLSRequired)
+
+	// SQLite works with local files, so it's always allowed.
+	cfg = &Config{Dialect: DialectSQLite, SQLite: SQLiteConfig{Path: ":memory:"}, RequireTLS: true}
+	db, err := Open(cfg, true)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
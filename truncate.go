@@ -0,0 +1,195 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// TruncateAllExcludedTables contains names of the tables that are never truncated by TruncateAll.
+// They are the default names of the tables that are used by migrate and distrlock packages.
+var TruncateAllExcludedTables = []string{"migrations", "distributed_locks"}
+
+// TruncateAll removes all rows from all user tables in the current database (schema),
+// except the ones listed in TruncateAllExcludedTables and exclude.
+// Foreign key checks are handled per dialect, so tables may be truncated in any order:
+//   - Postgres: all tables are truncated by a single TRUNCATE statement (identity sequences are restarted);
+//   - MySQL: FOREIGN_KEY_CHECKS is disabled during truncation and its previous value is restored afterward;
+//   - SQLite: foreign_keys pragma is disabled during deletion and its previous value is restored afterward;
+//   - MSSQL: constraints are disabled during deletion and re-enabled (with validation) afterward.
+//
+// An excluded table must not reference the truncated ones. For Postgres, it's checked and an error is returned,
+// since CASCADE is not used (it would empty the excluded table). For MySQL and SQLite, rows of the excluded table
+// would reference deleted rows, and for MSSQL re-enabling of the constraints would fail.
+//
+// It's intended to be used in integration tests for a fast reset of the database state between test cases.
+func TruncateAll(ctx context.Context, db *sql.DB, dialect Dialect, exclude ...string) error {
+	conn, err := db.Conn(ctx) // Session settings (like FOREIGN_KEY_CHECKS) must be applied to the same connection.
+	if err != nil {
+		return fmt.Errorf("get connection: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	tables, err := listTablesForTruncation(ctx, conn, dialect, exclude)
+	if err != nil {
+		return fmt.Errorf("list tables: %w", err)
+	}
+	if len(tables) == 0 {
+		return nil
+	}
+
+	switch dialect {
+	case DialectPostgres, DialectPgx:
+		if err = checkExcludedReferencingTables(ctx, conn, tables); err != nil {
+			return err
+		}
+		quoted := make([]string, 0, len(tables))
+		for _, table := range tables {
+			quoted = append(quoted, quoteIdentifier(dialect, table))
+		}
+		_, err = conn.ExecContext(ctx, "TRUNCATE TABLE "+strings.Join(quoted, ", ")+" RESTART IDENTITY")
+		return err
+	case DialectMySQL:
+		return execWithSessionSetting(ctx, conn, "SELECT @@SESSION.FOREIGN_KEY_CHECKS", "SET FOREIGN_KEY_CHECKS = %d",
+			tables, func(table string) string { return "TRUNCATE TABLE " + quoteIdentifier(dialect, table) })
+	case DialectSQLite:
+		return execWithSessionSetting(ctx, conn, "PRAGMA foreign_keys", "PRAGMA foreign_keys = %d",
+			tables, func(table string) string { return "DELETE FROM " + quoteIdentifier(dialect, table) })
+	case DialectMSSQL:
+		for _, table := range tables {
+			if _, err = conn.ExecContext(ctx, "ALTER TABLE "+quoteIdentifier(dialect, table)+" NOCHECK CONSTRAINT ALL"); err != nil {
+				return err
+			}
+		}
+		for _, table := range tables {
+			if _, err = conn.ExecContext(ctx, "DELETE FROM "+quoteIdentifier(dialect, table)); err != nil {
+				return err
+			}
+		}
+		for _, table := range tables {
+			if _, err = conn.ExecContext(ctx, "ALTER TABLE "+quoteIdentifier(dialect, table)+" WITH CHECK CHECK CONSTRAINT ALL"); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported sql dialect %q", dialect)
+	}
+}
+
+// execWithSessionSetting executes queries for the tables with disabled foreign key checks (the setting is set to 0).
+// The previous value of the setting is read with getQuery and restored afterward, so checks are not enabled
+// on connections that had them disabled.
+func execWithSessionSetting(
+	ctx context.Context, conn *sql.Conn, getQuery, setQueryFormat string, tables []string, makeQuery func(table string) string,
+) (err error) {
+	var prevValue int
+	if err = conn.QueryRowContext(ctx, getQuery).Scan(&prevValue); err != nil {
+		return err
+	}
+	if _, err = conn.ExecContext(ctx, fmt.Sprintf(setQueryFormat, 0)); err != nil {
+		return err
+	}
+	defer func() {
+		if _, restoreErr := conn.ExecContext(ctx, fmt.Sprintf(setQueryFormat, prevValue)); restoreErr != nil && err == nil {
+			err = restoreErr
+		}
+	}()
+	for _, table := range tables {
+		if _, err = conn.ExecContext(ctx, makeQuery(table)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkExcludedReferencingTables returns an error if some excluded table references one of the truncated tables.
+// TRUNCATE fails in this case, while CASCADE would empty the excluded table as well.
+func checkExcludedReferencingTables(ctx context.Context, conn *sql.Conn, tables []string) error {
+	truncated := make(map[string]struct{}, len(tables))
+	for _, table := range tables {
+		truncated[table] = struct{}{}
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT src.relname, dst.relname FROM pg_constraint c "+
+		"JOIN pg_class src ON src.oid = c.conrelid JOIN pg_class dst ON dst.oid = c.confrelid "+
+		"JOIN pg_namespace n ON n.oid = src.relnamespace WHERE c.contype = 'f' AND n.nspname = current_schema()")
+	if err != nil {
+		return fmt.Errorf("list foreign keys: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var referencing, referenced string
+		if err = rows.Scan(&referencing, &referenced); err != nil {
+			return fmt.Errorf("list foreign keys: %w", err)
+		}
+		_, referencingTruncated := truncated[referencing]
+		_, referencedTruncated := truncated[referenced]
+		if !referencingTruncated && referencedTruncated {
+			return fmt.Errorf("excluded table %s references table %s that is truncated, exclude both tables or none of them",
+				referencing, referenced)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("list foreign keys: %w", err)
+	}
+	return nil
+}
+
+func listTablesForTruncation(ctx context.Context, conn *sql.Conn, dialect Dialect, exclude []string) ([]string, error) {
+	var query string
+	switch dialect {
+	case DialectPostgres, DialectPgx:
+		query = "SELECT tablename FROM pg_tables WHERE schemaname = current_schema()"
+	case DialectMySQL:
+		query = "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE'"
+	case DialectSQLite:
+		query = "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'"
+	case DialectMSSQL:
+		query = "SELECT TABLE_NAME FROM INFORMATION_SCHEMA.TABLES WHERE TABLE_TYPE = 'BASE TABLE' AND TABLE_SCHEMA = SCHEMA_NAME()"
+	default:
+		return nil, fmt.Errorf("unsupported sql dialect %q", dialect)
+	}
+
+	excluded := make(map[string]struct{}, len(TruncateAllExcludedTables)+len(exclude))
+	for _, table := range TruncateAllExcludedTables {
+		excluded[table] = struct{}{}
+	}
+	for _, table := range exclude {
+		excluded[table] = struct{}{}
+	}
+
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err = rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		if _, ok := excluded[table]; !ok {
+			tables = append(tables, table)
+		}
+	}
+	return tables, rows.Err()
+}
+
+func quoteIdentifier(dialect Dialect, name string) string {
+	switch dialect {
+	case DialectMySQL:
+		return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+	case DialectMSSQL:
+		return "[" + strings.ReplaceAll(name, "]", "]]") + "]"
+	default:
+		return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTruncateAll_SQLite(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", t.TempDir()+"/test.db?_foreign_keys=on")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	for _, query := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT)",
+		"CREATE TABLE notes (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL REFERENCES users(id))",
+		"CREATE TABLE settings (name TEXT)",
+		"CREATE TABLE migrations (id TEXT)",
+		"INSERT INTO users (id, name) VALUES (1, 'Alice'), (2, 'Bob')",
+		"INSERT INTO notes (id, user_id) VALUES (1, 1), (2, 2)",
+		"INSERT INTO settings (name) VALUES ('foo')",
+		"INSERT INTO migrations (id) VALUES ('0001')",
+	} {
+		_, err = dbConn.Exec(query)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, TruncateAll(context.Background(), dbConn, DialectSQLite, "settings"))
+
+	requireRowsCount := func(table string, want int) {
+		t.Helper()
+		var count int
+		require.NoError(t, dbConn.QueryRow("SELECT count(*) FROM "+table).Scan(&count))
+		require.Equal(t, want, count)
+	}
+	requireRowsCount("users", 0)
+	requireRowsCount("notes", 0)
+	requireRowsCount("settings", 1)
+	requireRowsCount("migrations", 1)
+
+	// Foreign key checks should be restored.
+	_, err = dbConn.Exec("INSERT INTO notes (id, user_id) VALUES (1, 100)")
+	require.Error(t, err)
+}
+
+func TestTruncateAll_SQLite_ForeignKeysDisabled(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", t.TempDir()+"/test.db?_foreign_keys=off")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+	dbConn.SetMaxOpenConns(1)
+
+	for _, query := range []string{
+		"CREATE TABLE users (id INTEGER PRIMARY KEY)",
+		"CREATE TABLE notes (id INTEGER PRIMARY KEY, user_id INTEGER NOT NULL REFERENCES users(id))",
+		"INSERT INTO users (id) VALUES (1)",
+	} {
+		_, err = dbConn.Exec(query)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, TruncateAll(context.Background(), dbConn, DialectSQLite))
+
+	// Foreign key checks should stay disabled.
+	var foreignKeys int
+	require.NoError(t, dbConn.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys))
+	require.Equal(t, 0, foreignKeys)
+	_, err = dbConn.Exec("INSERT INTO notes (id, user_id) VALUES (1, 100)")
+	require.NoError(t, err)
+}
+
+func TestTruncateAll_PostgresExcludedReferencingTable(t *testing.T) {
+	const listTablesQuery = "SELECT tablename FROM pg_tables WHERE schemaname = current_schema()"
+	const listForeignKeysQuery = "SELECT src.relname, dst.relname FROM pg_constraint c " +
+		"JOIN pg_class src ON src.oid = c.conrelid JOIN pg_class dst ON dst.oid = c.confrelid " +
+		"JOIN pg_namespace n ON n.oid = src.relnamespace WHERE c.contype = 'f' AND n.nspname = current_schema()"
+
+	tests := []struct {
+		name    string
+		exclude []string
+		wantErr string
+	}{
+		{
+			name:    "referencing table is excluded",
+			exclude: []string{"notes"},
+			wantErr: "excluded table notes references table users that is truncated, exclude both tables or none of them",
+		},
+		{
+			name:    "referenced table is excluded",
+			exclude: []string{"users"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbConn, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+			require.NoError(t, err)
+			defer func() { _ = dbConn.Close() }()
+
+			mock.ExpectQuery(listTablesQuery).WillReturnRows(
+				sqlmock.NewRows([]string{"tablename"}).AddRow("users").AddRow("notes").AddRow("settings"))
+			mock.ExpectQuery(listForeignKeysQuery).WillReturnRows(
+				sqlmock.NewRows([]string{"src", "dst"}).AddRow("notes", "users"))
+			if tt.wantErr == "" {
+				mock.ExpectExec(`TRUNCATE TABLE "notes", "settings" RESTART IDENTITY`).WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+
+			err = TruncateAll(context.Background(), dbConn, DialectPostgres, tt.exclude...)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTruncateAll_UnsupportedDialect(t *testing.T) {
+	dbConn, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, dbConn.Close()) }()
+
+	require.EqualError(t, TruncateAll(context.Background(), dbConn, "unknown"), `list tables: unsupported sql dialect "unknown"`)
+}
@@ -0,0 +1,36 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import "database/sql/driver"
+
+// undefinedTableFuncs stores functions registered by RegisterIsUndefinedTableFunc.
+var undefinedTableFuncs = newDriverErrorFuncs()
+
+// IsUndefinedTable tells if the error means that the queried table (relation) doesn't exist,
+// e.g. the feature's table is not migrated yet, so the caller may create it or degrade gracefully.
+// Driver specific errors are recognized by the functions registered with RegisterIsUndefinedTableFunc
+// (e.g. by importing github.com/acronis/go-dbkit/postgres).
+func IsUndefinedTable(err error) bool {
+	if err == nil {
+		return false
+	}
+	return undefinedTableFuncs.match(err)
+}
+
+// RegisterIsUndefinedTableFunc registers callback to determine whether specific DB error means undefined table
+// (see IsUndefinedTable). Several registered functions for the same driver will be called one after another
+// in FIFO order before some function returns true.
+// Note: this function is not concurrent-safe. Typical scenario: register all custom functions in module init()
+func RegisterIsUndefinedTableFunc(d driver.Driver, isUndefinedTable func(err error) bool) {
+	undefinedTableFuncs.register(d, isUndefinedTable)
+}
+
+// UnregisterAllIsUndefinedTableFuncs removes previously registered undefined table functions for the given driver.
+func UnregisterAllIsUndefinedTableFuncs(d driver.Driver) {
+	undefinedTableFuncs.unregisterAll(d)
+}
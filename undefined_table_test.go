@@ -0,0 +1,33 @@
+/*
+Copyright © 2024 Acronis International GmbH.
+
+Released under MIT license.
+*/
+
+package dbkit
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsUndefinedTable(t *testing.T) {
+	require.False(t, IsUndefinedTable(nil))
+
+	errNoTable := errors.New("no table")
+	drv := &connectionErrorTestDriver{}
+	RegisterIsUndefinedTableFunc(drv, func(err error) bool {
+		return errors.Is(err, errNoTable)
+	})
+	defer UnregisterAllIsUndefinedTableFuncs(drv)
+	require.True(t, IsUndefinedTable(fmt.Errorf("query: %w", errNoTable)))
+	require.False(t, IsUndefinedTable(errors.New("syntax error")))
+	// Undefined table functions don't affect connection errors.
+	require.False(t, IsConnectionError(errNoTable))
+
+	UnregisterAllIsUndefinedTableFuncs(drv)
+	require.False(t, IsUndefinedTable(errNoTable))
+}